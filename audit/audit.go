@@ -0,0 +1,325 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const exportBatchSize = 1000
+
+// Record appends an entry for the authenticated caller of the current request.
+func Record(c *gin.Context, action, targetType, target string, before, after any) {
+	actorId := ActorID(c)
+
+	var actorEmail string
+	if actorId != nil {
+		actorEmail, _ = db.StoreFrom(c).Users().GetEmail(*actorId)
+	}
+
+	RecordActor(c, actorId, actorEmail, action, targetType, target, before, after)
+}
+
+// RecordActor is Record for requests that are not authenticated yet, such as sign-up.
+func RecordActor(c *gin.Context, actorId *int, actorEmail, action, targetType, target string, before, after any) {
+	add(db.StoreFrom(c), utils.AuditLog{
+		ActorID:    actorId,
+		ActorEmail: actorEmail,
+		IP:         c.ClientIP(),
+		Action:     action,
+		TargetType: targetType,
+		Target:     target,
+		Before:     summarize(before),
+		After:      summarize(after),
+	})
+}
+
+// RecordSystem is Record for background jobs, which act on nobody's request.
+func RecordSystem(store db.Store, action, targetType, target string, before, after any) {
+	add(store, utils.AuditLog{
+		Action:     action,
+		TargetType: targetType,
+		Target:     target,
+		Before:     summarize(before),
+		After:      summarize(after),
+	})
+}
+
+func add(store db.Store, entry utils.AuditLog) {
+	if err := store.Audit().Add(entry); err != nil {
+		log.Printf("audit log error: %s", err)
+	}
+}
+
+func ActorID(c *gin.Context) *int {
+	claims, ok := c.Get("user")
+	if !ok {
+		return nil
+	}
+
+	mapClaims, ok := claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+
+	userId, ok := mapClaims["user_id"].(float64)
+	if !ok {
+		return nil
+	}
+
+	id := int(userId)
+	return &id
+}
+
+// EnvKeys returns the variable names declared in a dotenv string. Values are
+// never written to the audit log.
+func EnvKeys(env string) []string {
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(env, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, _, _ := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func ProjectSummary(p *utils.Project) gin.H {
+	if p == nil {
+		return nil
+	}
+
+	summary := gin.H{
+		"name": p.Name,
+		"slug": p.Slug,
+	}
+	if p.Type != nil {
+		summary["type"] = *p.Type
+	}
+	if p.Status != nil {
+		summary["status"] = *p.Status
+	}
+	if p.ContainerName != nil {
+		summary["container_name"] = *p.ContainerName
+	}
+	if p.CurrentImage != nil {
+		summary["current_image"] = *p.CurrentImage
+	}
+	if p.Env != nil {
+		summary["env_keys"] = EnvKeys(*p.Env)
+	}
+
+	return summary
+}
+
+func summarize(v any) string {
+	if v == nil {
+		return ""
+	}
+
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func GetAuditLogs(c *gin.Context) {
+	filter, err := parseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	logs, err := db.StoreFrom(c).Audit().List(filter)
+	if err != nil {
+		log.Printf("audit log query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"logs": logs,
+		},
+		"meta": gin.H{
+			"limit":  filter.Limit,
+			"offset": filter.Offset,
+		},
+	})
+}
+
+func ExportAuditLogs(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if err := utils.StringValidator("format", format, utils.ValidatorConfig{
+		ExpectedValues: []string{"csv", "json"},
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	filter, err := parseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	// Validate the query before committing to a 200 response.
+	filter.Limit = exportBatchSize
+	first, err := db.StoreFrom(c).Audit().List(filter)
+	if err != nil {
+		log.Printf("audit log export error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	filename := "audit-log-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	var csvWriter *csv.Writer
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+		csvWriter = csv.NewWriter(c.Writer)
+		csvWriter.Write([]string{"id", "created_at", "actor_id", "actor_email", "ip", "action", "target_type", "target", "before", "after"})
+	} else {
+		c.Header("Content-Type", "application/json")
+		c.Writer.WriteString("[")
+	}
+	c.Status(http.StatusOK)
+
+	written := 0
+	batch := first
+	for {
+		for _, l := range batch {
+			if csvWriter != nil {
+				actorId := ""
+				if l.ActorID != nil {
+					actorId = strconv.Itoa(*l.ActorID)
+				}
+				csvWriter.Write([]string{
+					strconv.Itoa(l.ID),
+					l.CreatedAt.UTC().Format(time.RFC3339),
+					actorId,
+					l.ActorEmail,
+					l.IP,
+					l.Action,
+					l.TargetType,
+					l.Target,
+					l.Before,
+					l.After,
+				})
+			} else {
+				data, _ := json.Marshal(l)
+				if written > 0 {
+					c.Writer.WriteString(",")
+				}
+				c.Writer.Write(data)
+			}
+			written++
+		}
+
+		if len(batch) < exportBatchSize {
+			break
+		}
+
+		// Page by id rather than offset, so entries written during the export don't
+		// shift rows into the next batch.
+		filter.Offset = 0
+		filter.BeforeID = batch[len(batch)-1].ID
+		batch, err = db.StoreFrom(c).Audit().List(filter)
+		if err != nil {
+			log.Printf("audit log export error: %s", err)
+			break
+		}
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+	} else {
+		c.Writer.WriteString("]")
+	}
+}
+
+func parseFilter(c *gin.Context) (utils.AuditLogFilter, error) {
+	filter := utils.AuditLogFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		Target:     c.Query("target"),
+		Limit:      50,
+	}
+
+	if actor := c.Query("actor_id"); actor != "" {
+		id, err := strconv.Atoi(actor)
+		if err != nil {
+			return filter, errors.New("`actor_id` must be an integer")
+		}
+		filter.ActorID = &id
+	}
+
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 && limit <= 500 {
+		filter.Limit = limit
+	}
+
+	if offset, err := strconv.Atoi(c.Query("offset")); err == nil && offset > 0 {
+		filter.Offset = offset
+	}
+
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, errors.New("`since` must be an RFC3339 timestamp")
+		}
+		filter.Since = &t
+	}
+
+	if until := c.Query("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, errors.New("`until` must be an RFC3339 timestamp")
+		}
+		filter.Until = &t
+	}
+
+	return filter, nil
+}
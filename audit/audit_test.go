@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"encoding/json"
+	"infracon/db"
+	"infracon/db/dbtest"
+	"infracon/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecordUsesInjectedStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := dbtest.OpenStore(t)
+
+	router := gin.New()
+	router.Use(db.Inject(store))
+	router.POST("/projects/:slug", func(c *gin.Context) {
+		Record(c, "project.create", "project", c.Param("slug"), nil, gin.H{"name": "web"})
+	})
+	router.GET("/audit-logs", GetAuditLogs)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/projects/web", nil))
+	RecordSystem(store, "project.rollback.auto", "project", "web", nil, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/audit-logs?target=web", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var body struct {
+		Data struct {
+			Logs []utils.AuditLog `json:"logs"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	logs := body.Data.Logs
+	if len(logs) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(logs), logs)
+	}
+	// Newest first.
+	if logs[0].Action != "project.rollback.auto" || logs[0].ActorID != nil || logs[0].IP != "" {
+		t.Errorf("system entry: %+v", logs[0])
+	}
+	if logs[1].Action != "project.create" || logs[1].IP == "" {
+		t.Errorf("request entry: %+v", logs[1])
+	}
+}
@@ -1,38 +1,45 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"errors"
+	"fmt"
+	"infracon/audit"
 	"infracon/db"
 	"infracon/utils"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// bcrypt hash of a random string, used to keep sign-in timing uniform for unknown emails.
+const dummyPasswordHash = "$2a$10$9stxgVmM7xQldAZv/ZI4OecbbnSYplgDfaNsd6icqWy3uzMf/GHh."
+
 func SignUp(c *gin.Context) {
-	db, err := db.GetDatabase()
+	users := db.StoreFrom(c).Users()
+
+	exists, err := users.Any()
 	if err != nil {
-		log.Printf("db error: %s", err)
+		log.Printf("query error: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Something went wrong",
 			"status":  false,
 		})
 		return
 	}
-
-	if err := db.QueryRow("SELECT * FROM users").Scan(new(int)); err != nil {
-		log.Println(err)
-		if !errors.Is(err, sql.ErrNoRows) {
-			c.JSON(http.StatusForbidden, gin.H{
-				"message": "Admin user exists already",
-				"status":  false,
-			})
-			return
-		}
+	if exists {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "Admin user exists already",
+			"status":  false,
+		})
+		return
 	}
 
 	var body AuthPayload
@@ -55,14 +62,9 @@ func SignUp(c *gin.Context) {
 		return
 	}
 	body.Password = string(hash)
-	var userId int
-	if err := db.QueryRow(
-		`
-			INSERT INTO users (email, password) VALUES ($1, $2) RETURNING id;	
-		`,
-		body.Email,
-		body.Password,
-	).Scan(&userId); err != nil {
+	body.Email = strings.ToLower(strings.TrimSpace(body.Email))
+	userId, err := users.Create(body.Email, body.Password)
+	if err != nil {
 		log.Printf("insert query error: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Something went wrong",
@@ -71,6 +73,9 @@ func SignUp(c *gin.Context) {
 		return
 	}
 
+	recordEvent(c, EventSignUp, &userId, body.Email, true, "")
+	audit.RecordActor(c, &userId, body.Email, "user.create", "user", strconv.Itoa(userId), nil, gin.H{"email": body.Email})
+
 	token, err := utils.GenerateJwtToken(userId)
 	if err != nil {
 		log.Printf("generating token error: %s", err)
@@ -80,6 +85,7 @@ func SignUp(c *gin.Context) {
 		})
 		return
 	}
+	recordEvent(c, EventTokenCreated, &userId, body.Email, true, "sign-up")
 
 	c.JSON(http.StatusAccepted, gin.H{
 		"message": "Operation successful",
@@ -92,19 +98,6 @@ func SignUp(c *gin.Context) {
 }
 
 func Signin(c *gin.Context) {
-	var userId int
-	var password string
-
-	db, err := db.GetDatabase()
-	if err != nil {
-		log.Printf("db error: %s", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Something went wrong",
-			"status":  false,
-		})
-		return
-	}
-
 	var body AuthPayload
 	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -115,12 +108,22 @@ func Signin(c *gin.Context) {
 		return
 	}
 
-	if err = db.QueryRow(
-		`
-			SELECT id, password FROM users WHERE email = $1	
-		`,
-		body.Email,
-	).Scan(&userId, &password); err != nil {
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	ipKey := "ip:" + c.ClientIP()
+	accountKey := "account:" + email
+
+	if wait := max(ipLimiter.lockedFor(ipKey), accountLimiter.lockedFor(accountKey)); wait > 0 {
+		recordEvent(c, EventSignInLocked, nil, email, false, fmt.Sprintf("locked for %s", wait.Round(time.Second)))
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"message": "Too many failed attempts, try again later",
+			"status":  false,
+		})
+		return
+	}
+
+	userId, password, err := db.StoreFrom(c).Users().GetCredentials(email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		log.Printf("query error: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Something went wrong",
@@ -129,15 +132,32 @@ func Signin(c *gin.Context) {
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(password), []byte(body.Password))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Invalid password",
+	if errors.Is(err, sql.ErrNoRows) {
+		// Compare against a dummy hash so unknown emails cost the same as wrong passwords.
+		password = dummyPasswordHash
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(password), []byte(body.Password)) != nil || errors.Is(err, sql.ErrNoRows) {
+		ipLimiter.fail(ipKey)
+		accountLimiter.fail(accountKey)
+
+		var failedUserId *int
+		if userId > 0 {
+			failedUserId = &userId
+		}
+		recordEvent(c, EventSignInFailed, failedUserId, email, false, "")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid email or password",
 			"status":  false,
 		})
 		return
 	}
 
+	// The IP's failures stand: one valid account must not buy more guesses at others.
+	accountLimiter.reset(accountKey)
+	recordEvent(c, EventSignIn, &userId, email, true, "")
+
 	token, err := utils.GenerateJwtToken(userId)
 	if err != nil {
 		log.Printf("generating token error: %s", err)
@@ -147,6 +167,7 @@ func Signin(c *gin.Context) {
 		})
 		return
 	}
+	recordEvent(c, EventTokenCreated, &userId, email, true, "sign-in")
 
 	c.JSON(http.StatusAccepted, gin.H{
 		"message": "Operation successful",
@@ -178,20 +199,23 @@ func ResetPassword(c *gin.Context) {
 		return
 	}
 
-	if strings.TrimSpace(key) != body.SetupKey {
-		c.JSON(http.StatusBadRequest, gin.H{
+	ipKey := "reset:" + c.ClientIP()
+	if wait := ipLimiter.lockedFor(ipKey); wait > 0 {
+		recordEvent(c, EventPasswordFailed, nil, "", false, "locked")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
 			"status":  false,
-			"message": "Invalid setup key",
+			"message": "Too many failed attempts, try again later",
 		})
 		return
 	}
 
-	db, err := db.GetDatabase()
-	if err != nil {
-		log.Printf("db error: %s", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Something went wrong",
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(key)), []byte(body.SetupKey)) != 1 {
+		ipLimiter.fail(ipKey)
+		recordEvent(c, EventPasswordFailed, nil, "", false, "invalid setup key")
+		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  false,
+			"message": "Invalid setup key",
 		})
 		return
 	}
@@ -207,7 +231,30 @@ func ResetPassword(c *gin.Context) {
 	}
 	body.Password = string(hash)
 
-	if err := db.QueryRow("UPDATE users SET password = $1 RETURNING 1", body.Password).Scan(new(int)); err != nil {
+	users := db.StoreFrom(c).Users()
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	var userId int
+	if email != "" {
+		userId, err = users.GetIdByEmail(email)
+	} else {
+		userId, err = users.FirstID()
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  false,
+			"message": "User not found",
+		})
+		return
+	}
+	if err == nil {
+		if email == "" {
+			email, err = users.GetEmail(userId)
+		}
+	}
+	if err == nil {
+		err = users.SetPassword(userId, body.Password)
+	}
+	if err != nil {
 		log.Printf("query error: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Something went wrong",
@@ -216,6 +263,10 @@ func ResetPassword(c *gin.Context) {
 		return
 	}
 
+	ipLimiter.reset(ipKey)
+	recordEvent(c, EventPasswordReset, &userId, email, true, "setup key")
+	audit.RecordActor(c, nil, "", "user.password_reset", "user", strconv.Itoa(userId), nil, gin.H{"method": "setup_key", "email": email})
+
 	c.JSON(http.StatusAccepted, gin.H{
 		"status":  true,
 		"message": "Operation successful",
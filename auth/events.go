@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	EventSignIn         = "sign_in"
+	EventSignInFailed   = "sign_in_failed"
+	EventSignInLocked   = "sign_in_locked"
+	EventSignUp         = "sign_up"
+	EventPasswordReset  = "password_reset"
+	EventPasswordFailed = "password_reset_failed"
+	EventTokenCreated   = "token_created"
+)
+
+func recordEvent(c *gin.Context, eventType string, userId *int, email string, success bool, details string) {
+	event := utils.AuthEvent{
+		EventType: eventType,
+		UserID:    userId,
+		Email:     email,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Success:   success,
+		Details:   details,
+	}
+
+	if err := db.StoreFrom(c).AuthEvents().Record(event); err != nil {
+		log.Printf("auth event error: %s", err)
+	}
+}
+
+func GetAuthEvents(c *gin.Context) {
+	filter := utils.AuthEventFilter{
+		EventType: c.Query("type"),
+		Email:     c.Query("email"),
+		IP:        c.Query("ip"),
+		Limit:     50,
+	}
+
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 && limit <= 500 {
+		filter.Limit = limit
+	}
+
+	if offset, err := strconv.Atoi(c.Query("offset")); err == nil && offset > 0 {
+		filter.Offset = offset
+	}
+
+	for param, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "`" + param + "` must be an RFC3339 timestamp",
+				"status":  false,
+			})
+			return
+		}
+		*target = &t
+	}
+
+	events, err := db.StoreFrom(c).AuthEvents().List(filter)
+	if err != nil {
+		log.Printf("auth events query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"events": events,
+		},
+		"meta": gin.H{
+			"limit":  filter.Limit,
+			"offset": filter.Offset,
+		},
+	})
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"infracon/telemetry"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func githubOAuthEnabled() bool {
+	return os.Getenv("GITHUB_OAUTH_CLIENT_ID") != "" && os.Getenv("GITHUB_OAUTH_CLIENT_SECRET") != ""
+}
+
+func githubBaseURL() string {
+	if base := os.Getenv("GITHUB_OAUTH_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "https://github.com"
+}
+
+func githubAPIBaseURL() string {
+	if base := os.Getenv("GITHUB_API_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "https://api.github.com"
+}
+
+func githubAuthorizeURL(redirectURI, state string) string {
+	scope := "read:user user:email"
+	if os.Getenv("GITHUB_OAUTH_ALLOWED_ORG") != "" {
+		scope += " read:org"
+	}
+
+	params := url.Values{}
+	params.Set("client_id", os.Getenv("GITHUB_OAUTH_CLIENT_ID"))
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", scope)
+	params.Set("state", state)
+	params.Set("allow_signup", "false")
+
+	return githubBaseURL() + "/login/oauth/authorize?" + params.Encode()
+}
+
+func githubExchange(code, redirectURI string) (*OAuthIdentity, error) {
+	form := url.Values{}
+	form.Set("client_id", os.Getenv("GITHUB_OAUTH_CLIENT_ID"))
+	form.Set("client_secret", os.Getenv("GITHUB_OAUTH_CLIENT_SECRET"))
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+
+	req, err := http.NewRequest("POST", githubBaseURL()+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauthHTTPClient.Do(req)
+	if err != nil {
+		telemetry.GithubAPIErrors.Inc("oauth_token")
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token OAuthTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		telemetry.GithubAPIErrors.Inc("oauth_token")
+		return nil, err
+	}
+
+	if token.Error != "" {
+		telemetry.GithubAPIErrors.Inc("oauth_token")
+		return nil, fmt.Errorf("Github token error: %s %s", token.Error, token.ErrorDescription)
+	}
+
+	if token.AccessToken == "" {
+		return nil, errors.New("Github token response has no access_token")
+	}
+
+	var user struct {
+		ID int `json:"id"`
+	}
+	if err := githubAPIGet(token.AccessToken, "/user", &user); err != nil {
+		return nil, err
+	}
+
+	var emails []GithubEmailAPIResponse
+	if err := githubAPIGet(token.AccessToken, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &OAuthIdentity{
+		Provider: "github",
+		Subject:  fmt.Sprintf("%d", user.ID),
+	}
+
+	for _, e := range emails {
+		if !e.Verified {
+			continue
+		}
+		if identity.Email == "" || e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = true
+		}
+	}
+
+	if org := os.Getenv("GITHUB_OAUTH_ALLOWED_ORG"); org != "" {
+		var membership struct {
+			State string `json:"state"`
+		}
+		err := githubAPIGet(token.AccessToken, "/user/memberships/orgs/"+url.PathEscape(org), &membership)
+		if err == nil && membership.State == "active" {
+			identity.Groups = []string{org}
+		}
+	}
+
+	return identity, nil
+}
+
+func githubAPIGet(accessToken, path string, out any) error {
+	req, err := http.NewRequest("GET", githubAPIBaseURL()+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := oauthHTTPClient.Do(req)
+	if err != nil {
+		telemetry.GithubAPIErrors.Inc("oauth_api")
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		telemetry.GithubAPIErrors.Inc("oauth_api")
+		var response map[string]string
+		json.NewDecoder(resp.Body).Decode(&response)
+		return fmt.Errorf("Github error: %s", response["message"])
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
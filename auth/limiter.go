@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	lockoutBaseDuration = 30 * time.Second
+	lockoutMaxDuration  = time.Hour
+	failureResetWindow  = 24 * time.Hour
+)
+
+type attemptRecord struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type loginLimiter struct {
+	mu        sync.Mutex
+	threshold int
+	records   map[string]*attemptRecord
+}
+
+var (
+	ipLimiter      = newLoginLimiter(20)
+	accountLimiter = newLoginLimiter(5)
+)
+
+func newLoginLimiter(threshold int) *loginLimiter {
+	return &loginLimiter{
+		threshold: threshold,
+		records:   map[string]*attemptRecord{},
+	}
+}
+
+// lockedFor returns how long the key remains locked out, or zero if it may attempt again.
+func (l *loginLimiter) lockedFor(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.records[key]
+	if !ok {
+		return 0
+	}
+
+	return max(time.Until(r.lockedUntil), 0)
+}
+
+// fail registers a failed attempt. Once the threshold is crossed every further
+// failure doubles the lockout, capped at lockoutMaxDuration.
+func (l *loginLimiter) fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	r, ok := l.records[key]
+	if !ok || now.Sub(r.lastFailure) > failureResetWindow {
+		r = &attemptRecord{}
+		l.records[key] = r
+	}
+
+	r.failures++
+	r.lastFailure = now
+
+	if len(l.records) > 10000 {
+		for k, rec := range l.records {
+			if now.Sub(rec.lastFailure) > failureResetWindow && now.After(rec.lockedUntil) {
+				delete(l.records, k)
+			}
+		}
+	}
+
+	if r.failures >= l.threshold {
+		exponent := float64(r.failures - l.threshold)
+		lockout := time.Duration(float64(lockoutBaseDuration) * math.Pow(2, exponent))
+		if lockout <= 0 || lockout > lockoutMaxDuration {
+			lockout = lockoutMaxDuration
+		}
+		r.lockedUntil = now.Add(lockout)
+	}
+}
+
+func (l *loginLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.records, key)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLimiterLocksOutAtThreshold(t *testing.T) {
+	l := newLoginLimiter(3)
+
+	for i := 0; i < 2; i++ {
+		l.fail("a")
+		if d := l.lockedFor("a"); d != 0 {
+			t.Fatalf("locked for %s after %d failures", d, i+1)
+		}
+	}
+	l.fail("a")
+	if d := l.lockedFor("a"); d <= lockoutBaseDuration-time.Second || d > lockoutBaseDuration {
+		t.Errorf("first lockout: got %s, want about %s", d, lockoutBaseDuration)
+	}
+	if d := l.lockedFor("b"); d != 0 {
+		t.Errorf("other key locked for %s", d)
+	}
+
+	l.reset("a")
+	if d := l.lockedFor("a"); d != 0 {
+		t.Errorf("locked for %s after reset", d)
+	}
+}
+
+func TestLoginLimiterBacksOffExponentially(t *testing.T) {
+	l := newLoginLimiter(1)
+
+	want := lockoutBaseDuration
+	for i := 0; i < 4; i++ {
+		l.fail("a")
+		if d := l.lockedFor("a"); d <= want-time.Second || d > want {
+			t.Fatalf("failure %d: got %s, want about %s", i+1, d, want)
+		}
+		want *= 2
+	}
+
+	for i := 0; i < 20; i++ {
+		l.fail("a")
+	}
+	if d := l.lockedFor("a"); d <= lockoutMaxDuration-time.Second || d > lockoutMaxDuration {
+		t.Errorf("capped lockout: got %s, want %s", d, lockoutMaxDuration)
+	}
+}
+
+func TestLoginLimiterForgetsStaleFailures(t *testing.T) {
+	l := newLoginLimiter(2)
+
+	l.fail("a")
+	l.records["a"].lastFailure = time.Now().Add(-failureResetWindow - time.Minute)
+	l.fail("a")
+	if d := l.lockedFor("a"); d != 0 {
+		t.Errorf("a failure outside the window still counted: locked for %s", d)
+	}
+}
@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const oauthStateCookie = "infracon_oauth_state"
+
+func GetAuthProviders(c *gin.Context) {
+	providers := []string{"password"}
+	if githubOAuthEnabled() {
+		providers = append(providers, "github")
+	}
+	if oidcEnabled() {
+		providers = append(providers, "oidc")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"providers": providers,
+		},
+	})
+}
+
+func StartOAuth(c *gin.Context) {
+	provider := c.Param("provider")
+	if err := utils.StringValidator("provider", provider, utils.ValidatorConfig{
+		NotEmpty:       true,
+		ExpectedValues: []string{"github", "oidc"},
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	if (provider == "github" && !githubOAuthEnabled()) || (provider == "oidc" && !oidcEnabled()) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Provider not configured",
+			"status":  false,
+		})
+		return
+	}
+
+	state, err := randomHex(16)
+	if err != nil {
+		log.Printf("oauth state error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	nonce, err := randomHex(16)
+	if err != nil {
+		log.Printf("oauth nonce error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	redirectURI := oauthRedirectURI(c, provider)
+
+	var authorizeURL string
+	if provider == "github" {
+		authorizeURL = githubAuthorizeURL(redirectURI, state)
+	} else {
+		authorizeURL, err = oidcAuthorizeURL(redirectURI, state, nonce)
+		if err != nil {
+			log.Printf("oidc discovery error: %s", err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"message": "Identity provider unavailable",
+				"status":  false,
+			})
+			return
+		}
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, provider+":"+state+":"+nonce, 600, "/api/auth/oauth", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authorizeURL)
+}
+
+func OAuthCallback(c *gin.Context) {
+	provider := c.Param("provider")
+
+	cookie, err := c.Cookie(oauthStateCookie)
+	c.SetCookie(oauthStateCookie, "", -1, "/api/auth/oauth", "", c.Request.TLS != nil, true)
+	parts := strings.Split(cookie, ":")
+	if err != nil || len(parts) != 3 || parts[0] != provider || subtle.ConstantTimeCompare([]byte(parts[1]), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid OAuth state",
+			"status":  false,
+		})
+		return
+	}
+
+	if errCode := c.Query("error"); errCode != "" {
+		recordEvent(c, EventSignInFailed, nil, "", false, provider+": "+errCode)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Sign in was cancelled or denied",
+			"status":  false,
+		})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "`code` is required",
+			"status":  false,
+		})
+		return
+	}
+
+	redirectURI := oauthRedirectURI(c, provider)
+
+	var identity *OAuthIdentity
+	switch provider {
+	case "github":
+		identity, err = githubExchange(code, redirectURI)
+	case "oidc":
+		identity, err = oidcExchange(code, redirectURI, parts[2])
+	}
+	if err != nil {
+		log.Printf("oauth exchange error: %s", err)
+		recordEvent(c, EventSignInFailed, nil, "", false, provider+": exchange failed")
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Could not verify identity with provider",
+			"status":  false,
+		})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+	if email == "" || !identity.EmailVerified {
+		recordEvent(c, EventSignInFailed, nil, email, false, provider+": no verified email")
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "A verified email is required",
+			"status":  false,
+		})
+		return
+	}
+
+	if !identityAllowed(provider, identity) {
+		recordEvent(c, EventSignInFailed, nil, email, false, provider+": not a member of the allowed org, group or email domain")
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not allowed to sign in",
+			"status":  false,
+		})
+		return
+	}
+
+	users := db.StoreFrom(c).Users()
+	userId, err := users.GetIdByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !autoProvisionEnabled(provider) {
+			recordEvent(c, EventSignInFailed, nil, email, false, provider+": no matching user")
+			c.JSON(http.StatusForbidden, gin.H{
+				"message": "No user with this email",
+				"status":  false,
+			})
+			return
+		}
+		userId, err = provisionUser(users, email)
+		if err == nil {
+			recordEvent(c, EventSignUp, &userId, email, true, provider)
+			audit.RecordActor(c, &userId, email, "user.create", "user", strconv.Itoa(userId), nil, gin.H{"email": email, "provider": provider})
+		}
+	}
+	if err != nil {
+		log.Printf("oauth user lookup error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	recordEvent(c, EventSignIn, &userId, email, true, provider)
+
+	token, err := utils.GenerateJwtToken(userId)
+	if err != nil {
+		log.Printf("generating token error: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Error generating token",
+			"status":  false,
+		})
+		return
+	}
+	recordEvent(c, EventTokenCreated, &userId, email, true, provider)
+
+	if target := os.Getenv("OAUTH_SUCCESS_REDIRECT_URL"); target != "" {
+		c.Redirect(http.StatusFound, target+"#token="+url.QueryEscape(token))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Operation successful",
+		"status":  true,
+		"data": gin.H{
+			"token": token,
+		},
+	})
+}
+
+func identityAllowed(provider string, identity *OAuthIdentity) bool {
+	if required := requiredMembership(provider); required != "" && !utils.Contains(identity.Groups, required) {
+		return false
+	}
+
+	domains := allowedEmailDomains()
+	if len(domains) == 0 {
+		return true
+	}
+	_, domain, _ := strings.Cut(strings.ToLower(identity.Email), "@")
+	return utils.Contains(domains, domain)
+}
+
+// requiredMembership is the org or group the provider's identities must belong to.
+func requiredMembership(provider string) string {
+	switch provider {
+	case "github":
+		return os.Getenv("GITHUB_OAUTH_ALLOWED_ORG")
+	case "oidc":
+		return os.Getenv("OIDC_ALLOWED_GROUP")
+	}
+	return ""
+}
+
+// allowedEmailDomains reads OAUTH_ALLOWED_EMAIL_DOMAINS, a comma-separated list that
+// applies to every provider.
+func allowedEmailDomains() []string {
+	var domains []string
+	for _, d := range strings.Split(os.Getenv("OAUTH_ALLOWED_EMAIL_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// autoProvisionEnabled reports whether unknown identities from the provider get an
+// account. Without an org, group or email-domain restriction that would be anyone
+// with an account at the provider, so it is refused even when OAUTH_AUTO_PROVISION is on.
+func autoProvisionEnabled(provider string) bool {
+	if os.Getenv("OAUTH_AUTO_PROVISION") != "true" {
+		return false
+	}
+	return requiredMembership(provider) != "" || len(allowedEmailDomains()) > 0
+}
+
+// CheckOAuthConfig fails when OAUTH_AUTO_PROVISION is on for a configured provider
+// that has no org, group or email-domain restriction.
+func CheckOAuthConfig() error {
+	if os.Getenv("OAUTH_AUTO_PROVISION") != "true" {
+		return nil
+	}
+	if githubOAuthEnabled() && !autoProvisionEnabled("github") {
+		return errors.New("OAUTH_AUTO_PROVISION requires GITHUB_OAUTH_ALLOWED_ORG or OAUTH_ALLOWED_EMAIL_DOMAINS")
+	}
+	if oidcEnabled() && !autoProvisionEnabled("oidc") {
+		return errors.New("OAUTH_AUTO_PROVISION requires OIDC_ALLOWED_GROUP or OAUTH_ALLOWED_EMAIL_DOMAINS")
+	}
+	return nil
+}
+
+// provisionUser creates a user that can only sign in through an identity provider
+// (or after a setup-key password reset), since nobody knows its random password.
+func provisionUser(users db.UserRepository, email string) (int, error) {
+	password, err := randomHex(32)
+	if err != nil {
+		return 0, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	return users.Create(email, string(hash))
+}
+
+func oauthRedirectURI(c *gin.Context, provider string) string {
+	base := strings.TrimSuffix(os.Getenv("OAUTH_REDIRECT_BASE_URL"), "/")
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+
+	return base + "/api/auth/oauth/" + provider + "/callback"
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
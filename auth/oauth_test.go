@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"infracon/db"
+	"infracon/db/dbtest"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "infracon"
+
+// setupOAuthTest gives the test a fresh in-memory store behind the OAuth routes and a
+// .env for GenerateJwtToken, which insists on loading one.
+func setupOAuthTest(t *testing.T) (db.Store, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := dbtest.OpenStore(t)
+
+	router := gin.New()
+	router.Use(db.Inject(store))
+	router.GET("/api/auth/oauth/:provider", StartOAuth)
+	router.GET("/api/auth/oauth/:provider/callback", OAuthCallback)
+	return store, router
+}
+
+// callback completes a sign-in whose StartOAuth set the state cookie to cookie, with
+// the provider redirecting back with state.
+func callback(router *gin.Engine, provider, cookie, state string) *httptest.ResponseRecorder {
+	query := url.Values{"state": {state}, "code": {"the-code"}}
+	req := httptest.NewRequest("GET", "/api/auth/oauth/"+provider+"/callback?"+query.Encode(), nil)
+	req.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: url.QueryEscape(cookie)})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// oidcProvider is an identity provider whose token endpoint returns an id_token with
+// the claims it is given, signed by signer while its JWKS publishes published.
+type oidcProvider struct {
+	*httptest.Server
+	signer    *rsa.PrivateKey
+	published *rsa.PublicKey
+	claims    jwt.MapClaims
+}
+
+func newOIDCProvider(t *testing.T) *oidcProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &oidcProvider{signer: key, published: &key.PublicKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OIDCDiscoveryDocument{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JwksURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{{
+			Kid: "k1",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(p.published.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.published.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != testClientID || r.FormValue("code") != "the-code" {
+			json.NewEncoder(w).Encode(OAuthTokenResponse{Error: "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, p.claims)
+		token.Header["kid"] = "k1"
+		idToken, err := token.SignedString(p.signer)
+		if err != nil {
+			t.Errorf("sign id_token: %s", err)
+		}
+		json.NewEncoder(w).Encode(OAuthTokenResponse{AccessToken: "access", IDToken: idToken})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	p.claims = jwt.MapClaims{
+		"iss":            p.URL,
+		"aud":            testClientID,
+		"sub":            "user-1",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          "the-nonce",
+		"email":          "Ada@Example.com",
+		"email_verified": true,
+		"groups":         []string{"engineering"},
+	}
+
+	t.Setenv("OIDC_ISSUER_URL", p.URL)
+	t.Setenv("OIDC_CLIENT_ID", testClientID)
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+
+	// Discovery and keys are cached across requests; drop what an earlier test left.
+	oidcMu.Lock()
+	oidcDiscovery, oidcKeys = nil, nil
+	oidcMu.Unlock()
+
+	return p
+}
+
+func createUser(t *testing.T, store db.Store, email string) int {
+	t.Helper()
+	id, err := store.Users().Create(email, "unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestStartOAuthSetsStateCookie(t *testing.T) {
+	_, router := setupOAuthTest(t)
+	newOIDCProvider(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/auth/oauth/oidc", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cookie string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oauthStateCookie {
+			cookie, _ = url.QueryUnescape(c.Value)
+		}
+	}
+	want := "oidc:" + location.Query().Get("state") + ":" + location.Query().Get("nonce")
+	if cookie != want {
+		t.Errorf("cookie %q, want %q", cookie, want)
+	}
+}
+
+func TestOAuthCallbackRejectsStateMismatch(t *testing.T) {
+	_, router := setupOAuthTest(t)
+	newOIDCProvider(t)
+
+	if w := callback(router, "oidc", "oidc:expected:the-nonce", "forged"); w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400: %s", w.Code, w.Body)
+	}
+	// A state issued for another provider does not carry over either.
+	if w := callback(router, "github", "oidc:state:the-nonce", "state"); w.Code != http.StatusBadRequest {
+		t.Errorf("provider swap: status %d, want 400", w.Code)
+	}
+}
+
+func TestOIDCCallbackSignsInExistingUser(t *testing.T) {
+	store, router := setupOAuthTest(t)
+	newOIDCProvider(t)
+	createUser(t, store, "ada@example.com")
+
+	w := callback(router, "oidc", "oidc:state:the-nonce", "state")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"token"`) {
+		t.Errorf("no token in %s", w.Body)
+	}
+}
+
+func TestOIDCCallbackRejectsNonceMismatch(t *testing.T) {
+	store, router := setupOAuthTest(t)
+	p := newOIDCProvider(t)
+	createUser(t, store, "ada@example.com")
+	p.claims["nonce"] = "replayed"
+
+	if w := callback(router, "oidc", "oidc:state:the-nonce", "state"); w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401: %s", w.Code, w.Body)
+	}
+}
+
+func TestOIDCCallbackRejectsUnpublishedSigningKey(t *testing.T) {
+	store, router := setupOAuthTest(t)
+	p := newOIDCProvider(t)
+	createUser(t, store, "ada@example.com")
+
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.signer = forger
+
+	if w := callback(router, "oidc", "oidc:state:the-nonce", "state"); w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401: %s", w.Code, w.Body)
+	}
+}
+
+func TestOIDCCallbackEnforcesAllowedGroup(t *testing.T) {
+	store, router := setupOAuthTest(t)
+	p := newOIDCProvider(t)
+	createUser(t, store, "ada@example.com")
+	t.Setenv("OIDC_ALLOWED_GROUP", "admins")
+
+	if w := callback(router, "oidc", "oidc:state:the-nonce", "state"); w.Code != http.StatusForbidden {
+		t.Errorf("outside the group: status %d, want 403: %s", w.Code, w.Body)
+	}
+
+	p.claims["groups"] = []string{"engineering", "admins"}
+	if w := callback(router, "oidc", "oidc:state:the-nonce", "state"); w.Code != http.StatusAccepted {
+		t.Errorf("in the group: status %d, want 202: %s", w.Code, w.Body)
+	}
+}
+
+func TestOAuthCallbackAutoProvision(t *testing.T) {
+	store, router := setupOAuthTest(t)
+	newOIDCProvider(t)
+
+	if w := callback(router, "oidc", "oidc:state:the-nonce", "state"); w.Code != http.StatusForbidden {
+		t.Errorf("provisioning off: status %d, want 403: %s", w.Code, w.Body)
+	}
+	if _, err := store.Users().GetIdByEmail("ada@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("user created with provisioning off: %v", err)
+	}
+
+	t.Setenv("OAUTH_AUTO_PROVISION", "true")
+	t.Setenv("OAUTH_ALLOWED_EMAIL_DOMAINS", "example.org, Example.com")
+	if err := CheckOAuthConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if w := callback(router, "oidc", "oidc:state:the-nonce", "state"); w.Code != http.StatusAccepted {
+		t.Fatalf("provisioning on: status %d, want 202: %s", w.Code, w.Body)
+	}
+	if _, err := store.Users().GetIdByEmail("ada@example.com"); err != nil {
+		t.Errorf("provisioned user: %s", err)
+	}
+}
+
+func TestOAuthAutoProvisionWithoutRestrictionIsRejected(t *testing.T) {
+	store, router := setupOAuthTest(t)
+	newOIDCProvider(t)
+	t.Setenv("OAUTH_AUTO_PROVISION", "true")
+
+	if err := CheckOAuthConfig(); err == nil {
+		t.Error("startup check accepted auto-provisioning without a restriction")
+	}
+	if w := callback(router, "oidc", "oidc:state:the-nonce", "state"); w.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403: %s", w.Code, w.Body)
+	}
+	if _, err := store.Users().GetIdByEmail("ada@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("user created without a restriction: %v", err)
+	}
+}
+
+func TestOAuthCallbackEnforcesAllowedEmailDomains(t *testing.T) {
+	store, router := setupOAuthTest(t)
+	newOIDCProvider(t)
+	createUser(t, store, "ada@example.com")
+	t.Setenv("OAUTH_ALLOWED_EMAIL_DOMAINS", "example.org")
+
+	if w := callback(router, "oidc", "oidc:state:the-nonce", "state"); w.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403: %s", w.Code, w.Body)
+	}
+}
+
+// newGithubProvider serves the OAuth and API endpoints githubExchange uses, with the
+// signed-in user an active member of org or not.
+func newGithubProvider(t *testing.T, org string, member bool) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_secret") != "secret" || r.FormValue("code") != "the-code" {
+			json.NewEncoder(w).Encode(OAuthTokenResponse{Error: "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(OAuthTokenResponse{AccessToken: "gho_test"})
+	})
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"id": 42})
+	})
+	mux.HandleFunc("GET /user/emails", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]GithubEmailAPIResponse{
+			{Email: "unverified@example.com", Primary: true},
+			{Email: "ada@example.com", Verified: true},
+		})
+	})
+	mux.HandleFunc("GET /user/memberships/orgs/{org}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_test" || r.PathValue("org") != org || !member {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Not Found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"state": "active"})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	t.Setenv("GITHUB_OAUTH_CLIENT_ID", "client")
+	t.Setenv("GITHUB_OAUTH_CLIENT_SECRET", "secret")
+	t.Setenv("GITHUB_OAUTH_BASE_URL", server.URL)
+	t.Setenv("GITHUB_API_BASE_URL", server.URL)
+	t.Setenv("GITHUB_OAUTH_ALLOWED_ORG", org)
+}
+
+func TestGithubCallbackEnforcesAllowedOrg(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		member bool
+		want   int
+	}{
+		{"member", true, http.StatusAccepted},
+		{"outsider", false, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, router := setupOAuthTest(t)
+			newGithubProvider(t, "acme", tc.member)
+			createUser(t, store, "ada@example.com")
+
+			if w := callback(router, "github", "github:state:unused", "state"); w.Code != tc.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const oidcCacheTTL = 10 * time.Minute
+
+var (
+	oidcMu          sync.Mutex
+	oidcDiscovery   *OIDCDiscoveryDocument
+	oidcKeys        map[string]any
+	oidcCachedAt    time.Time
+	oauthHTTPClient = &http.Client{Timeout: 15 * time.Second}
+)
+
+func oidcEnabled() bool {
+	return os.Getenv("OIDC_ISSUER_URL") != "" && os.Getenv("OIDC_CLIENT_ID") != ""
+}
+
+func getOIDCDiscovery() (*OIDCDiscoveryDocument, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcDiscovery != nil && time.Since(oidcCachedAt) < oidcCacheTTL {
+		return oidcDiscovery, nil
+	}
+
+	issuer := strings.TrimSuffix(os.Getenv("OIDC_ISSUER_URL"), "/")
+	resp, err := oauthHTTPClient.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery returned %d", resp.StatusCode)
+	}
+
+	var doc OIDCDiscoveryDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC issuer mismatch: expected %s got %s", issuer, doc.Issuer)
+	}
+
+	oidcDiscovery = &doc
+	oidcKeys = nil
+	oidcCachedAt = time.Now()
+	return oidcDiscovery, nil
+}
+
+func getOIDCKey(jwksURI, kid string) (any, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if key, ok := oidcKeys[kid]; ok {
+		return key, nil
+	}
+
+	// Unknown kid: the provider may have rotated keys, so refetch the set once.
+	resp, err := oauthHTTPClient.Get(jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned %d", resp.StatusCode)
+	}
+
+	var set JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	oidcKeys = map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJSONWebKey(k)
+		if err != nil {
+			continue
+		}
+		oidcKeys[k.Kid] = key
+	}
+
+	key, ok := oidcKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", kid)
+	}
+	return key, nil
+}
+
+func parseJSONWebKey(k JSONWebKey) (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func oidcAuthorizeURL(redirectURI, state, nonce string) (string, error) {
+	doc, err := getOIDCDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	scopes := os.Getenv("OIDC_SCOPES")
+	if scopes == "" {
+		scopes = "openid email profile"
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", os.Getenv("OIDC_CLIENT_ID"))
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", scopes)
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+
+	return doc.AuthorizationEndpoint + "?" + params.Encode(), nil
+}
+
+func oidcExchange(code, redirectURI, nonce string) (*OAuthIdentity, error) {
+	doc, err := getOIDCDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+
+	req, err := http.NewRequest("POST", doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(os.Getenv("OIDC_CLIENT_ID")), url.QueryEscape(os.Getenv("OIDC_CLIENT_SECRET")))
+
+	resp, err := oauthHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token OAuthTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	if token.Error != "" {
+		return nil, fmt.Errorf("OIDC token error: %s %s", token.Error, token.ErrorDescription)
+	}
+
+	if token.IDToken == "" {
+		return nil, errors.New("OIDC token response has no id_token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(
+		token.IDToken,
+		claims,
+		func(t *jwt.Token) (any, error) {
+			kid, _ := t.Header["kid"].(string)
+			return getOIDCKey(doc.JwksURI, kid)
+		},
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(os.Getenv("OIDC_CLIENT_ID")),
+		jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	// Fall back to the userinfo endpoint when the id_token omits the email.
+	if _, ok := claims["email"]; !ok && doc.UserinfoEndpoint != "" {
+		if err := fetchOIDCUserinfo(doc.UserinfoEndpoint, token.AccessToken, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	identity := &OAuthIdentity{
+		Provider: "oidc",
+		Email:    stringClaim(claims, "email"),
+		Groups:   stringSliceClaim(claims, groupsClaimName()),
+	}
+	identity.Subject, _ = claims.GetSubject()
+
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+
+	return identity, nil
+}
+
+func fetchOIDCUserinfo(endpoint, accessToken string, claims jwt.MapClaims) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauthHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OIDC userinfo returned %d", resp.StatusCode)
+	}
+
+	var info map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return err
+	}
+
+	if sub, _ := info["sub"].(string); sub != stringClaim(claims, "sub") {
+		return errors.New("OIDC userinfo subject mismatch")
+	}
+
+	for k, v := range info {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return nil
+}
+
+func groupsClaimName() string {
+	if name := os.Getenv("OIDC_GROUPS_CLAIM"); name != "" {
+		return name
+	}
+	return "groups"
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	v, _ := claims[name].(string)
+	return v
+}
+
+func stringSliceClaim(claims jwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
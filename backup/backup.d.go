@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"infracon/utils"
+	"io"
+	"net/http"
+	"time"
+)
+
+type CreateBackupTargetPayload struct {
+	Name      string `json:"name" binding:"required"`
+	Kind      string `json:"kind" binding:"required"`
+	Path      string `json:"path"`
+	Endpoint  string `json:"endpoint"`
+	Bucket    string `json:"bucket"`
+	Region    string `json:"region"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+type SetBackupPolicyPayload struct {
+	Enabled  bool   `json:"enabled"`
+	Schedule string `json:"schedule"`
+	TargetID *int   `json:"target_id"`
+	KeepLast int    `json:"keep_last" binding:"min=1"`
+	KeepDays int    `json:"keep_days" binding:"min=0"`
+}
+
+type CreateSnapshotPayload struct {
+	TargetID *int `json:"target_id"`
+}
+
+type BackupPolicyStatus struct {
+	utils.BackupPolicy
+	NextRun *time.Time `json:"next_run"`
+}
+
+// store reads and writes snapshot files by key in a backup target.
+type store interface {
+	Put(key string, r io.ReadSeeker, size int64) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+type localStore struct {
+	root string
+}
+
+// s3Store talks to S3 and S3-compatible services such as MinIO using path-style
+// requests signed with AWS Signature Version 4.
+type s3Store struct {
+	client    *http.Client
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// cronSchedule is a parsed five-field cron expression. Each field is a bit set of the
+// values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
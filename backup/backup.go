@@ -0,0 +1,664 @@
+package backup
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/telemetry"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var bucketNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+func GetBackupTargets(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	targets, err := db.StoreFrom(c).Backups().ListTargets(project.Slug)
+	if err != nil {
+		log.Printf("backup targets query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"targets": targets,
+		},
+	})
+}
+
+func CreateBackupTarget(c *gin.Context) {
+	var body CreateBackupTargetPayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid payload",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	if err := validateTarget(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	t := utils.BackupTarget{
+		ProjectSlug: project.Slug,
+		Name:        body.Name,
+		Kind:        body.Kind,
+		Path:        body.Path,
+		Endpoint:    body.Endpoint,
+		Bucket:      body.Bucket,
+		Region:      body.Region,
+		Prefix:      body.Prefix,
+		AccessKey:   body.AccessKey,
+		SecretKey:   body.SecretKey,
+		CreatedAt:   time.Now(),
+	}
+
+	id, err := db.StoreFrom(c).Backups().CreateTarget(t)
+	if err != nil {
+		log.Printf("backup target insert error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	t.ID = id
+	audit.Record(c, "project.backup_target.create", "project", project.Slug, nil, t)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  true,
+		"message": "Backup target created",
+		"data": gin.H{
+			"target": t,
+		},
+	})
+}
+
+// DeleteBackupTarget refuses to delete a target that the backup policy or existing
+// snapshots still use, so no snapshot loses the place its files are stored.
+func DeleteBackupTarget(c *gin.Context) {
+	t, ok := lookupTarget(c)
+	if !ok {
+		return
+	}
+
+	store := db.StoreFrom(c)
+
+	policy, err := Policy(store, t.ProjectSlug)
+	if err != nil {
+		log.Printf("backup policy query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	if policy.TargetID != nil && *policy.TargetID == t.ID {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "The backup policy uses this target",
+			"status":  false,
+		})
+		return
+	}
+
+	snapshots, err := store.Backups().ListSnapshots(t.ProjectSlug)
+	if err != nil {
+		log.Printf("snapshots query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	for _, s := range snapshots {
+		if s.TargetID == t.ID {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "Snapshots are stored in this target, delete them first",
+				"status":  false,
+			})
+			return
+		}
+	}
+
+	if err := store.Backups().DeleteTarget(t.ProjectSlug, t.ID); err != nil {
+		log.Printf("backup target delete error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.backup_target.delete", "project", t.ProjectSlug, t, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Backup target deleted",
+	})
+}
+
+// TestBackupTarget writes, reads back and deletes a test object in the target.
+func TestBackupTarget(c *gin.Context) {
+	t, ok := lookupTarget(c)
+	if !ok {
+		return
+	}
+
+	if err := testStore(*t); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"message": "Backup target test failed",
+			"details": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Backup target is writable",
+	})
+}
+
+func GetBackupPolicy(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	policy, err := Policy(db.StoreFrom(c), project.Slug)
+	if err != nil {
+		log.Printf("backup policy query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	status := BackupPolicyStatus{BackupPolicy: policy}
+	if schedule, err := parseCron(policy.Schedule); err == nil && policy.Enabled {
+		status.NextRun = schedule.next(time.Now())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"policy": status,
+		},
+	})
+}
+
+// SetBackupPolicy saves the schedule and retention rules and applies the new
+// retention to existing snapshots in the background.
+func SetBackupPolicy(c *gin.Context) {
+	var body SetBackupPolicyPayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid payload",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	body.Schedule = strings.TrimSpace(body.Schedule)
+	if body.Schedule != "" {
+		if _, err := parseCron(body.Schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid `schedule`: " + err.Error(),
+				"status":  false,
+			})
+			return
+		}
+	}
+	if body.Enabled && (body.Schedule == "" || body.TargetID == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "An enabled policy needs a `schedule` and a `target_id`",
+			"status":  false,
+		})
+		return
+	}
+	if body.TargetID != nil {
+		if _, err := db.StoreFrom(c).Backups().GetTarget(project.Slug, *body.TargetID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "Backup target not found",
+					"status":  false,
+				})
+				return
+			}
+			log.Printf("backup target query error: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Something went wrong",
+				"status":  false,
+			})
+			return
+		}
+	}
+
+	before, err := Policy(db.StoreFrom(c), project.Slug)
+	if err != nil {
+		log.Printf("backup policy query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	policy := utils.BackupPolicy{
+		ProjectSlug: project.Slug,
+		Enabled:     body.Enabled,
+		Schedule:    body.Schedule,
+		TargetID:    body.TargetID,
+		KeepLast:    body.KeepLast,
+		KeepDays:    body.KeepDays,
+	}
+	if err := db.StoreFrom(c).Backups().SetPolicy(policy); err != nil {
+		log.Printf("backup policy update error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.backup_policy.update", "project", project.Slug, before, policy)
+
+	go func() {
+		if err := applyRetention(db.StoreFrom(c), project.Slug); err != nil {
+			log.Printf("backup retention error for %s: %s", project.Slug, err)
+		}
+	}()
+
+	status := BackupPolicyStatus{BackupPolicy: policy}
+	if schedule, err := parseCron(policy.Schedule); err == nil && policy.Enabled {
+		status.NextRun = schedule.next(time.Now())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Backup policy updated",
+		"data": gin.H{
+			"policy": status,
+		},
+	})
+}
+
+func GetSnapshots(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	snapshots, err := db.StoreFrom(c).Backups().ListSnapshots(project.Slug)
+	if err != nil {
+		log.Printf("snapshots query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"snapshots": snapshots,
+		},
+	})
+}
+
+// CreateSnapshot starts a snapshot into the given target, or the policy's target when
+// none is given. Progress shows up on the events feed and in the snapshot's status.
+func CreateSnapshot(c *gin.Context) {
+	var body CreateSnapshotPayload
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  false,
+				"message": "Invalid payload",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	targetId := body.TargetID
+	if targetId == nil {
+		policy, err := Policy(db.StoreFrom(c), project.Slug)
+		if err != nil {
+			log.Printf("backup policy query error: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Something went wrong",
+				"status":  false,
+			})
+			return
+		}
+		targetId = policy.TargetID
+	}
+	if targetId == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Pass a `target_id` or set one in the backup policy",
+			"status":  false,
+		})
+		return
+	}
+
+	target, err := db.StoreFrom(c).Backups().GetTarget(project.Slug, *targetId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Backup target not found",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("backup target query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	id, err := StartSnapshot(db.StoreFrom(c), project, *target, "manual")
+	if err != nil {
+		if errors.Is(err, errNoVolumes) || errors.Is(err, errBusy) {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": err.Error(),
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("snapshot start error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.snapshot.create", "project", project.Slug, nil, gin.H{"snapshot_id": id, "target_id": target.ID})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  true,
+		"message": "Snapshot started",
+		"data": gin.H{
+			"snapshot_id": id,
+		},
+	})
+}
+
+// DeleteSnapshot removes the snapshot's files from its target and forgets it.
+func DeleteSnapshot(c *gin.Context) {
+	s, ok := lookupSnapshot(c)
+	if !ok {
+		return
+	}
+
+	if s.Status == "running" {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "The snapshot is still running",
+			"status":  false,
+		})
+		return
+	}
+
+	if err := deleteSnapshot(db.StoreFrom(c), *s); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"message": "Could not delete the snapshot's files",
+			"details": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.snapshot.delete", "project", s.ProjectSlug, s, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Snapshot deleted",
+	})
+}
+
+// RestoreSnapshot streams the restore of a completed snapshot into the project's
+// volumes. The container is stopped meanwhile and started again afterwards.
+func RestoreSnapshot(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, map[string]any{
+			"status":  false,
+			"message": "SSE not supported",
+		})
+		return
+	}
+
+	stopHeartbeat := utils.StartSSEHeartbeat(c, flusher)
+	defer stopHeartbeat()
+
+	store := db.StoreFrom(c)
+
+	slug := c.Param("slug")
+	project, err := store.Projects().Get(slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.StreamSSEError("Project not found", c, flusher)
+		} else {
+			utils.StreamSSEError(err.Error(), c, flusher)
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.StreamSSEError("Invalid snapshot id", c, flusher)
+		return
+	}
+	s, err := store.Backups().GetSnapshot(slug, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.StreamSSEError("Snapshot not found", c, flusher)
+		} else {
+			utils.StreamSSEError(err.Error(), c, flusher)
+		}
+		return
+	}
+	if s.Status != "completed" {
+		utils.StreamSSEError(fmt.Sprintf("Snapshot is %s, only completed snapshots can be restored", s.Status), c, flusher)
+		return
+	}
+	target, err := store.Backups().GetTarget(slug, s.TargetID)
+	if err != nil {
+		utils.StreamSSEError(fmt.Sprintf("Backup target %d: %s", s.TargetID, err), c, flusher)
+		return
+	}
+
+	if !acquire(slug) {
+		utils.StreamSSEError(errBusy.Error(), c, flusher)
+		return
+	}
+	defer release(slug)
+
+	utils.WriteSSEData(slug, 0, []string{"INFO", fmt.Sprintf("Restoring snapshot %d from %s", s.ID, target.Name)}, c, flusher)
+	if err := restore(store, project, s, *target, c, flusher); err != nil {
+		telemetry.Backups.Inc("restore", "failure")
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Restore failed: %s", err)}, c, flusher)
+		audit.Record(c, "project.snapshot.restore", "project", slug, nil, gin.H{"snapshot_id": s.ID, "error": err.Error()})
+		utils.WriteSSEData(slug, 0, []string{"DONE", "failed"}, c, flusher)
+		return
+	}
+
+	telemetry.Backups.Inc("restore", "success")
+	audit.Record(c, "project.snapshot.restore", "project", slug, nil, gin.H{"snapshot_id": s.ID})
+	utils.WriteSSEData(slug, 0, []string{"DONE", "restored"}, c, flusher)
+}
+
+func lookupProject(c *gin.Context) (*utils.Project, bool) {
+	project, err := db.StoreFrom(c).Projects().Get(c.Param("slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Project not found!",
+				"status":  false,
+			})
+			return nil, false
+		}
+		log.Printf("project lookup query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return nil, false
+	}
+	return project, true
+}
+
+func lookupTarget(c *gin.Context) (*utils.BackupTarget, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid backup target id",
+			"status":  false,
+		})
+		return nil, false
+	}
+
+	t, err := db.StoreFrom(c).Backups().GetTarget(c.Param("slug"), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Backup target not found",
+				"status":  false,
+			})
+			return nil, false
+		}
+		log.Printf("backup target query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return nil, false
+	}
+
+	return t, true
+}
+
+func lookupSnapshot(c *gin.Context) (*utils.Snapshot, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid snapshot id",
+			"status":  false,
+		})
+		return nil, false
+	}
+
+	s, err := db.StoreFrom(c).Backups().GetSnapshot(c.Param("slug"), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Snapshot not found",
+				"status":  false,
+			})
+			return nil, false
+		}
+		log.Printf("snapshot query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return nil, false
+	}
+
+	return s, true
+}
+
+func validateTarget(body *CreateBackupTargetPayload) error {
+	if err := utils.StringValidator("name", body.Name, utils.ValidatorConfig{
+		NotEmpty:  true,
+		MaxLength: 64,
+	}); err != nil {
+		return err
+	}
+
+	if err := utils.StringValidator("kind", body.Kind, utils.ValidatorConfig{
+		ExpectedValues: []string{"local", "s3"},
+	}); err != nil {
+		return err
+	}
+
+	body.Prefix = strings.Trim(body.Prefix, "/")
+	for _, segment := range strings.Split(body.Prefix, "/") {
+		if segment == "." || segment == ".." {
+			return errors.New("Prefix must not contain . or .. segments")
+		}
+	}
+
+	if body.Kind == "local" {
+		if !filepath.IsAbs(body.Path) {
+			return errors.New("Path must be an absolute directory")
+		}
+		body.Path = filepath.Clean(body.Path)
+		body.Endpoint, body.Bucket, body.Region, body.AccessKey, body.SecretKey = "", "", "", "", ""
+		return nil
+	}
+
+	body.Path = ""
+	u, err := url.Parse(body.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+		return errors.New("Endpoint must be an http(s) URL without a path, e.g. http://minio:9000")
+	}
+	if !bucketNameRegex.MatchString(body.Bucket) {
+		return errors.New("Bucket must be a valid S3 bucket name")
+	}
+	if body.AccessKey == "" || body.SecretKey == "" {
+		return errors.New("Access key and secret key are required")
+	}
+	if body.Region == "" {
+		body.Region = "us-east-1"
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"fmt"
+	"infracon/db"
+	"infracon/events"
+	"infracon/utils"
+	"log"
+	"time"
+)
+
+// RunScheduler fails snapshots that a crash or reboot interrupted, then starts the
+// snapshots that enabled backup policies schedule, checking once a minute.
+func RunScheduler(ds db.Store) {
+	n, err := ds.Backups().FailInterruptedSnapshots("interrupted: infracon stopped before the snapshot finished")
+	if err != nil {
+		log.Printf("backup scheduler error: %s", err)
+	} else if n > 0 {
+		log.Printf("backup scheduler: marked %d interrupted snapshots as failed", n)
+	}
+
+	for {
+		now := time.Now()
+		minute := now.Truncate(time.Minute).Add(time.Minute)
+		time.Sleep(minute.Sub(now))
+		runDue(ds, minute)
+	}
+}
+
+func runDue(ds db.Store, minute time.Time) {
+	policies, err := ds.Backups().EnabledPolicies()
+	if err != nil {
+		log.Printf("backup scheduler error: %s", err)
+		return
+	}
+
+	for _, policy := range policies {
+		schedule, err := parseCron(policy.Schedule)
+		if err != nil {
+			log.Printf("backup scheduler: %s has an invalid schedule %q: %s", policy.ProjectSlug, policy.Schedule, err)
+			continue
+		}
+		if !schedule.matches(minute) {
+			continue
+		}
+
+		if err := scheduleSnapshot(ds, policy); err != nil {
+			message := fmt.Sprintf("Scheduled snapshot of %s could not start: %s", policy.ProjectSlug, err)
+			log.Print(message)
+			events.Publish(utils.SSEEvent{Type: "backup", ProjectSlug: policy.ProjectSlug, Level: "error", Message: message})
+		}
+	}
+}
+
+func scheduleSnapshot(ds db.Store, policy utils.BackupPolicy) error {
+	p, err := ds.Projects().Get(policy.ProjectSlug)
+	if err != nil {
+		return err
+	}
+	target, err := ds.Backups().GetTarget(policy.ProjectSlug, *policy.TargetID)
+	if err != nil {
+		return fmt.Errorf("backup target %d: %w", *policy.TargetID, err)
+	}
+
+	_, err = StartSnapshot(ds, p, *target, "schedule")
+	return err
+}
@@ -0,0 +1,332 @@
+package backup
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/db"
+	"infracon/events"
+	"infracon/project"
+	"infracon/telemetry"
+	"infracon/utils"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	activeMu sync.Mutex
+	active   = map[string]bool{}
+
+	errNoVolumes = errors.New("the project has no volumes to snapshot")
+	errBusy      = errors.New("a snapshot or restore of the project is already running")
+)
+
+func DefaultBackupPolicy(slug string) utils.BackupPolicy {
+	policy := utils.BackupPolicy{
+		ProjectSlug: slug,
+		KeepLast:    7,
+		KeepDays:    30,
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("BACKUP_RETENTION_KEEP_LAST")); err == nil && n > 0 {
+		policy.KeepLast = n
+	}
+	if days, err := strconv.Atoi(os.Getenv("BACKUP_RETENTION_KEEP_DAYS")); err == nil && days >= 0 {
+		policy.KeepDays = days
+	}
+
+	return policy
+}
+
+func Policy(ds db.Store, slug string) (utils.BackupPolicy, error) {
+	policy, err := ds.Backups().GetPolicy(slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultBackupPolicy(slug), nil
+	}
+	if err != nil {
+		return utils.BackupPolicy{}, err
+	}
+	return *policy, nil
+}
+
+// acquire marks a snapshot or restore of the project as running, or reports false when
+// one already is.
+func acquire(slug string) bool {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	if active[slug] {
+		return false
+	}
+	active[slug] = true
+	return true
+}
+
+func release(slug string) {
+	activeMu.Lock()
+	delete(active, slug)
+	activeMu.Unlock()
+}
+
+func helperImage() string {
+	return utils.GetEnv("BACKUP_HELPER_IMAGE", "alpine:3")
+}
+
+// StartSnapshot records a snapshot of the project's volumes and writes it to target in
+// the background, returning the snapshot's id.
+func StartSnapshot(ds db.Store, p *utils.Project, target utils.BackupTarget, trigger string) (int, error) {
+	volumes, err := ds.Volumes().List(p.Slug)
+	if err != nil {
+		return 0, err
+	}
+	if len(volumes) == 0 {
+		return 0, errNoVolumes
+	}
+
+	if !acquire(p.Slug) {
+		return 0, errBusy
+	}
+
+	id, err := ds.Backups().CreateSnapshot(utils.Snapshot{ProjectSlug: p.Slug, TargetID: target.ID, Trigger: trigger, Status: "running"})
+	if err != nil {
+		release(p.Slug)
+		return 0, err
+	}
+
+	go func() {
+		defer release(p.Slug)
+		runSnapshot(ds, id, p, target, volumes)
+	}()
+
+	return id, nil
+}
+
+func runSnapshot(ds db.Store, id int, p *utils.Project, target utils.BackupTarget, volumes []utils.ProjectVolume) {
+	started := time.Now()
+	events.Publish(utils.SSEEvent{Type: "backup", ProjectSlug: p.Slug, Message: fmt.Sprintf("Snapshot %d to %s started", id, target.Name)})
+
+	skipped, err := writeSnapshot(ds, id, p, target, volumes)
+	if err != nil {
+		telemetry.Backups.Inc("snapshot", "failure")
+		message := fmt.Sprintf("Snapshot %d of %s to %s failed: %s", id, p.Slug, target.Name, err)
+		log.Print(message)
+		if err := ds.Backups().FinishSnapshot(id, "failed", err.Error()); err != nil {
+			log.Printf("snapshot %d update error: %s", id, err)
+		}
+		events.Publish(utils.SSEEvent{Type: "backup", ProjectSlug: p.Slug, Level: "error", Message: message})
+		details := map[string]any{"project": p.Slug, "snapshot_id": id, "target": target.Name}
+		if err := utils.SendNotification("backup.failed", message, details); err != nil {
+			log.Printf("notification error: %s", err)
+		}
+		return
+	}
+
+	message := ""
+	if len(skipped) > 0 {
+		message = fmt.Sprintf("skipped volumes that were never created: %s", strings.Join(skipped, ", "))
+	}
+	if err := ds.Backups().FinishSnapshot(id, "completed", message); err != nil {
+		log.Printf("snapshot %d update error: %s", id, err)
+	}
+	telemetry.Backups.Inc("snapshot", "success")
+	events.Publish(utils.SSEEvent{Type: "backup", ProjectSlug: p.Slug, Message: fmt.Sprintf("Snapshot %d to %s completed in %s", id, target.Name, time.Since(started).Round(time.Second))})
+
+	if err := applyRetention(ds, p.Slug); err != nil {
+		log.Printf("backup retention error for %s: %s", p.Slug, err)
+	}
+}
+
+// writeSnapshot stores one gzipped tarball per volume under
+// <prefix>/<slug>/<id>-<timestamp>/<volume>.tar.gz. With BACKUP_PAUSE_CONTAINER=true
+// the project's container is paused meanwhile so files are captured consistently;
+// database add-ons are always paused.
+func writeSnapshot(ds db.Store, id int, p *utils.Project, target utils.BackupTarget, volumes []utils.ProjectVolume) ([]string, error) {
+	s, err := newStore(target)
+	if err != nil {
+		return nil, err
+	}
+
+	pause := utils.GetEnv("BACKUP_PAUSE_CONTAINER", "false") == "true" || (p.Type != nil && *p.Type == "addon")
+	if pause && p.ContainerName != nil && *p.ContainerName != "" {
+		if err := exec.Command("docker", "pause", *p.ContainerName).Run(); err == nil {
+			defer func() {
+				if output, err := exec.Command("docker", "unpause", *p.ContainerName).CombinedOutput(); err != nil {
+					log.Printf("could not unpause %s: %s", *p.ContainerName, strings.TrimSpace(string(output)))
+				}
+			}()
+		}
+	}
+
+	dir := path.Join(strings.Trim(target.Prefix, "/"), p.Slug, fmt.Sprintf("%d-%s", id, time.Now().UTC().Format("20060102T150405Z")))
+	var skipped []string
+	for _, v := range volumes {
+		if err := exec.Command("docker", "volume", "inspect", v.DockerName).Run(); err != nil {
+			skipped = append(skipped, v.Name)
+			continue
+		}
+
+		key := path.Join(dir, v.Name+".tar.gz")
+		size, err := snapshotVolume(s, v, key)
+		if err != nil {
+			return nil, fmt.Errorf("volume %s: %w", v.Name, err)
+		}
+		if err := ds.Backups().AddSnapshotFile(utils.SnapshotFile{SnapshotID: id, VolumeName: v.Name, Key: key, SizeBytes: size}); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(skipped) == len(volumes) {
+		return nil, errors.New("none of the project's volumes exist yet")
+	}
+	return skipped, nil
+}
+
+// snapshotVolume archives the volume through a throwaway helper container into a
+// temporary file, so the upload knows its size and can hash it before sending.
+func snapshotVolume(s store, v utils.ProjectVolume, key string) (int64, error) {
+	tmp, err := os.CreateTemp(utils.GetEnv("BACKUP_TMP_DIR", ""), "infracon-snapshot-*.tar.gz")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", "run", "--rm", "-v", v.DockerName+":/data:ro", helperImage(), "tar", "-czf", "-", "-C", "/data", ".")
+	cmd.Stdout = tmp
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("archiving failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	if err := s.Put(key, tmp, size); err != nil {
+		return 0, fmt.Errorf("upload failed: %w", err)
+	}
+	return size, nil
+}
+
+// applyRetention deletes completed snapshots beyond the policy's keep_last that are
+// also older than keep_days, and failed snapshots once a newer one completed.
+func applyRetention(ds db.Store, slug string) error {
+	policy, err := Policy(ds, slug)
+	if err != nil {
+		return err
+	}
+	snapshots, err := ds.Backups().ListSnapshots(slug)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -policy.KeepDays)
+	completed := 0
+	var errs []error
+
+	// Snapshots come newest first.
+	for _, s := range snapshots {
+		switch s.Status {
+		case "completed":
+			completed++
+			if completed <= policy.KeepLast || s.CreatedAt.After(cutoff) {
+				continue
+			}
+		case "failed":
+			if completed == 0 {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if err := deleteSnapshot(ds, s); err != nil {
+			errs = append(errs, fmt.Errorf("snapshot %d: %w", s.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deleteSnapshot removes the snapshot's files from its target and then its record.
+func deleteSnapshot(ds db.Store, s utils.Snapshot) error {
+	if len(s.Files) > 0 {
+		target, err := ds.Backups().GetTarget(s.ProjectSlug, s.TargetID)
+		if err != nil {
+			return err
+		}
+		st, err := newStore(*target)
+		if err != nil {
+			return err
+		}
+		for _, f := range s.Files {
+			if err := st.Delete(f.Key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ds.Backups().DeleteSnapshot(s.ProjectSlug, s.ID)
+}
+
+// restore replaces the data of every volume in the snapshot while the project's
+// container is stopped. Volumes that were deleted from the project since are skipped.
+func restore(ds db.Store, p *utils.Project, s *utils.Snapshot, target utils.BackupTarget, c *gin.Context, f http.Flusher) error {
+	st, err := newStore(target)
+	if err != nil {
+		return err
+	}
+
+	volumes, err := ds.Volumes().List(p.Slug)
+	if err != nil {
+		return err
+	}
+	byName := map[string]utils.ProjectVolume{}
+	for _, v := range volumes {
+		byName[v.Name] = v
+	}
+
+	return project.WhileStopped(ds, p, func() error {
+		for _, file := range s.Files {
+			v, ok := byName[file.VolumeName]
+			if !ok {
+				utils.WriteSSEData(p.Slug, 0, []string{"INFO", fmt.Sprintf("Skipping %s, the project no longer has this volume", file.VolumeName)}, c, f)
+				continue
+			}
+			if err := project.EnsureVolume(v, 0, c, f); err != nil {
+				return err
+			}
+
+			r, err := st.Get(file.Key)
+			if err != nil {
+				return fmt.Errorf("could not read %s: %w", file.Key, err)
+			}
+
+			utils.WriteSSEData(p.Slug, 0, []string{"INFO", fmt.Sprintf("Restoring volume %s from %s", v.Name, file.Key)}, c, f)
+			cmd := exec.Command("docker", "run", "--rm", "-i", "-v", v.DockerName+":/data", helperImage(),
+				"sh", "-c", "find /data -mindepth 1 -delete && tar -xzf - -C /data")
+			cmd.Stdin = r
+			err = utils.ExecCommandAndStreamViaSSE(p.Slug, 0, "RUN", cmd, c, f)
+			r.Close()
+			if err != nil {
+				return fmt.Errorf("could not restore volume %s: %w", v.Name, err)
+			}
+		}
+		return nil
+	}, c, f)
+}
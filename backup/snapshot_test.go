@@ -0,0 +1,186 @@
+package backup
+
+import (
+	"database/sql"
+	"errors"
+	"infracon/db"
+	"infracon/db/dbtest"
+	"infracon/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeDocker stands in for the docker CLI: volumes are directories under
+// $FAKE_DOCKER_VOLUMES and `docker run` runs the helper's command on the host with
+// /data pointing at the mounted volume's directory. Everything else succeeds.
+const fakeDocker = `#!/bin/sh
+case "$1" in
+volume)
+	case "$2" in
+	inspect) test -d "$FAKE_DOCKER_VOLUMES/$3" ;;
+	create) eval "name=\${$#}"; mkdir -p "$FAKE_DOCKER_VOLUMES/$name" ;;
+	esac
+	;;
+run)
+	shift
+	while [ "$1" != "-v" ]; do shift; done
+	dir="$FAKE_DOCKER_VOLUMES/${2%%:*}"
+	shift 3
+	if [ "$1" = sh ]; then
+		exec sh -c "$(printf '%s' "$3" | sed "s#/data#$dir#g")"
+	fi
+	for arg; do
+		shift
+		[ "$arg" = /data ] && arg="$dir"
+		set -- "$@" "$arg"
+	done
+	exec "$@"
+	;;
+esac
+`
+
+// installFakeDocker puts fakeDocker first on PATH and returns its volumes directory.
+func installFakeDocker(t *testing.T) string {
+	t.Helper()
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(fakeDocker), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	volumes := t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_DOCKER_VOLUMES", volumes)
+	return volumes
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitForSnapshot(t *testing.T, store db.Store, slug string, id int) *utils.Snapshot {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s, err := store.Backups().GetSnapshot(slug, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Status != "running" {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot %d still running", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLocalSnapshotRestoreRoundTrip(t *testing.T) {
+	volumes := installFakeDocker(t)
+	store := dbtest.OpenStore(t)
+
+	p := utils.Project{Name: "pg", Slug: "pg"}
+	var err error
+	if p.ID, err = store.Projects().Create(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Volumes().Create(utils.ProjectVolume{ProjectSlug: "pg", Name: "data", MountPath: "/var/lib/postgresql/data", DockerName: "infracon-pg-data"}); err != nil {
+		t.Fatal(err)
+	}
+	target := utils.BackupTarget{ProjectSlug: "pg", Name: "disk", Kind: "local", Path: t.TempDir(), Prefix: "backups"}
+	if target.ID, err = store.Backups().CreateTarget(target); err != nil {
+		t.Fatal(err)
+	}
+
+	data := filepath.Join(volumes, "infracon-pg-data")
+	writeFiles(t, data, map[string]string{
+		"PG_VERSION":     "16\n",
+		"base/1/16384":   "rows",
+		"pg_wal/0000001": "wal",
+	})
+
+	id, err := StartSnapshot(store, &p, target, "manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := waitForSnapshot(t, store, "pg", id)
+	if s.Status != "completed" || len(s.Files) != 1 {
+		t.Fatalf("snapshot: %+v", s)
+	}
+	archive := filepath.Join(target.Path, filepath.FromSlash(s.Files[0].Key))
+	if info, err := os.Stat(archive); err != nil || info.Size() != s.Files[0].SizeBytes {
+		t.Fatalf("archive %s: %v, recorded %d bytes", archive, err, s.Files[0].SizeBytes)
+	}
+
+	// Change the volume after the snapshot; the restore has to undo all of it.
+	writeFiles(t, data, map[string]string{"PG_VERSION": "17\n", "stray": "x"})
+	if err := os.RemoveAll(filepath.Join(data, "pg_wal")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restore(store, &p, s, target, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"PG_VERSION": "16\n", "base/1/16384": "rows", "pg_wal/0000001": "wal"} {
+		got, err := os.ReadFile(filepath.Join(data, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(data, "stray")); !os.IsNotExist(err) {
+		t.Errorf("stray file survived the restore: %v", err)
+	}
+
+	if err := deleteSnapshot(store, *s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("archive survived the delete: %v", err)
+	}
+	if _, err := store.Backups().GetSnapshot("pg", id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("snapshot record survived the delete: %v", err)
+	}
+}
+
+func TestSnapshotSkipsVolumesThatWereNeverCreated(t *testing.T) {
+	volumes := installFakeDocker(t)
+	store := dbtest.OpenStore(t)
+
+	p := utils.Project{Name: "web", Slug: "web"}
+	var err error
+	if p.ID, err = store.Projects().Create(p); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"uploads", "cache"} {
+		if _, err := store.Volumes().Create(utils.ProjectVolume{ProjectSlug: "web", Name: name, MountPath: "/" + name, DockerName: "infracon-web-" + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target := utils.BackupTarget{ProjectSlug: "web", Name: "disk", Kind: "local", Path: t.TempDir()}
+	if target.ID, err = store.Backups().CreateTarget(target); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, filepath.Join(volumes, "infracon-web-uploads"), map[string]string{"a.png": "png"})
+
+	id, err := StartSnapshot(store, &p, target, "manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := waitForSnapshot(t, store, "web", id)
+	if s.Status != "completed" || len(s.Files) != 1 || s.Files[0].VolumeName != "uploads" {
+		t.Fatalf("snapshot: %+v", s)
+	}
+	if s.Message != "skipped volumes that were never created: cache" {
+		t.Errorf("message: %q", s.Message)
+	}
+}
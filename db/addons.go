@@ -0,0 +1,99 @@
+package db
+
+import (
+	"infracon/utils"
+)
+
+type addonRepo struct{ s *sqlStore }
+
+const addonColumns = "project_slug, engine, version, username, password, database_name, created_at"
+
+func scanAddon(row rowScanner) (*utils.Addon, error) {
+	var a utils.Addon
+	err := row.Scan(&a.ProjectSlug, &a.Engine, &a.Version, &a.Username, &a.Password, &a.Database, &a.CreatedAt)
+	return &a, err
+}
+
+func (r addonRepo) Create(a utils.Addon) error {
+	_, err := r.s.q().Exec(
+		`INSERT INTO addons (project_slug, engine, version, username, password, database_name) VALUES ($1, $2, $3, $4, $5, $6)`,
+		a.ProjectSlug,
+		a.Engine,
+		a.Version,
+		a.Username,
+		a.Password,
+		a.Database,
+	)
+	return err
+}
+
+// Get returns sql.ErrNoRows when the project is not an add-on.
+func (r addonRepo) Get(slug string) (*utils.Addon, error) {
+	return scanAddon(r.s.q().QueryRow("SELECT "+addonColumns+" FROM addons WHERE project_slug = $1", slug))
+}
+
+func (r addonRepo) List() ([]utils.Addon, error) {
+	rows, err := r.s.q().Query("SELECT " + addonColumns + " FROM addons ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addons := []utils.Addon{}
+	for rows.Next() {
+		a, err := scanAddon(rows)
+		if err != nil {
+			return nil, err
+		}
+		addons = append(addons, *a)
+	}
+
+	return addons, rows.Err()
+}
+
+type linkRepo struct{ s *sqlStore }
+
+func (r linkRepo) Create(l utils.ProjectLink) error {
+	_, err := r.s.q().Exec(
+		`INSERT INTO project_links (project_slug, target_slug, env_var) VALUES ($1, $2, $3)
+		ON CONFLICT (project_slug, target_slug) DO UPDATE SET env_var = excluded.env_var`,
+		l.ProjectSlug,
+		l.TargetSlug,
+		l.EnvVar,
+	)
+	return err
+}
+
+// List returns the services the project depends on.
+func (r linkRepo) List(slug string) ([]utils.ProjectLink, error) {
+	return r.query("SELECT project_slug, target_slug, env_var, created_at FROM project_links WHERE project_slug = $1 ORDER BY target_slug", slug)
+}
+
+// Dependents returns the links of every project that depends on slug.
+func (r linkRepo) Dependents(slug string) ([]utils.ProjectLink, error) {
+	return r.query("SELECT project_slug, target_slug, env_var, created_at FROM project_links WHERE target_slug = $1 ORDER BY project_slug", slug)
+}
+
+func (r linkRepo) query(query, slug string) ([]utils.ProjectLink, error) {
+	rows, err := r.s.q().Query(query, slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []utils.ProjectLink{}
+	for rows.Next() {
+		var l utils.ProjectLink
+		if err := rows.Scan(&l.ProjectSlug, &l.TargetSlug, &l.EnvVar, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+
+	return links, rows.Err()
+}
+
+func (r linkRepo) Delete(slug, target string) error {
+	_, err := r.s.q().Exec("DELETE FROM project_links WHERE project_slug = $1 AND target_slug = $2", slug, target)
+	return err
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"infracon/utils"
+	"strings"
+)
+
+type auditRepo struct{ s *sqlStore }
+
+func (r auditRepo) Add(l utils.AuditLog) error {
+	_, err := r.s.q().Exec(
+		`INSERT INTO audit_logs (actor_id, actor_email, ip, action, target_type, target, before, after) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		l.ActorID,
+		l.ActorEmail,
+		l.IP,
+		l.Action,
+		l.TargetType,
+		l.Target,
+		l.Before,
+		l.After,
+	)
+	return err
+}
+
+func (r auditRepo) List(f utils.AuditLogFilter) ([]utils.AuditLog, error) {
+	var conditions []string
+	var args []any
+	if f.ActorID != nil {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, *f.ActorID)
+	}
+	if f.Action != "" {
+		// A trailing dot matches a whole family of actions, e.g. "project." matches "project.deploy".
+		if strings.HasSuffix(f.Action, ".") {
+			conditions = append(conditions, "action LIKE ? ESCAPE '\\'")
+			args = append(args, escapeLike(f.Action)+"%")
+		} else {
+			conditions = append(conditions, "action = ?")
+			args = append(args, f.Action)
+		}
+	}
+	if f.TargetType != "" {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, f.TargetType)
+	}
+	if f.Target != "" {
+		conditions = append(conditions, "target = ?")
+		args = append(args, f.Target)
+	}
+	if f.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if f.Until != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, f.Until.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	if f.BeforeID > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, f.BeforeID)
+	}
+
+	query := "SELECT id, actor_id, actor_email, ip, action, target_type, target, before, after, created_at FROM audit_logs"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, f.Limit, f.Offset)
+
+	rows, err := r.s.q().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []utils.AuditLog{}
+	for rows.Next() {
+		var l utils.AuditLog
+		if err := rows.Scan(&l.ID, &l.ActorID, &l.ActorEmail, &l.IP, &l.Action, &l.TargetType, &l.Target, &l.Before, &l.After, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+
+	return logs, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"infracon/utils"
+	"strings"
+)
+
+type authEventRepo struct{ s *sqlStore }
+
+func (r authEventRepo) Record(e utils.AuthEvent) error {
+	_, err := r.s.q().Exec(
+		`INSERT INTO auth_events (event_type, user_id, email, ip, user_agent, success, details) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		e.EventType,
+		e.UserID,
+		e.Email,
+		e.IP,
+		e.UserAgent,
+		e.Success,
+		e.Details,
+	)
+	return err
+}
+
+func (r authEventRepo) List(f utils.AuthEventFilter) ([]utils.AuthEvent, error) {
+	var conditions []string
+	var args []any
+	if f.EventType != "" {
+		conditions = append(conditions, "event_type = ?")
+		args = append(args, f.EventType)
+	}
+	if f.Email != "" {
+		conditions = append(conditions, "email = ?")
+		args = append(args, f.Email)
+	}
+	if f.IP != "" {
+		conditions = append(conditions, "ip = ?")
+		args = append(args, f.IP)
+	}
+	if f.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if f.Until != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, f.Until.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	query := "SELECT id, event_type, user_id, email, ip, user_agent, success, details, created_at FROM auth_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, f.Limit, f.Offset)
+
+	rows, err := r.s.q().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []utils.AuthEvent{}
+	for rows.Next() {
+		var e utils.AuthEvent
+		if err := rows.Scan(&e.ID, &e.EventType, &e.UserID, &e.Email, &e.IP, &e.UserAgent, &e.Success, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+type userRepo struct{ s *sqlStore }
+
+// Any reports whether an admin user has signed up.
+func (r userRepo) Any() (bool, error) {
+	var exists bool
+	err := r.s.q().QueryRow("SELECT EXISTS (SELECT 1 FROM users)").Scan(&exists)
+	return exists, err
+}
+
+func (r userRepo) Create(email, passwordHash string) (int, error) {
+	var id int
+	err := r.s.q().QueryRow("INSERT INTO users (email, password) VALUES ($1, $2) RETURNING id", email, passwordHash).Scan(&id)
+	return id, err
+}
+
+func (r userRepo) GetIdByEmail(email string) (int, error) {
+	var id int
+	err := r.s.q().QueryRow("SELECT id FROM users WHERE lower(email) = lower($1)", email).Scan(&id)
+	return id, err
+}
+
+func (r userRepo) GetCredentials(email string) (int, string, error) {
+	var id int
+	var passwordHash string
+	err := r.s.q().QueryRow("SELECT id, password FROM users WHERE lower(email) = lower($1)", email).Scan(&id, &passwordHash)
+	return id, passwordHash, err
+}
+
+func (r userRepo) GetEmail(id int) (string, error) {
+	var email string
+	err := r.s.q().QueryRow("SELECT email FROM users WHERE id = $1", id).Scan(&email)
+	return email, err
+}
+
+// FirstID returns the user who signed up as the admin, or sql.ErrNoRows when nobody
+// has yet.
+func (r userRepo) FirstID() (int, error) {
+	var id int
+	err := r.s.q().QueryRow("SELECT id FROM users ORDER BY id LIMIT 1").Scan(&id)
+	return id, err
+}
+
+// SetPassword changes the user's password. It returns sql.ErrNoRows when there is no
+// such user.
+func (r userRepo) SetPassword(id int, passwordHash string) error {
+	res, err := r.s.q().Exec("UPDATE users SET password = $1 WHERE id = $2", passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
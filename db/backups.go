@@ -0,0 +1,255 @@
+package db
+
+import (
+	"infracon/utils"
+)
+
+type backupRepo struct{ s *sqlStore }
+
+const backupTargetColumns = "id, project_slug, name, kind, path, endpoint, bucket, region, prefix, access_key, secret_key, created_at"
+
+func scanBackupTarget(row rowScanner) (*utils.BackupTarget, error) {
+	var t utils.BackupTarget
+	err := row.Scan(&t.ID, &t.ProjectSlug, &t.Name, &t.Kind, &t.Path, &t.Endpoint, &t.Bucket, &t.Region, &t.Prefix, &t.AccessKey, &t.SecretKey, &t.CreatedAt)
+	return &t, err
+}
+
+func (r backupRepo) CreateTarget(t utils.BackupTarget) (int, error) {
+	var id int
+	err := r.s.q().QueryRow(
+		`INSERT INTO backup_targets (project_slug, name, kind, path, endpoint, bucket, region, prefix, access_key, secret_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		t.ProjectSlug,
+		t.Name,
+		t.Kind,
+		t.Path,
+		t.Endpoint,
+		t.Bucket,
+		t.Region,
+		t.Prefix,
+		t.AccessKey,
+		t.SecretKey,
+	).Scan(&id)
+	return id, err
+}
+
+func (r backupRepo) GetTarget(slug string, id int) (*utils.BackupTarget, error) {
+	return scanBackupTarget(r.s.q().QueryRow("SELECT "+backupTargetColumns+" FROM backup_targets WHERE project_slug = $1 AND id = $2", slug, id))
+}
+
+func (r backupRepo) ListTargets(slug string) ([]utils.BackupTarget, error) {
+	rows, err := r.s.q().Query("SELECT "+backupTargetColumns+" FROM backup_targets WHERE project_slug = $1 ORDER BY id", slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []utils.BackupTarget{}
+	for rows.Next() {
+		t, err := scanBackupTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, *t)
+	}
+
+	return targets, rows.Err()
+}
+
+func (r backupRepo) DeleteTarget(slug string, id int) error {
+	_, err := r.s.q().Exec("DELETE FROM backup_targets WHERE project_slug = $1 AND id = $2", slug, id)
+	return err
+}
+
+const backupPolicyColumns = "project_slug, enabled, schedule, target_id, keep_last, keep_days"
+
+func scanBackupPolicy(row rowScanner) (*utils.BackupPolicy, error) {
+	var p utils.BackupPolicy
+	err := row.Scan(&p.ProjectSlug, &p.Enabled, &p.Schedule, &p.TargetID, &p.KeepLast, &p.KeepDays)
+	return &p, err
+}
+
+// GetPolicy returns sql.ErrNoRows when the project has no policy yet.
+func (r backupRepo) GetPolicy(slug string) (*utils.BackupPolicy, error) {
+	return scanBackupPolicy(r.s.q().QueryRow("SELECT "+backupPolicyColumns+" FROM backup_policies WHERE project_slug = $1", slug))
+}
+
+// EnabledPolicies returns the policies the scheduler has to run.
+func (r backupRepo) EnabledPolicies() ([]utils.BackupPolicy, error) {
+	rows, err := r.s.q().Query("SELECT " + backupPolicyColumns + " FROM backup_policies WHERE enabled = 1 AND schedule <> '' AND target_id IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []utils.BackupPolicy{}
+	for rows.Next() {
+		p, err := scanBackupPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *p)
+	}
+
+	return policies, rows.Err()
+}
+
+func (r backupRepo) SetPolicy(p utils.BackupPolicy) error {
+	_, err := r.s.q().Exec(
+		`INSERT INTO backup_policies (project_slug, enabled, schedule, target_id, keep_last, keep_days) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (project_slug) DO UPDATE SET
+			enabled = excluded.enabled,
+			schedule = excluded.schedule,
+			target_id = excluded.target_id,
+			keep_last = excluded.keep_last,
+			keep_days = excluded.keep_days,
+			updated_at = CURRENT_TIMESTAMP`,
+		p.ProjectSlug,
+		p.Enabled,
+		p.Schedule,
+		p.TargetID,
+		p.KeepLast,
+		p.KeepDays,
+	)
+	return err
+}
+
+const snapshotColumns = "id, project_slug, target_id, trigger, status, size_bytes, message, created_at, finished_at"
+
+func scanSnapshot(row rowScanner) (*utils.Snapshot, error) {
+	s := utils.Snapshot{Files: []utils.SnapshotFile{}}
+	err := row.Scan(&s.ID, &s.ProjectSlug, &s.TargetID, &s.Trigger, &s.Status, &s.SizeBytes, &s.Message, &s.CreatedAt, &s.FinishedAt)
+	return &s, err
+}
+
+func (r backupRepo) CreateSnapshot(s utils.Snapshot) (int, error) {
+	var id int
+	err := r.s.q().QueryRow(
+		`INSERT INTO snapshots (project_slug, target_id, trigger, status) VALUES ($1, $2, $3, $4) RETURNING id`,
+		s.ProjectSlug,
+		s.TargetID,
+		s.Trigger,
+		s.Status,
+	).Scan(&id)
+	return id, err
+}
+
+func (r backupRepo) AddSnapshotFile(f utils.SnapshotFile) error {
+	_, err := r.s.q().Exec(
+		"INSERT OR REPLACE INTO snapshot_files (snapshot_id, volume_name, object_key, size_bytes) VALUES ($1, $2, $3, $4)",
+		f.SnapshotID,
+		f.VolumeName,
+		f.Key,
+		f.SizeBytes,
+	)
+	return err
+}
+
+func (r backupRepo) FinishSnapshot(id int, status, message string) error {
+	_, err := r.s.q().Exec(
+		`UPDATE snapshots SET
+			status = $1,
+			message = $2,
+			size_bytes = (SELECT COALESCE(SUM(size_bytes), 0) FROM snapshot_files WHERE snapshot_id = $3),
+			finished_at = COALESCE(finished_at, CURRENT_TIMESTAMP)
+		WHERE id = $3`,
+		status,
+		message,
+		id,
+	)
+	return err
+}
+
+// FailInterruptedSnapshots marks snapshots that were still running as failed, e.g.
+// after a crash.
+func (r backupRepo) FailInterruptedSnapshots(message string) (int64, error) {
+	res, err := r.s.q().Exec(
+		`UPDATE snapshots SET
+			status = 'failed',
+			message = $1,
+			finished_at = COALESCE(finished_at, CURRENT_TIMESTAMP)
+		WHERE status = 'running'`,
+		message,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r backupRepo) GetSnapshot(slug string, id int) (*utils.Snapshot, error) {
+	s, err := scanSnapshot(r.s.q().QueryRow("SELECT "+snapshotColumns+" FROM snapshots WHERE project_slug = $1 AND id = $2", slug, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.s.q().Query("SELECT snapshot_id, volume_name, object_key, size_bytes FROM snapshot_files WHERE snapshot_id = $1 ORDER BY volume_name", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f utils.SnapshotFile
+		if err := rows.Scan(&f.SnapshotID, &f.VolumeName, &f.Key, &f.SizeBytes); err != nil {
+			return nil, err
+		}
+		s.Files = append(s.Files, f)
+	}
+
+	return s, rows.Err()
+}
+
+// ListSnapshots returns the project's snapshots, newest first, with their files.
+func (r backupRepo) ListSnapshots(slug string) ([]utils.Snapshot, error) {
+	rows, err := r.s.q().Query("SELECT "+snapshotColumns+" FROM snapshots WHERE project_slug = $1 ORDER BY id DESC", slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []utils.Snapshot{}
+	index := map[int]int{}
+	for rows.Next() {
+		s, err := scanSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[s.ID] = len(snapshots)
+		snapshots = append(snapshots, *s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	files, err := r.s.q().Query(
+		"SELECT snapshot_id, volume_name, object_key, size_bytes FROM snapshot_files WHERE snapshot_id IN (SELECT id FROM snapshots WHERE project_slug = $1) ORDER BY volume_name",
+		slug,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer files.Close()
+
+	for files.Next() {
+		var f utils.SnapshotFile
+		if err := files.Scan(&f.SnapshotID, &f.VolumeName, &f.Key, &f.SizeBytes); err != nil {
+			return nil, err
+		}
+		if i, ok := index[f.SnapshotID]; ok {
+			snapshots[i].Files = append(snapshots[i].Files, f)
+		}
+	}
+
+	return snapshots, files.Err()
+}
+
+func (r backupRepo) DeleteSnapshot(slug string, id int) error {
+	return r.s.atomic(func(q querier) error {
+		if _, err := q.Exec("DELETE FROM snapshot_files WHERE snapshot_id IN (SELECT id FROM snapshots WHERE project_slug = $1 AND id = $2)", slug, id); err != nil {
+			return err
+		}
+		_, err := q.Exec("DELETE FROM snapshots WHERE project_slug = $1 AND id = $2", slug, id)
+		return err
+	})
+}
@@ -1,58 +1,27 @@
 package db
 
 import (
-	"database/sql"
-	"errors"
-	"fmt"
 	"infracon/utils"
-	"strings"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
-func GetDatabase() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "./infracon.db")
-	if err != nil {
-		return nil, err
-	}
-	return db, nil
-}
+type projectRepo struct{ s *sqlStore }
 
-func GetGithubToken() (string, error) {
-	db, err := GetDatabase()
-	if err != nil {
-		return "", err
-	}
+const projectColumns = "id, name, slug, type, env, github_repo, project_path, status, container_name, current_image, created_at, updated_at"
 
-	var token string
-	if err := db.QueryRow("SELECT token FROM github_tokens").Scan(&token); err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return "", err
-	}
-
-	return token, nil
+func scanProject(row rowScanner) (*utils.Project, error) {
+	var p utils.Project
+	err := row.Scan(&p.ID, &p.Name, &p.Slug, &p.Type, &p.Env, &p.GithubRepo, &p.ProjectPath, &p.Status, &p.ContainerName, &p.CurrentImage, &p.CreatedAt, &p.UpdatedAt)
+	return &p, err
 }
 
-func CreateProject(p utils.Project) (int, error) {
-	db, err := GetDatabase()
-	if err != nil {
-		return 0, err
-	}
-
+func (r projectRepo) Create(p utils.Project) (int, error) {
 	var id int
-	if err := db.QueryRow("INSERT INTO projects (name, slug, type) VALUES ($1, $2, $3) RETURNING id", p.Name, p.Slug, p.Type).Scan(&id); err != nil {
-		return 0, err
-	}
-
-	return id, nil
+	err := r.s.q().QueryRow("INSERT INTO projects (name, slug, type, env) VALUES ($1, $2, $3, $4) RETURNING id", p.Name, p.Slug, p.Type, p.Env).Scan(&id)
+	return id, err
 }
 
-func UpdateProject(p utils.Project) error {
-	db, err := GetDatabase()
-	if err != nil {
-		return err
-	}
-
-	_, err = db.Exec(`
+func (r projectRepo) Update(p utils.Project) error {
+	_, err := r.s.q().Exec(`
 		UPDATE projects SET
 			name = CASE 
 				WHEN $1 IS NOT NULL AND $1 <> '' THEN $1 
@@ -90,7 +59,7 @@ func UpdateProject(p utils.Project) error {
 				WHEN $9 IS NOT NULL THEN $9 
 				ELSE current_image 
 			END,
-			updated_at = NOW()
+			updated_at = CURRENT_TIMESTAMP
 		WHERE id = $10
 	`,
 		p.Name,
@@ -108,101 +77,68 @@ func UpdateProject(p utils.Project) error {
 	return err
 }
 
-func SaveLogs(slug string, logs []string) error {
-	db, err := GetDatabase()
-	if err != nil {
-		return err
-	}
-
-	var values []string
-	for _, log := range logs {
-		values = append(values, fmt.Sprintf("('%s', '%s')", slug, log))
-	}
-
-	query := fmt.Sprintf(`
-		INSERT INTO logs (project_slug, log)
-		VALUES %s
-	`, strings.Join(values, ", "))
-
-	_, err = db.Exec(query)
-	if err != nil {
-		return err
-	}
-
-	return err
+func (r projectRepo) Get(slug string) (*utils.Project, error) {
+	return scanProject(r.s.q().QueryRow("SELECT "+projectColumns+" FROM projects WHERE slug = $1", slug))
 }
 
-func GetProject(slug string) (*utils.Project, error) {
-	db, err := GetDatabase()
-	if err != nil {
-		return nil, err
-	}
-
-	var p utils.Project
-	err = db.QueryRow("SELECT name, slug, type, env, project_path, status, container_name, current_image from projects where slug = $1", slug).Scan(&p.Name, &p.Slug, &p.Type, &p.Env, &p.ProjectPath, &p.Status, &p.Status, &p.ContainerName, &p.CurrentImage)
-	return &p, err
+// GetByContainer finds the project whose current release runs in the container.
+func (r projectRepo) GetByContainer(containerName string) (*utils.Project, error) {
+	return scanProject(r.s.q().QueryRow("SELECT "+projectColumns+" FROM projects WHERE container_name = $1", containerName))
 }
 
-func GetProjects() ([]utils.Project, error) {
-	db, err := GetDatabase()
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := db.Query("SELECT name, slug, type, env, project_path, status, container_name, current_image, created_at, updated_at FROM projects ORDER BY updated_at DESC")
+func (r projectRepo) List() ([]utils.Project, error) {
+	rows, err := r.s.q().Query("SELECT " + projectColumns + " FROM projects ORDER BY updated_at DESC")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var projects []utils.Project
 	for rows.Next() {
-		var p utils.Project
-		err = rows.Scan(&p.Name, &p.Slug, &p.Type, &p.Env, &p.ProjectPath, &p.Status, &p.Status, &p.ContainerName, &p.CurrentImage, &p.CreatedAt, &p.CreatedAt)
+		p, err := scanProject(rows)
 		if err != nil {
 			return nil, err
 		}
-		projects = append(projects, p)
+		projects = append(projects, *p)
 	}
 
-	return projects, nil
+	return projects, rows.Err()
 }
 
-func AddDockerImage(slug, imageName string) error {
-	db, err := GetDatabase()
-	if err != nil {
-		return err
-	}
-
-	return db.QueryRow("INSERT INTO docker_images (project_slug, image_tag) VALUES($1, $2) RETURNING id", slug, imageName).Scan(new(int))
-}
-
-func HasDockerImage(slug string, imageTag string) (bool, error) {
-	db, err := GetDatabase()
-	if err != nil {
-		return false, err
-	}
-	defer db.Close()
-
-	var count int
-	err = db.QueryRow(
-		`SELECT COUNT(*) FROM docker_images WHERE slug = ? AND image_tag = ?`,
-		slug, imageTag,
-	).Scan(&count)
-	if err != nil {
-		return false, err
-	}
-	return count > 0, nil
+// Delete removes a project and everything recorded about it except the audit trail and
+// the data that outlives it: retained images unless purgeImages is set, volumes unless
+// purgeVolumes is set, and its snapshots and backup targets, whose archives are never
+// removed with the project.
+func (r projectRepo) Delete(slug string, purgeImages, purgeVolumes bool) error {
+	statements := []string{
+		"DELETE FROM deployment_logs WHERE deployment_id IN (SELECT id FROM deployments WHERE project_slug = $1)",
+		"DELETE FROM deployments WHERE project_slug = $1",
+		"DELETE FROM logs WHERE project_slug = $1",
+		"DELETE FROM metrics WHERE project_slug = $1",
+		"DELETE FROM log_drains WHERE project_slug = $1",
+		"DELETE FROM log_retention_policies WHERE project_slug = $1",
+		"DELETE FROM project_resources WHERE project_slug = $1",
+		"DELETE FROM project_incidents WHERE project_slug = $1",
+		"DELETE FROM image_retention_policies WHERE project_slug = $1",
+		"DELETE FROM pinned_images WHERE project_slug = $1",
+		"DELETE FROM backup_policies WHERE project_slug = $1",
+		"DELETE FROM project_links WHERE project_slug = $1 OR target_slug = $1",
+		"DELETE FROM addons WHERE project_slug = $1",
+	}
+	if purgeImages {
+		statements = append(statements, "DELETE FROM docker_images WHERE project_slug = $1")
+	}
+	if purgeVolumes {
+		statements = append(statements, "DELETE FROM project_volumes WHERE project_slug = $1")
+	}
+	statements = append(statements, "DELETE FROM projects WHERE slug = $1")
+
+	return r.s.atomic(func(q querier) error {
+		for _, statement := range statements {
+			if _, err := q.Exec(statement, slug); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
-
-// func GetDockerImages(slug string) ([]utils.DockerImage, error) {
-// 	db, err := GetDatabase()
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	defer db.Close()
-
-// 	rows, err := db.Query(
-// 		`SELECT image_tag FROM docker_images WHERE project_slug = $1 ORDER BY created_at DESC`,
-// 		slug,
-// 	)
-// }
@@ -0,0 +1,22 @@
+// Package dbtest opens throwaway databases for tests of the packages built on db.
+package dbtest
+
+import (
+	"infracon/db"
+	"testing"
+)
+
+// OpenStore returns a store over a migrated, private :memory: database that is
+// closed when the test ends.
+func OpenStore(t testing.TB) db.Store {
+	t.Helper()
+	conn, err := db.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := db.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	return db.NewStore(conn)
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"infracon/utils"
+)
+
+type deploymentRepo struct{ s *sqlStore }
+
+const deploymentColumns = "id, project_slug, kind, source, image_tag, container_name, commit_sha, previous_image, status, message, created_at, finished_at"
+
+func scanDeployment(row rowScanner) (*utils.Deployment, error) {
+	var d utils.Deployment
+	err := row.Scan(&d.ID, &d.ProjectSlug, &d.Kind, &d.Source, &d.ImageTag, &d.ContainerName, &d.CommitSha, &d.PreviousImage, &d.Status, &d.Message, &d.CreatedAt, &d.FinishedAt)
+	return &d, err
+}
+
+func (r deploymentRepo) Create(d utils.Deployment) (int, error) {
+	var id int
+	err := r.s.q().QueryRow(
+		`INSERT INTO deployments (project_slug, kind, source, image_tag, container_name, commit_sha, previous_image, status, message) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		d.ProjectSlug,
+		d.Kind,
+		d.Source,
+		d.ImageTag,
+		d.ContainerName,
+		d.CommitSha,
+		d.PreviousImage,
+		d.Status,
+		d.Message,
+	).Scan(&id)
+	return id, err
+}
+
+func (r deploymentRepo) SetCommit(id int, commitSha string) error {
+	_, err := r.s.q().Exec("UPDATE deployments SET commit_sha = $1 WHERE id = $2", commitSha, id)
+	return err
+}
+
+// Finish records the final status of a deployment. Terminal statuses are "running",
+// "failed" and, once replaced, "superseded" or "rolled_back".
+func (r deploymentRepo) Finish(id int, status, message string) error {
+	_, err := r.s.q().Exec(
+		`UPDATE deployments SET
+			status = $1,
+			message = CASE WHEN $2 <> '' THEN $2 ELSE message END,
+			finished_at = COALESCE(finished_at, CURRENT_TIMESTAMP)
+		WHERE id = $3`,
+		status,
+		message,
+		id,
+	)
+	return err
+}
+
+// FailInterrupted marks deployments that were still building or deploying as failed.
+// Only call it before any deployment can have started in this process.
+func (r deploymentRepo) FailInterrupted(message string) (int64, error) {
+	res, err := r.s.q().Exec(
+		`UPDATE deployments SET
+			status = 'failed',
+			message = $1,
+			finished_at = COALESCE(finished_at, CURRENT_TIMESTAMP)
+		WHERE status IN ('building', 'deploying')`,
+		message,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Supersede marks every running deployment of the project except keepId as replaced.
+func (r deploymentRepo) Supersede(slug string, keepId int, status string) error {
+	_, err := r.s.q().Exec(
+		"UPDATE deployments SET status = $1 WHERE project_slug = $2 AND id <> $3 AND status = 'running'",
+		status,
+		slug,
+		keepId,
+	)
+	return err
+}
+
+func (r deploymentRepo) Get(slug string, id int) (*utils.Deployment, error) {
+	return scanDeployment(r.s.q().QueryRow("SELECT "+deploymentColumns+" FROM deployments WHERE project_slug = $1 AND id = $2", slug, id))
+}
+
+func (r deploymentRepo) List(slug string, limit, offset int) ([]utils.Deployment, error) {
+	rows, err := r.s.q().Query(
+		"SELECT "+deploymentColumns+" FROM deployments WHERE project_slug = $1 ORDER BY id DESC LIMIT $2 OFFSET $3",
+		slug,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deployments := []utils.Deployment{}
+	for rows.Next() {
+		d, err := scanDeployment(rows)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, *d)
+	}
+
+	return deployments, rows.Err()
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"infracon/utils"
+)
+
+type drainRepo struct{ s *sqlStore }
+
+const logDrainColumns = "id, project_slug, name, kind, endpoint, protocol, token, sources, created_at"
+
+func scanLogDrain(row rowScanner) (*utils.LogDrain, error) {
+	var d utils.LogDrain
+	err := row.Scan(&d.ID, &d.ProjectSlug, &d.Name, &d.Kind, &d.Endpoint, &d.Protocol, &d.Token, &d.Sources, &d.CreatedAt)
+	return &d, err
+}
+
+func (r drainRepo) Create(d utils.LogDrain) (int, error) {
+	var id int
+	err := r.s.q().QueryRow(
+		`INSERT INTO log_drains (project_slug, name, kind, endpoint, protocol, token, sources) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		d.ProjectSlug,
+		d.Name,
+		d.Kind,
+		d.Endpoint,
+		d.Protocol,
+		d.Token,
+		d.Sources,
+	).Scan(&id)
+	return id, err
+}
+
+func (r drainRepo) Get(slug string, id int) (*utils.LogDrain, error) {
+	return scanLogDrain(r.s.q().QueryRow("SELECT "+logDrainColumns+" FROM log_drains WHERE project_slug = $1 AND id = $2", slug, id))
+}
+
+// List returns the drains of a project, or of every project when slug is empty.
+func (r drainRepo) List(slug string) ([]utils.LogDrain, error) {
+	query := "SELECT " + logDrainColumns + " FROM log_drains"
+	args := []any{}
+	if slug != "" {
+		query += " WHERE project_slug = $1"
+		args = append(args, slug)
+	}
+
+	rows, err := r.s.q().Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drains := []utils.LogDrain{}
+	for rows.Next() {
+		d, err := scanLogDrain(rows)
+		if err != nil {
+			return nil, err
+		}
+		drains = append(drains, *d)
+	}
+
+	return drains, rows.Err()
+}
+
+func (r drainRepo) Delete(slug string, id int) error {
+	_, err := r.s.q().Exec("DELETE FROM log_drains WHERE project_slug = $1 AND id = $2", slug, id)
+	return err
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"infracon/utils"
+)
+
+type imageRepo struct{ s *sqlStore }
+
+const imageColumns = "id, project_slug, image_tag, commit_sha, deployment_id, created_at"
+
+func scanImage(row rowScanner) (*utils.ProjectImage, error) {
+	var img utils.ProjectImage
+	err := row.Scan(&img.ID, &img.ProjectSlug, &img.ImageTag, &img.CommitSha, &img.DeploymentID, &img.CreatedAt)
+	return &img, err
+}
+
+func (r imageRepo) Add(img utils.ProjectImage) error {
+	_, err := r.s.q().Exec(
+		"INSERT OR IGNORE INTO docker_images (project_slug, image_tag, commit_sha, deployment_id) VALUES ($1, $2, $3, $4)",
+		img.ProjectSlug,
+		img.ImageTag,
+		img.CommitSha,
+		img.DeploymentID,
+	)
+	return err
+}
+
+func (r imageRepo) Has(slug, imageTag string) (bool, error) {
+	var count int
+	err := r.s.q().QueryRow("SELECT COUNT(*) FROM docker_images WHERE project_slug = $1 AND image_tag = $2", slug, imageTag).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r imageRepo) Get(slug, imageTag string) (*utils.ProjectImage, error) {
+	return scanImage(r.s.q().QueryRow("SELECT "+imageColumns+" FROM docker_images WHERE project_slug = $1 AND image_tag = $2", slug, imageTag))
+}
+
+func (r imageRepo) List(slug string) ([]utils.ProjectImage, error) {
+	rows, err := r.s.q().Query("SELECT "+imageColumns+" FROM docker_images WHERE project_slug = $1 ORDER BY id DESC", slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []utils.ProjectImage{}
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *img)
+	}
+
+	return images, rows.Err()
+}
+
+// Delete forgets a retained image and its pin.
+func (r imageRepo) Delete(slug, imageTag string) error {
+	return r.s.atomic(func(q querier) error {
+		if _, err := q.Exec("DELETE FROM docker_images WHERE project_slug = $1 AND image_tag = $2", slug, imageTag); err != nil {
+			return err
+		}
+		_, err := q.Exec("DELETE FROM pinned_images WHERE project_slug = $1 AND image_tag = $2", slug, imageTag)
+		return err
+	})
+}
+
+func (r imageRepo) Pin(slug, imageTag string) error {
+	_, err := r.s.q().Exec("INSERT OR IGNORE INTO pinned_images (project_slug, image_tag) VALUES ($1, $2)", slug, imageTag)
+	return err
+}
+
+func (r imageRepo) Unpin(slug, imageTag string) error {
+	_, err := r.s.q().Exec("DELETE FROM pinned_images WHERE project_slug = $1 AND image_tag = $2", slug, imageTag)
+	return err
+}
+
+// Pinned returns the set of the project's image tags that must never be collected.
+func (r imageRepo) Pinned(slug string) (map[string]bool, error) {
+	rows, err := r.s.q().Query("SELECT image_tag FROM pinned_images WHERE project_slug = $1", slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pinned := map[string]bool{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		pinned[tag] = true
+	}
+
+	return pinned, rows.Err()
+}
+
+// GetRetentionPolicy returns sql.ErrNoRows when the project uses the defaults.
+func (r imageRepo) GetRetentionPolicy(slug string) (*utils.ImageRetentionPolicy, error) {
+	var p utils.ImageRetentionPolicy
+	err := r.s.q().QueryRow("SELECT project_slug, keep_last, keep_days FROM image_retention_policies WHERE project_slug = $1", slug).Scan(&p.ProjectSlug, &p.KeepLast, &p.KeepDays)
+	return &p, err
+}
+
+func (r imageRepo) SetRetentionPolicy(p utils.ImageRetentionPolicy) error {
+	_, err := r.s.q().Exec(
+		`INSERT INTO image_retention_policies (project_slug, keep_last, keep_days) VALUES ($1, $2, $3)
+		ON CONFLICT (project_slug) DO UPDATE SET keep_last = excluded.keep_last, keep_days = excluded.keep_days, updated_at = CURRENT_TIMESTAMP`,
+		p.ProjectSlug,
+		p.KeepLast,
+		p.KeepDays,
+	)
+	return err
+}
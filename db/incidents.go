@@ -0,0 +1,47 @@
+package db
+
+import (
+	"infracon/utils"
+)
+
+type incidentRepo struct{ s *sqlStore }
+
+const incidentColumns = "id, project_slug, container_name, kind, exit_code, message, created_at"
+
+func (r incidentRepo) Create(i utils.Incident) (int, error) {
+	var id int
+	err := r.s.q().QueryRow(
+		`INSERT INTO project_incidents (project_slug, container_name, kind, exit_code, message) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		i.ProjectSlug,
+		i.ContainerName,
+		i.Kind,
+		i.ExitCode,
+		i.Message,
+	).Scan(&id)
+	return id, err
+}
+
+// List returns a project's incidents, newest first.
+func (r incidentRepo) List(slug string, limit, offset int) ([]utils.Incident, error) {
+	rows, err := r.s.q().Query(
+		"SELECT "+incidentColumns+" FROM project_incidents WHERE project_slug = $1 ORDER BY id DESC LIMIT $2 OFFSET $3",
+		slug,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	incidents := []utils.Incident{}
+	for rows.Next() {
+		var i utils.Incident
+		if err := rows.Scan(&i.ID, &i.ProjectSlug, &i.ContainerName, &i.Kind, &i.ExitCode, &i.Message, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, i)
+	}
+
+	return incidents, rows.Err()
+}
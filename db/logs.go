@@ -0,0 +1,73 @@
+package db
+
+import (
+	"infracon/utils"
+)
+
+type logRepo struct{ s *sqlStore }
+
+// AppendDeploymentLogs stores a batch of build log lines in one transaction.
+func (r logRepo) AppendDeploymentLogs(lines []utils.DeploymentLog) error {
+	return r.s.atomic(func(q querier) error {
+		stmt, err := q.Prepare("INSERT OR IGNORE INTO deployment_logs (deployment_id, seq, kind, phase, message, created_at) VALUES ($1, $2, $3, $4, $5, $6)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, l := range lines {
+			if _, err := stmt.Exec(l.DeploymentID, l.Seq, l.Kind, l.Phase, l.Message, l.CreatedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r logRepo) LastDeploymentLogSeq(deploymentId int) (int, error) {
+	var seq int
+	err := r.s.q().QueryRow("SELECT COALESCE(MAX(seq), 0) FROM deployment_logs WHERE deployment_id = $1", deploymentId).Scan(&seq)
+	return seq, err
+}
+
+func (r logRepo) GetDeploymentLogs(deploymentId, afterSeq, limit int) ([]utils.DeploymentLog, error) {
+	rows, err := r.s.q().Query(
+		"SELECT deployment_id, seq, kind, phase, message, created_at FROM deployment_logs WHERE deployment_id = $1 AND seq > $2 ORDER BY seq LIMIT $3",
+		deploymentId,
+		afterSeq,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []utils.DeploymentLog{}
+	for rows.Next() {
+		var l utils.DeploymentLog
+		if err := rows.Scan(&l.DeploymentID, &l.Seq, &l.Kind, &l.Phase, &l.Message, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+
+	return lines, rows.Err()
+}
+
+// GetRetentionPolicy returns sql.ErrNoRows when the project uses the defaults.
+func (r logRepo) GetRetentionPolicy(slug string) (*utils.LogRetentionPolicy, error) {
+	var p utils.LogRetentionPolicy
+	err := r.s.q().QueryRow("SELECT project_slug, max_age_days, max_size_mb FROM log_retention_policies WHERE project_slug = $1", slug).Scan(&p.ProjectSlug, &p.MaxAgeDays, &p.MaxSizeMB)
+	return &p, err
+}
+
+func (r logRepo) SetRetentionPolicy(p utils.LogRetentionPolicy) error {
+	_, err := r.s.q().Exec(
+		`INSERT INTO log_retention_policies (project_slug, max_age_days, max_size_mb) VALUES ($1, $2, $3)
+		ON CONFLICT (project_slug) DO UPDATE SET max_age_days = excluded.max_age_days, max_size_mb = excluded.max_size_mb`,
+		p.ProjectSlug,
+		p.MaxAgeDays,
+		p.MaxSizeMB,
+	)
+	return err
+}
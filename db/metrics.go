@@ -0,0 +1,87 @@
+package db
+
+import (
+	"infracon/utils"
+	"time"
+)
+
+type metricRepo struct{ s *sqlStore }
+
+func (r metricRepo) Upsert(m utils.MetricPoint) error {
+	_, err := r.s.q().Exec(
+		`INSERT INTO metrics (project_slug, resolution, bucket, samples, cpu_avg, cpu_max, mem_avg, mem_max, mem_limit, net_rx, net_tx, block_read, block_write)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (project_slug, resolution, bucket) DO UPDATE SET
+			samples = excluded.samples, cpu_avg = excluded.cpu_avg, cpu_max = excluded.cpu_max,
+			mem_avg = excluded.mem_avg, mem_max = excluded.mem_max, mem_limit = excluded.mem_limit,
+			net_rx = excluded.net_rx, net_tx = excluded.net_tx, block_read = excluded.block_read, block_write = excluded.block_write`,
+		m.ProjectSlug,
+		m.Resolution,
+		m.Bucket.Unix(),
+		m.Samples,
+		m.CPUAvg,
+		m.CPUMax,
+		m.MemAvg,
+		m.MemMax,
+		m.MemLimit,
+		m.NetRx,
+		m.NetTx,
+		m.BlockRead,
+		m.BlockWrite,
+	)
+	return err
+}
+
+// Rollup rebuilds the buckets of resolution `to` that start at or after since
+// from the finer resolution `from`. Averages are weighted by sample count and I/O is summed.
+func (r metricRepo) Rollup(from, to string, size time.Duration, since time.Time) error {
+	seconds := int64(size.Seconds())
+	_, err := r.s.q().Exec(
+		`INSERT OR REPLACE INTO metrics (project_slug, resolution, bucket, samples, cpu_avg, cpu_max, mem_avg, mem_max, mem_limit, net_rx, net_tx, block_read, block_write)
+		SELECT project_slug, $1, (bucket / $2) * $2, SUM(samples),
+			SUM(cpu_avg * samples) / SUM(samples), MAX(cpu_max),
+			SUM(mem_avg * samples) / SUM(samples), MAX(mem_max), MAX(mem_limit),
+			SUM(net_rx), SUM(net_tx), SUM(block_read), SUM(block_write)
+		FROM metrics
+		WHERE resolution = $3 AND bucket >= $4 AND samples > 0
+		GROUP BY project_slug, (bucket / $2) * $2`,
+		to,
+		seconds,
+		from,
+		(since.Unix()/seconds)*seconds,
+	)
+	return err
+}
+
+func (r metricRepo) Prune(resolution string, before time.Time) error {
+	_, err := r.s.q().Exec("DELETE FROM metrics WHERE resolution = $1 AND bucket < $2", resolution, before.Unix())
+	return err
+}
+
+func (r metricRepo) List(slug, resolution string, from, to time.Time) ([]utils.MetricPoint, error) {
+	rows, err := r.s.q().Query(
+		`SELECT bucket, samples, cpu_avg, cpu_max, mem_avg, mem_max, mem_limit, net_rx, net_tx, block_read, block_write
+		FROM metrics WHERE project_slug = $1 AND resolution = $2 AND bucket >= $3 AND bucket <= $4 ORDER BY bucket`,
+		slug,
+		resolution,
+		from.Unix(),
+		to.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []utils.MetricPoint{}
+	for rows.Next() {
+		m := utils.MetricPoint{ProjectSlug: slug, Resolution: resolution}
+		var bucket int64
+		if err := rows.Scan(&bucket, &m.Samples, &m.CPUAvg, &m.CPUMax, &m.MemAvg, &m.MemMax, &m.MemLimit, &m.NetRx, &m.NetTx, &m.BlockRead, &m.BlockWrite); err != nil {
+			return nil, err
+		}
+		m.Bucket = time.Unix(bucket, 0).UTC()
+		points = append(points, m)
+	}
+
+	return points, rows.Err()
+}
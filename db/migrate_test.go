@@ -0,0 +1,80 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func openTestDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestMigrateCreatesEveryTable(t *testing.T) {
+	conn := openTestDatabase(t)
+
+	tables := []string{
+		"users", "github_tokens", "projects", "docker_images", "deployments", "logs",
+		"deployment_logs", "auth_events", "audit_logs", "metrics", "log_drains",
+		"project_resources", "log_retention_policies", "project_volumes",
+		"image_retention_policies", "pinned_images", "project_incidents", "backup_targets",
+		"backup_policies", "snapshots", "snapshot_files", "addons", "project_links",
+		"schema_migrations",
+	}
+	for _, table := range tables {
+		var name string
+		if err := conn.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = $1", table).Scan(&name); err != nil {
+			t.Errorf("table %s missing: %s", table, err)
+		}
+	}
+
+	// projects used to lack the comma before updated_at, which stopped the whole schema.
+	if _, err := conn.Exec("SELECT updated_at FROM projects"); err != nil {
+		t.Errorf("projects.updated_at: %s", err)
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	conn := openTestDatabase(t)
+
+	if err := Migrate(conn); err != nil {
+		t.Fatalf("second run: %s", err)
+	}
+	statuses, err := GetMigrationStatus(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range statuses {
+		if m.AppliedAt == nil {
+			t.Errorf("migration %d_%s still pending", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrateRefusesUnknownOrEditedMigrations(t *testing.T) {
+	conn := openTestDatabase(t)
+
+	if _, err := conn.Exec("UPDATE schema_migrations SET checksum = 'edited' WHERE version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(conn); err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Errorf("edited migration: got %v", err)
+	}
+
+	conn = openTestDatabase(t)
+	if _, err := conn.Exec("INSERT INTO schema_migrations (version, name, checksum) VALUES (9999, 'future', 'x')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(conn); err == nil || !strings.Contains(err.Error(), "upgrade") {
+		t.Errorf("newer database: got %v", err)
+	}
+}
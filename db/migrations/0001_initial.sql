@@ -0,0 +1,270 @@
+-- Schema as of the first release with migrations. Tables use IF NOT EXISTS so
+-- installs that predate schema_migrations adopt it without losing data.
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS github_tokens (
+	user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	token TEXT NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS projects (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	slug TEXT NOT NULL UNIQUE,
+	type TEXT,
+	env TEXT,
+	github_repo TEXT,
+	project_path TEXT,
+	top_level_directories TEXT,
+	status TEXT NOT NULL DEFAULT 'building',
+	container_name TEXT,
+	current_image TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS docker_images (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_slug TEXT NOT NULL,
+	image_tag TEXT,
+	commit_sha TEXT,
+	deployment_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (project_slug, image_tag)
+);
+
+CREATE TABLE IF NOT EXISTS deployments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_slug TEXT NOT NULL,
+	kind TEXT NOT NULL DEFAULT 'deploy',
+	source TEXT NOT NULL DEFAULT '',
+	image_tag TEXT NOT NULL,
+	container_name TEXT NOT NULL,
+	commit_sha TEXT,
+	previous_image TEXT,
+	status TEXT NOT NULL DEFAULT 'building',
+	message TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	finished_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS deployments_project_idx ON deployments (project_slug, id);
+
+CREATE TABLE IF NOT EXISTS logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_slug TEXT NOT NULL,
+	log TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS deployment_logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	deployment_id INTEGER NOT NULL,
+	seq INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	phase TEXT NOT NULL DEFAULT '',
+	message TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	UNIQUE (deployment_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS auth_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_type TEXT NOT NULL,
+	user_id INTEGER,
+	email TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	success INTEGER NOT NULL DEFAULT 0,
+	details TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS auth_events_email_idx ON auth_events (email, created_at);
+CREATE INDEX IF NOT EXISTS auth_events_ip_idx ON auth_events (ip, created_at);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor_id INTEGER,
+	actor_email TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL DEFAULT '',
+	target TEXT NOT NULL DEFAULT '',
+	before TEXT NOT NULL DEFAULT '',
+	after TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_logs_target_idx ON audit_logs (target_type, target, created_at);
+CREATE INDEX IF NOT EXISTS audit_logs_action_idx ON audit_logs (action, created_at);
+
+CREATE TABLE IF NOT EXISTS metrics (
+	project_slug TEXT NOT NULL,
+	resolution TEXT NOT NULL,
+	bucket INTEGER NOT NULL,
+	samples INTEGER NOT NULL,
+	cpu_avg REAL NOT NULL,
+	cpu_max REAL NOT NULL,
+	mem_avg INTEGER NOT NULL,
+	mem_max INTEGER NOT NULL,
+	mem_limit INTEGER NOT NULL,
+	net_rx INTEGER NOT NULL,
+	net_tx INTEGER NOT NULL,
+	block_read INTEGER NOT NULL,
+	block_write INTEGER NOT NULL,
+	PRIMARY KEY (project_slug, resolution, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS log_drains (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_slug TEXT NOT NULL,
+	name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	endpoint TEXT NOT NULL,
+	protocol TEXT NOT NULL DEFAULT '',
+	token TEXT NOT NULL DEFAULT '',
+	sources TEXT NOT NULL DEFAULT 'build,runtime',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_log_drains_project ON log_drains(project_slug);
+
+CREATE TABLE IF NOT EXISTS project_resources (
+	project_slug TEXT PRIMARY KEY,
+	memory_mb INTEGER NOT NULL DEFAULT 0,
+	cpus REAL NOT NULL DEFAULT 0,
+	pids_limit INTEGER NOT NULL DEFAULT 0,
+	restart_policy TEXT NOT NULL DEFAULT 'unless-stopped',
+	max_retries INTEGER NOT NULL DEFAULT 0,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS log_retention_policies (
+	project_slug TEXT PRIMARY KEY,
+	max_age_days INTEGER NOT NULL,
+	max_size_mb INTEGER NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS project_volumes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_slug TEXT NOT NULL,
+	name TEXT NOT NULL,
+	mount_path TEXT NOT NULL,
+	docker_name TEXT NOT NULL UNIQUE,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (project_slug, name),
+	UNIQUE (project_slug, mount_path)
+);
+
+CREATE TABLE IF NOT EXISTS image_retention_policies (
+	project_slug TEXT PRIMARY KEY,
+	keep_last INTEGER NOT NULL,
+	keep_days INTEGER NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS pinned_images (
+	project_slug TEXT NOT NULL,
+	image_tag TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (project_slug, image_tag)
+);
+
+CREATE TABLE IF NOT EXISTS project_incidents (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_slug TEXT NOT NULL,
+	container_name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	exit_code INTEGER,
+	message TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_incidents_project ON project_incidents(project_slug, id);
+
+CREATE TABLE IF NOT EXISTS backup_targets (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_slug TEXT NOT NULL,
+	name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	path TEXT NOT NULL DEFAULT '',
+	endpoint TEXT NOT NULL DEFAULT '',
+	bucket TEXT NOT NULL DEFAULT '',
+	region TEXT NOT NULL DEFAULT '',
+	prefix TEXT NOT NULL DEFAULT '',
+	access_key TEXT NOT NULL DEFAULT '',
+	secret_key TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS backup_policies (
+	project_slug TEXT PRIMARY KEY,
+	enabled INTEGER NOT NULL DEFAULT 0,
+	schedule TEXT NOT NULL DEFAULT '',
+	target_id INTEGER,
+	keep_last INTEGER NOT NULL,
+	keep_days INTEGER NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS snapshots (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_slug TEXT NOT NULL,
+	target_id INTEGER NOT NULL,
+	trigger TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'running',
+	size_bytes INTEGER NOT NULL DEFAULT 0,
+	message TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	finished_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_snapshots_project ON snapshots(project_slug, id);
+
+CREATE TABLE IF NOT EXISTS snapshot_files (
+	snapshot_id INTEGER NOT NULL,
+	volume_name TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	size_bytes INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (snapshot_id, volume_name)
+);
+
+CREATE TABLE IF NOT EXISTS addons (
+	project_slug TEXT PRIMARY KEY,
+	engine TEXT NOT NULL,
+	version TEXT NOT NULL,
+	username TEXT NOT NULL DEFAULT '',
+	password TEXT NOT NULL,
+	database_name TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS project_links (
+	project_slug TEXT NOT NULL,
+	target_slug TEXT NOT NULL,
+	env_var TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (project_slug, target_slug)
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_links_target ON project_links(target_slug);
+
+CREATE TRIGGER IF NOT EXISTS audit_logs_no_update BEFORE UPDATE ON audit_logs
+BEGIN
+	SELECT RAISE(ABORT, 'audit_logs is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_logs_no_delete BEFORE DELETE ON audit_logs
+BEGIN
+	SELECT RAISE(ABORT, 'audit_logs is append-only');
+END;
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"infracon/utils"
+)
+
+type resourceRepo struct{ s *sqlStore }
+
+// Get falls back to no limits and the unless-stopped restart policy.
+func (r resourceRepo) Get(slug string) (*utils.ProjectResources, error) {
+	res := utils.ProjectResources{ProjectSlug: slug, RestartPolicy: "unless-stopped"}
+	err := r.s.q().QueryRow(
+		"SELECT memory_mb, cpus, pids_limit, restart_policy, max_retries FROM project_resources WHERE project_slug = $1",
+		slug,
+	).Scan(&res.MemoryMB, &res.CPUs, &res.PidsLimit, &res.RestartPolicy, &res.MaxRetries)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return &res, err
+}
+
+func (r resourceRepo) Set(res utils.ProjectResources) error {
+	_, err := r.s.q().Exec(
+		`INSERT INTO project_resources (project_slug, memory_mb, cpus, pids_limit, restart_policy, max_retries) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (project_slug) DO UPDATE SET memory_mb = excluded.memory_mb, cpus = excluded.cpus, pids_limit = excluded.pids_limit,
+			restart_policy = excluded.restart_policy, max_retries = excluded.max_retries, updated_at = CURRENT_TIMESTAMP`,
+		res.ProjectSlug,
+		res.MemoryMB,
+		res.CPUs,
+		res.PidsLimit,
+		res.RestartPolicy,
+		res.MaxRetries,
+	)
+	return err
+}
@@ -0,0 +1,287 @@
+package db
+
+import (
+	"database/sql"
+	"infracon/utils"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const storeKey = "store"
+
+// Store groups the typed repositories over one database. main opens it once; handlers
+// get it from StoreFrom and background jobs are handed it when they start.
+type Store interface {
+	Projects() ProjectRepository
+	Deployments() DeploymentRepository
+	Images() ImageRepository
+	Logs() LogRepository
+	Users() UserRepository
+	Tokens() TokenRepository
+	AuthEvents() AuthEventRepository
+	Audit() AuditRepository
+	Resources() ResourceRepository
+	Volumes() VolumeRepository
+	Addons() AddonRepository
+	Links() LinkRepository
+	Drains() DrainRepository
+	Metrics() MetricRepository
+	Incidents() IncidentRepository
+	Backups() BackupRepository
+	// InTx runs fn against a store bound to a single transaction, committed when fn
+	// returns nil and rolled back otherwise. Nested calls join the outer transaction.
+	InTx(fn func(tx Store) error) error
+}
+
+type ProjectRepository interface {
+	Create(p utils.Project) (int, error)
+	Update(p utils.Project) error
+	Get(slug string) (*utils.Project, error)
+	GetByContainer(containerName string) (*utils.Project, error)
+	List() ([]utils.Project, error)
+	Delete(slug string, purgeImages, purgeVolumes bool) error
+}
+
+type DeploymentRepository interface {
+	Create(d utils.Deployment) (int, error)
+	SetCommit(id int, commitSha string) error
+	Finish(id int, status, message string) error
+	FailInterrupted(message string) (int64, error)
+	Supersede(slug string, keepId int, status string) error
+	Get(slug string, id int) (*utils.Deployment, error)
+	List(slug string, limit, offset int) ([]utils.Deployment, error)
+}
+
+type ImageRepository interface {
+	Add(img utils.ProjectImage) error
+	Has(slug, imageTag string) (bool, error)
+	Get(slug, imageTag string) (*utils.ProjectImage, error)
+	List(slug string) ([]utils.ProjectImage, error)
+	Delete(slug, imageTag string) error
+	Pin(slug, imageTag string) error
+	Unpin(slug, imageTag string) error
+	Pinned(slug string) (map[string]bool, error)
+	GetRetentionPolicy(slug string) (*utils.ImageRetentionPolicy, error)
+	SetRetentionPolicy(p utils.ImageRetentionPolicy) error
+}
+
+type LogRepository interface {
+	AppendDeploymentLogs(lines []utils.DeploymentLog) error
+	LastDeploymentLogSeq(deploymentId int) (int, error)
+	GetDeploymentLogs(deploymentId, afterSeq, limit int) ([]utils.DeploymentLog, error)
+	GetRetentionPolicy(slug string) (*utils.LogRetentionPolicy, error)
+	SetRetentionPolicy(p utils.LogRetentionPolicy) error
+}
+
+type UserRepository interface {
+	Any() (bool, error)
+	Create(email, passwordHash string) (int, error)
+	GetIdByEmail(email string) (int, error)
+	GetCredentials(email string) (id int, passwordHash string, err error)
+	GetEmail(id int) (string, error)
+	// FirstID returns the user who signed up as the admin.
+	FirstID() (int, error)
+	SetPassword(id int, passwordHash string) error
+}
+
+type TokenRepository interface {
+	// GetGithubToken returns sql.ErrNoRows when no token is configured.
+	GetGithubToken() (string, error)
+	// SetGithubToken stores the token and reports whether it replaced another.
+	SetGithubToken(token string) (bool, error)
+}
+
+type AuthEventRepository interface {
+	Record(e utils.AuthEvent) error
+	List(f utils.AuthEventFilter) ([]utils.AuthEvent, error)
+}
+
+type AuditRepository interface {
+	Add(l utils.AuditLog) error
+	List(f utils.AuditLogFilter) ([]utils.AuditLog, error)
+}
+
+type ResourceRepository interface {
+	// Get falls back to no limits and the unless-stopped restart policy.
+	Get(slug string) (*utils.ProjectResources, error)
+	Set(r utils.ProjectResources) error
+}
+
+type VolumeRepository interface {
+	Create(v utils.ProjectVolume) (int, error)
+	Get(slug string, id int) (*utils.ProjectVolume, error)
+	List(slug string) ([]utils.ProjectVolume, error)
+	Delete(slug string, id int) error
+}
+
+type AddonRepository interface {
+	Create(a utils.Addon) error
+	// Get returns sql.ErrNoRows when the project is not an add-on.
+	Get(slug string) (*utils.Addon, error)
+	List() ([]utils.Addon, error)
+}
+
+type LinkRepository interface {
+	Create(l utils.ProjectLink) error
+	// List returns the services the project depends on.
+	List(slug string) ([]utils.ProjectLink, error)
+	// Dependents returns the links of every project that depends on slug.
+	Dependents(slug string) ([]utils.ProjectLink, error)
+	Delete(slug, target string) error
+}
+
+type DrainRepository interface {
+	Create(d utils.LogDrain) (int, error)
+	Get(slug string, id int) (*utils.LogDrain, error)
+	// List returns the drains of a project, or of every project when slug is empty.
+	List(slug string) ([]utils.LogDrain, error)
+	Delete(slug string, id int) error
+}
+
+type MetricRepository interface {
+	Upsert(m utils.MetricPoint) error
+	Rollup(from, to string, size time.Duration, since time.Time) error
+	Prune(resolution string, before time.Time) error
+	List(slug, resolution string, from, to time.Time) ([]utils.MetricPoint, error)
+}
+
+type IncidentRepository interface {
+	Create(i utils.Incident) (int, error)
+	// List returns a project's incidents, newest first.
+	List(slug string, limit, offset int) ([]utils.Incident, error)
+}
+
+type BackupRepository interface {
+	CreateTarget(t utils.BackupTarget) (int, error)
+	GetTarget(slug string, id int) (*utils.BackupTarget, error)
+	ListTargets(slug string) ([]utils.BackupTarget, error)
+	DeleteTarget(slug string, id int) error
+	// GetPolicy returns sql.ErrNoRows when the project has no policy yet.
+	GetPolicy(slug string) (*utils.BackupPolicy, error)
+	// EnabledPolicies returns the policies the scheduler has to run.
+	EnabledPolicies() ([]utils.BackupPolicy, error)
+	SetPolicy(p utils.BackupPolicy) error
+	CreateSnapshot(s utils.Snapshot) (int, error)
+	AddSnapshotFile(f utils.SnapshotFile) error
+	FinishSnapshot(id int, status, message string) error
+	// FailInterruptedSnapshots marks snapshots that were still running as failed,
+	// e.g. after a crash.
+	FailInterruptedSnapshots(message string) (int64, error)
+	GetSnapshot(slug string, id int) (*utils.Snapshot, error)
+	// ListSnapshots returns the project's snapshots, newest first, with their files.
+	ListSnapshots(slug string) ([]utils.Snapshot, error)
+	DeleteSnapshot(slug string, id int) error
+}
+
+// querier is what *sql.DB and *sql.Tx have in common.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+type sqlStore struct {
+	conn *sql.DB
+	tx   *sql.Tx
+}
+
+func NewStore(conn *sql.DB) Store {
+	return &sqlStore{conn: conn}
+}
+
+func (s *sqlStore) q() querier {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.conn
+}
+
+func (s *sqlStore) InTx(fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&sqlStore{conn: s.conn, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// atomic runs fn in the store's transaction, or in a new one.
+func (s *sqlStore) atomic(fn func(q querier) error) error {
+	return s.InTx(func(tx Store) error {
+		return fn(tx.(*sqlStore).q())
+	})
+}
+
+func (s *sqlStore) Projects() ProjectRepository       { return projectRepo{s} }
+func (s *sqlStore) Deployments() DeploymentRepository { return deploymentRepo{s} }
+func (s *sqlStore) Images() ImageRepository           { return imageRepo{s} }
+func (s *sqlStore) Logs() LogRepository               { return logRepo{s} }
+func (s *sqlStore) Users() UserRepository             { return userRepo{s} }
+func (s *sqlStore) Tokens() TokenRepository           { return tokenRepo{s} }
+func (s *sqlStore) AuthEvents() AuthEventRepository   { return authEventRepo{s} }
+func (s *sqlStore) Audit() AuditRepository            { return auditRepo{s} }
+func (s *sqlStore) Resources() ResourceRepository     { return resourceRepo{s} }
+func (s *sqlStore) Volumes() VolumeRepository         { return volumeRepo{s} }
+func (s *sqlStore) Addons() AddonRepository           { return addonRepo{s} }
+func (s *sqlStore) Links() LinkRepository             { return linkRepo{s} }
+func (s *sqlStore) Drains() DrainRepository           { return drainRepo{s} }
+func (s *sqlStore) Metrics() MetricRepository         { return metricRepo{s} }
+func (s *sqlStore) Incidents() IncidentRepository     { return incidentRepo{s} }
+func (s *sqlStore) Backups() BackupRepository         { return backupRepo{s} }
+
+// Open opens the SQLite database at path in WAL mode, waiting up to five seconds on
+// locks held by other connections. ":memory:" gives a private database, e.g. for tests.
+func Open(path string) (*sql.DB, error) {
+	if path == ":memory:" {
+		conn, err := sql.Open("sqlite3", "file::memory:?_busy_timeout=5000")
+		if err != nil {
+			return nil, err
+		}
+		// Every connection to :memory: is a separate database.
+		conn.SetMaxOpenConns(1)
+		return conn, nil
+	}
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	conn, err := sql.Open("sqlite3", "file:"+path+separator+"_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	return conn, conn.Ping()
+}
+
+// Inject makes s available to the handlers behind it through StoreFrom.
+func Inject(s Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(storeKey, s)
+		c.Next()
+	}
+}
+
+// StoreFrom returns the store Inject made available to the request.
+func StoreFrom(c *gin.Context) Store {
+	s, ok := c.Get(storeKey)
+	if !ok {
+		panic("db: no store in the request context; is the router missing db.Inject?")
+	}
+	return s.(Store)
+}
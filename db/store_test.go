@@ -0,0 +1,180 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"infracon/utils"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func openTestStore(t *testing.T) Store {
+	t.Helper()
+	return NewStore(openTestDatabase(t))
+}
+
+func TestStoreRepositoriesRoundTrip(t *testing.T) {
+	store := openTestStore(t)
+
+	for _, slug := range []string{"web", "pg"} {
+		if _, err := store.Projects().Create(utils.Project{Name: slug, Slug: slug}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := store.Projects().Get("web")
+	if err != nil || p.Name != "web" {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+
+	if _, err := store.Volumes().Create(utils.ProjectVolume{ProjectSlug: "pg", Name: "data", MountPath: "/var/lib/postgresql/data", DockerName: "infracon-pg-data"}); err != nil {
+		t.Fatal(err)
+	}
+	volumes, err := store.Volumes().List("pg")
+	if err != nil || len(volumes) != 1 || volumes[0].DockerName != "infracon-pg-data" {
+		t.Errorf("volumes: got %+v, %v", volumes, err)
+	}
+
+	if err := store.Links().Create(utils.ProjectLink{ProjectSlug: "web", TargetSlug: "pg", EnvVar: "DATABASE_URL"}); err != nil {
+		t.Fatal(err)
+	}
+	dependents, err := store.Links().Dependents("pg")
+	if err != nil || len(dependents) != 1 || dependents[0].ProjectSlug != "web" {
+		t.Errorf("dependents: got %+v, %v", dependents, err)
+	}
+
+	targetId, err := store.Backups().CreateTarget(utils.BackupTarget{ProjectSlug: "pg", Name: "disk", Kind: "local", Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotId, err := store.Backups().CreateSnapshot(utils.Snapshot{ProjectSlug: "pg", TargetID: targetId, Trigger: "manual", Status: "running"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Backups().AddSnapshotFile(utils.SnapshotFile{SnapshotID: snapshotId, VolumeName: "data", Key: "pg/1/data.tar.gz", SizeBytes: 42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Backups().FinishSnapshot(snapshotId, "completed", ""); err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.Backups().GetSnapshot("pg", snapshotId)
+	if err != nil || s.Status != "completed" || len(s.Files) != 1 || s.Files[0].SizeBytes != 42 {
+		t.Fatalf("snapshot: got %+v, %v", s, err)
+	}
+	if err := store.Backups().DeleteSnapshot("pg", snapshotId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Backups().GetSnapshot("pg", snapshotId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted snapshot: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestInTxRollsBackOnError(t *testing.T) {
+	store := openTestStore(t)
+
+	failed := errors.New("boom")
+	err := store.InTx(func(tx Store) error {
+		if _, err := tx.Projects().Create(utils.Project{Name: "web", Slug: "web"}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+	if _, err := store.Projects().Get("web"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("project survived the rollback: %v", err)
+	}
+}
+
+func TestStoreFromReturnsInjectedStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := openTestStore(t)
+
+	var got Store
+	router := gin.New()
+	router.Use(Inject(store))
+	router.GET("/", func(c *gin.Context) { got = StoreFrom(c) })
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got != store {
+		t.Errorf("got %v, want the injected store", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("StoreFrom without Inject did not panic")
+		}
+	}()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	StoreFrom(c)
+}
+
+func TestSetPasswordChangesOnlyThatUser(t *testing.T) {
+	store := openTestStore(t)
+	users := store.Users()
+
+	if _, err := users.FirstID(); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("FirstID on an empty table: got %v, want sql.ErrNoRows", err)
+	}
+	admin, err := users.Create("admin@example.com", "admin-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := users.Create("dev@example.com", "dev-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := users.FirstID(); err != nil || id != admin {
+		t.Fatalf("FirstID: got %d, %v, want %d", id, err, admin)
+	}
+
+	if err := users.SetPassword(other, "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	for email, want := range map[string]string{"admin@example.com": "admin-hash", "dev@example.com": "new-hash"} {
+		if _, hash, err := users.GetCredentials(email); err != nil || hash != want {
+			t.Errorf("%s: got %q, %v, want %q", email, hash, err, want)
+		}
+	}
+	if err := users.SetPassword(other+1, "x"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown user: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestProjectDeleteKeepsDataThatOutlivesIt(t *testing.T) {
+	for _, purgeVolumes := range []bool{false, true} {
+		store := openTestStore(t)
+		if _, err := store.Projects().Create(utils.Project{Name: "pg", Slug: "pg"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Volumes().Create(utils.ProjectVolume{ProjectSlug: "pg", Name: "data", MountPath: "/data", DockerName: "infracon-pg-data"}); err != nil {
+			t.Fatal(err)
+		}
+		targetId, err := store.Backups().CreateTarget(utils.BackupTarget{ProjectSlug: "pg", Name: "disk", Kind: "local", Path: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshotId, err := store.Backups().CreateSnapshot(utils.Snapshot{ProjectSlug: "pg", TargetID: targetId, Trigger: "manual", Status: "completed"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Projects().Delete("pg", false, purgeVolumes); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Projects().Get("pg"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("purge_volumes=%t: project survived: %v", purgeVolumes, err)
+		}
+		volumes, err := store.Volumes().List("pg")
+		if err != nil || (len(volumes) == 0) != purgeVolumes {
+			t.Errorf("purge_volumes=%t: volumes: got %+v, %v", purgeVolumes, volumes, err)
+		}
+		if _, err := store.Backups().GetSnapshot("pg", snapshotId); err != nil {
+			t.Errorf("purge_volumes=%t: snapshot: %v", purgeVolumes, err)
+		}
+		if _, err := store.Backups().GetTarget("pg", targetId); err != nil {
+			t.Errorf("purge_volumes=%t: backup target: %v", purgeVolumes, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+type tokenRepo struct{ s *sqlStore }
+
+func (r tokenRepo) GetGithubToken() (string, error) {
+	var token string
+	err := r.s.q().QueryRow("SELECT token FROM github_tokens").Scan(&token)
+	return token, err
+}
+
+func (r tokenRepo) SetGithubToken(token string) (bool, error) {
+	var replaced bool
+	err := r.s.atomic(func(q querier) error {
+		var userId int
+		err := q.QueryRow("SELECT user_id FROM github_tokens").Scan(&userId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if userId > 0 {
+			replaced = true
+			_, err = q.Exec("UPDATE github_tokens SET token = $1, updated_at = CURRENT_TIMESTAMP WHERE user_id = $2", token, userId)
+		} else {
+			_, err = q.Exec("INSERT INTO github_tokens (user_id, token) VALUES (1, $1)", token)
+		}
+		return err
+	})
+	return replaced, err
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"infracon/utils"
+)
+
+type volumeRepo struct{ s *sqlStore }
+
+const volumeColumns = "id, project_slug, name, mount_path, docker_name, created_at"
+
+func scanVolume(row rowScanner) (*utils.ProjectVolume, error) {
+	var v utils.ProjectVolume
+	err := row.Scan(&v.ID, &v.ProjectSlug, &v.Name, &v.MountPath, &v.DockerName, &v.CreatedAt)
+	return &v, err
+}
+
+func (r volumeRepo) Create(v utils.ProjectVolume) (int, error) {
+	var id int
+	err := r.s.q().QueryRow(
+		`INSERT INTO project_volumes (project_slug, name, mount_path, docker_name) VALUES ($1, $2, $3, $4) RETURNING id`,
+		v.ProjectSlug,
+		v.Name,
+		v.MountPath,
+		v.DockerName,
+	).Scan(&id)
+	return id, err
+}
+
+func (r volumeRepo) Get(slug string, id int) (*utils.ProjectVolume, error) {
+	return scanVolume(r.s.q().QueryRow("SELECT "+volumeColumns+" FROM project_volumes WHERE project_slug = $1 AND id = $2", slug, id))
+}
+
+func (r volumeRepo) List(slug string) ([]utils.ProjectVolume, error) {
+	rows, err := r.s.q().Query("SELECT "+volumeColumns+" FROM project_volumes WHERE project_slug = $1 ORDER BY id", slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	volumes := []utils.ProjectVolume{}
+	for rows.Next() {
+		v, err := scanVolume(rows)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, *v)
+	}
+
+	return volumes, rows.Err()
+}
+
+func (r volumeRepo) Delete(slug string, id int) error {
+	_, err := r.s.q().Exec("DELETE FROM project_volumes WHERE project_slug = $1 AND id = $2", slug, id)
+	return err
+}
@@ -0,0 +1,65 @@
+package disk
+
+// ProjectUsage breaks down what a project occupies on disk. Image sizes follow
+// `docker system df`: shared layers are counted for every image using them, so only
+// ImageUniqueBytes adds up across projects. TotalBytes counts unique image bytes.
+type ProjectUsage struct {
+	ProjectSlug      string        `json:"project_slug"`
+	SourceBytes      int64         `json:"source_bytes"`
+	ImageBytes       int64         `json:"image_bytes"`
+	ImageSharedBytes int64         `json:"image_shared_bytes"`
+	ImageUniqueBytes int64         `json:"image_unique_bytes"`
+	VolumeBytes      int64         `json:"volume_bytes"`
+	LogBytes         int64         `json:"log_bytes"`
+	TotalBytes       int64         `json:"total_bytes"`
+	SourceDirs       []DirUsage    `json:"source_dirs"`
+	Images           []ImageUsage  `json:"images"`
+	Volumes          []VolumeUsage `json:"volumes"`
+}
+
+type DirUsage struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+type ImageUsage struct {
+	Tag         string `json:"tag"`
+	ID          string `json:"id"`
+	Bytes       int64  `json:"bytes"`
+	SharedBytes int64  `json:"shared_bytes"`
+	UniqueBytes int64  `json:"unique_bytes"`
+	Containers  int    `json:"containers"`
+}
+
+type VolumeUsage struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+type HostDisk struct {
+	Name        string  `json:"name"`
+	Path        string  `json:"path"`
+	TotalBytes  int64   `json:"total_bytes"`
+	FreeBytes   int64   `json:"free_bytes"`
+	FreePercent float64 `json:"free_percent"`
+	Low         bool    `json:"low"`
+}
+
+// systemDF is the output of `docker system df -v --format {{json .}}`. Sizes are
+// human readable strings.
+type systemDF struct {
+	Images []struct {
+		ID         string `json:"ID"`
+		Repository string `json:"Repository"`
+		Tag        string `json:"Tag"`
+		Size       string `json:"Size"`
+		SharedSize string `json:"SharedSize"`
+		UniqueSize string `json:"UniqueSize"`
+		Containers string `json:"Containers"`
+	} `json:"Images"`
+	Volumes []struct {
+		Name   string `json:"Name"`
+		Labels string `json:"Labels"`
+		Size   string `json:"Size"`
+	} `json:"Volumes"`
+}
@@ -0,0 +1,189 @@
+package disk
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/db"
+	"infracon/events"
+	"infracon/telemetry"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	hostMu   sync.RWMutex
+	lastHost []HostDisk
+	wasLow   = map[string]bool{}
+)
+
+func init() {
+	telemetry.NewGaugeFunc(
+		"infracon_disk_free_bytes",
+		"Free space on the filesystems infracon writes to, at the last check.",
+		[]string{"disk"},
+		func(emit func(float64, ...string)) {
+			hostMu.RLock()
+			defer hostMu.RUnlock()
+			for _, d := range lastHost {
+				emit(float64(d.FreeBytes), d.Name)
+			}
+		},
+	)
+}
+
+// RunMonitor checks free space every DISK_CHECK_INTERVAL and warns on the events feed
+// and through notifications when a disk runs low or recovers.
+func RunMonitor() {
+	interval := utils.GetEnvDuration("DISK_CHECK_INTERVAL", 5*time.Minute)
+
+	for {
+		check()
+		time.Sleep(interval)
+	}
+}
+
+func check() []HostDisk {
+	disks := HostDisks()
+
+	hostMu.Lock()
+	lastHost = disks
+	var changed []HostDisk
+	for _, d := range disks {
+		if d.Low != wasLow[d.Name] {
+			wasLow[d.Name] = d.Low
+			changed = append(changed, d)
+		}
+	}
+	hostMu.Unlock()
+
+	for _, d := range changed {
+		if !d.Low {
+			events.Publish(utils.SSEEvent{Type: "disk", Level: "info", Message: fmt.Sprintf("Disk %s (%s) recovered: %s free", d.Name, d.Path, formatBytes(d.FreeBytes))})
+			continue
+		}
+
+		message := lowMessage(d)
+		log.Print(message)
+		events.Publish(utils.SSEEvent{Type: "disk", Level: "warn", Message: message})
+		details := map[string]any{
+			"disk":         d.Name,
+			"path":         d.Path,
+			"free_bytes":   d.FreeBytes,
+			"total_bytes":  d.TotalBytes,
+			"free_percent": d.FreePercent,
+		}
+		if err := utils.SendNotification("disk.low", message, details); err != nil {
+			log.Printf("notification error: %s", err)
+		}
+	}
+
+	return disks
+}
+
+// BuildWarning checks free space right before a build and describes every disk that
+// is running low, or returns "" when there is room.
+func BuildWarning() string {
+	var warnings []string
+	for _, d := range check() {
+		if d.Low && d.Name != "logs" {
+			warnings = append(warnings, lowMessage(d))
+		}
+	}
+	return strings.Join(warnings, "; ")
+}
+
+func lowMessage(d HostDisk) string {
+	return fmt.Sprintf("Disk %s (%s) is low on space: %s free of %s (%.1f%%)", d.Name, d.Path, formatBytes(d.FreeBytes), formatBytes(d.TotalBytes), d.FreePercent)
+}
+
+func formatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", v, units[i])
+}
+
+func GetDiskUsage(c *gin.Context) {
+	usage, err := ProjectsUsage(db.StoreFrom(c))
+	if err != nil {
+		log.Printf("disk usage error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	projects := []*ProjectUsage{}
+	for _, u := range usage {
+		projects = append(projects, u)
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].TotalBytes > projects[j].TotalBytes
+	})
+
+	disks := check()
+	warnings := []string{}
+	for _, d := range disks {
+		if d.Low {
+			warnings = append(warnings, lowMessage(d))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"host":     disks,
+			"projects": projects,
+			"warnings": warnings,
+		},
+	})
+}
+
+func GetProjectDiskUsage(c *gin.Context) {
+	project, err := db.StoreFrom(c).Projects().Get(c.Param("slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Project not found!",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("project lookup query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	usage, err := ProjectsUsage(db.StoreFrom(c))
+	if err != nil {
+		log.Printf("disk usage error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"usage": usage[project.Slug],
+		},
+	})
+}
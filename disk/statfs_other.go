@@ -0,0 +1,9 @@
+//go:build !unix
+
+package disk
+
+import "errors"
+
+func statfs(path string) (total, free int64, err error) {
+	return 0, 0, errors.New("disk usage is not supported on this platform")
+}
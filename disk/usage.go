@@ -0,0 +1,250 @@
+package disk
+
+import (
+	"encoding/json"
+	"fmt"
+	"infracon/db"
+	"infracon/logstore"
+	"infracon/utils"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	dockerRootMu sync.Mutex
+	dockerRoot   string
+)
+
+// ProjectsUsage measures the disk consumption of every project. Image and volume sizes
+// are left out when the docker daemon can't be queried.
+func ProjectsUsage(store db.Store) (map[string]*ProjectUsage, error) {
+	projects, err := store.Projects().List()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[string]*ProjectUsage{}
+	tagOwner := map[string]string{}
+	for _, p := range projects {
+		u := &ProjectUsage{
+			ProjectSlug: p.Slug,
+			SourceDirs:  []DirUsage{},
+			Images:      []ImageUsage{},
+			Volumes:     []VolumeUsage{},
+		}
+		usage[p.Slug] = u
+
+		for _, dir := range utils.ProjectSourceDirs(p.Slug) {
+			size := utils.DirSize(dir)
+			u.SourceDirs = append(u.SourceDirs, DirUsage{Path: dir, Bytes: size})
+			u.SourceBytes += size
+		}
+		u.LogBytes = logstore.Usage(p.Slug)
+
+		if p.CurrentImage != nil && *p.CurrentImage != "" {
+			tagOwner[imageRef(*p.CurrentImage)] = p.Slug
+		}
+		images, err := store.Images().List(p.Slug)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range images {
+			tagOwner[imageRef(img.ImageTag)] = p.Slug
+		}
+	}
+
+	if err := addDockerUsage(usage, tagOwner); err != nil {
+		log.Printf("docker disk usage error: %s", err)
+	}
+
+	for _, u := range usage {
+		u.TotalBytes = u.SourceBytes + u.ImageUniqueBytes + u.VolumeBytes + u.LogBytes
+	}
+
+	return usage, nil
+}
+
+// VolumeSizes returns the size of every docker volume by name.
+func VolumeSizes() (map[string]int64, error) {
+	df, err := dockerDF()
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := map[string]int64{}
+	for _, v := range df.Volumes {
+		sizes[v.Name], _ = utils.ParseDockerSize(v.Size)
+	}
+	return sizes, nil
+}
+
+// ImagesBytes is what docker's images take on disk, with shared layers counted once.
+func ImagesBytes() (int64, error) {
+	output, err := exec.Command("docker", "system", "df", "--format", "{{.Type}}\t{{.Size}}").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query docker disk usage: %w", err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		if kind, size, ok := strings.Cut(line, "\t"); ok && kind == "Images" {
+			return utils.ParseDockerSize(size)
+		}
+	}
+	return 0, fmt.Errorf("docker system df reported no images")
+}
+
+func dockerDF() (*systemDF, error) {
+	output, err := exec.Command("docker", "system", "df", "-v", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query docker disk usage: %w", err)
+	}
+
+	var df systemDF
+	if err := json.Unmarshal(output, &df); err != nil {
+		return nil, fmt.Errorf("failed to parse docker system df output: %w", err)
+	}
+	return &df, nil
+}
+
+func addDockerUsage(usage map[string]*ProjectUsage, tagOwner map[string]string) error {
+	df, err := dockerDF()
+	if err != nil {
+		return err
+	}
+
+	idOwner := map[string]string{}
+	managed, err := utils.ListManagedImages()
+	if err != nil {
+		return err
+	}
+	for _, img := range managed {
+		if labels, ok := utils.ParseResourceLabels(img.Config.Labels); ok {
+			idOwner[strings.TrimPrefix(img.ID, "sha256:")] = labels.ProjectSlug
+		}
+	}
+
+	for _, img := range df.Images {
+		tag := img.Repository + ":" + img.Tag
+		owner := tagOwner[imageRef(tag)]
+		if owner == "" {
+			for id, slug := range idOwner {
+				if img.ID != "" && strings.HasPrefix(id, strings.TrimPrefix(img.ID, "sha256:")) {
+					owner = slug
+					break
+				}
+			}
+		}
+		u, ok := usage[owner]
+		if !ok {
+			continue
+		}
+
+		size, _ := utils.ParseDockerSize(img.Size)
+		shared, _ := utils.ParseDockerSize(img.SharedSize)
+		unique, _ := utils.ParseDockerSize(img.UniqueSize)
+		containers, _ := strconv.Atoi(img.Containers)
+
+		if img.Repository == "<none>" {
+			tag = img.ID
+		}
+		u.Images = append(u.Images, ImageUsage{
+			Tag:         imageRef(tag),
+			ID:          img.ID,
+			Bytes:       size,
+			SharedBytes: shared,
+			UniqueBytes: unique,
+			Containers:  containers,
+		})
+		u.ImageBytes += size
+		u.ImageSharedBytes += shared
+		u.ImageUniqueBytes += unique
+	}
+
+	for _, v := range df.Volumes {
+		labels, ok := utils.ParseResourceLabels(utils.ParseLabelList(v.Labels))
+		if !ok {
+			continue
+		}
+		u, ok := usage[labels.ProjectSlug]
+		if !ok {
+			continue
+		}
+		size, _ := utils.ParseDockerSize(v.Size)
+		u.Volumes = append(u.Volumes, VolumeUsage{Name: v.Name, Bytes: size})
+		u.VolumeBytes += size
+	}
+
+	return nil
+}
+
+// HostDisks reports the filesystems holding release sources, runtime logs and docker's
+// data. A disk is low when its free space drops below DISK_FREE_WARN_PERCENT percent
+// or DISK_FREE_WARN_MB megabytes.
+func HostDisks() []HostDisk {
+	warnPercent := 10.0
+	if v, err := strconv.ParseFloat(utils.GetEnv("DISK_FREE_WARN_PERCENT", ""), 64); err == nil && v >= 0 {
+		warnPercent = v
+	}
+	warnBytes := int64(2048) << 20
+	if v, err := strconv.ParseInt(utils.GetEnv("DISK_FREE_WARN_MB", ""), 10, 64); err == nil && v >= 0 {
+		warnBytes = v << 20
+	}
+
+	paths := []struct{ name, path string }{
+		{"apps", "infracon-apps"},
+		{"logs", logstore.Root()},
+		{"docker", dockerRootDir()},
+	}
+
+	disks := []HostDisk{}
+	for _, p := range paths {
+		if p.path == "" {
+			continue
+		}
+
+		// Fall back to the closest existing parent, e.g. before the first deploy.
+		path, _ := filepath.Abs(p.path)
+		total, free, err := statfs(path)
+		for err != nil && filepath.Dir(path) != path {
+			path = filepath.Dir(path)
+			total, free, err = statfs(path)
+		}
+		if err != nil || total == 0 {
+			continue
+		}
+
+		d := HostDisk{
+			Name:        p.name,
+			Path:        path,
+			TotalBytes:  total,
+			FreeBytes:   free,
+			FreePercent: float64(free) / float64(total) * 100,
+		}
+		d.Low = d.FreePercent < warnPercent || d.FreeBytes < warnBytes
+		disks = append(disks, d)
+	}
+
+	return disks
+}
+
+func dockerRootDir() string {
+	dockerRootMu.Lock()
+	defer dockerRootMu.Unlock()
+
+	if dockerRoot == "" {
+		output, err := exec.Command("docker", "info", "--format", "{{.DockerRootDir}}").Output()
+		if err == nil {
+			dockerRoot = strings.TrimSpace(string(output))
+		}
+	}
+	return dockerRoot
+}
+
+// imageRef normalises an image name so "app" and "app:latest" compare equal.
+func imageRef(name string) string {
+	return strings.TrimSuffix(name, ":latest")
+}
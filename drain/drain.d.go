@@ -0,0 +1,74 @@
+package drain
+
+import (
+	"infracon/utils"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type CreateLogDrainPayload struct {
+	Name     string   `json:"name" binding:"required"`
+	Kind     string   `json:"kind" binding:"required"`
+	Endpoint string   `json:"endpoint" binding:"required"`
+	Protocol string   `json:"protocol"`
+	Token    string   `json:"token"`
+	Sources  []string `json:"sources"`
+}
+
+type DrainStatus struct {
+	utils.LogDrain
+	Queued          int        `json:"queued"`
+	Dropped         int64      `json:"dropped"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at"`
+}
+
+type sender interface {
+	Send(records []utils.LogRecord) error
+	Close() error
+}
+
+// partialSendError reports a failed batch whose first sent records were delivered.
+type partialSendError struct {
+	sent int
+	err  error
+}
+
+type worker struct {
+	drain   utils.LogDrain
+	sender  sender
+	sources []string
+	queue   chan utils.LogRecord
+	done    chan struct{}
+	dropped atomic.Int64
+
+	mu              sync.Mutex
+	lastError       string
+	lastDeliveredAt *time.Time
+}
+
+type syslogSender struct {
+	network  string
+	addr     string
+	tls      bool
+	hostname string
+	conn     net.Conn
+}
+
+type httpSender struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+type lokiSender struct {
+	httpSender
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
@@ -0,0 +1,253 @@
+package drain
+
+import (
+	"database/sql"
+	"errors"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetLogDrains(c *gin.Context) {
+	drains, err := db.StoreFrom(c).Drains().List(c.Param("slug"))
+	if err != nil {
+		log.Printf("log drains query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	statuses := []DrainStatus{}
+	for _, d := range drains {
+		statuses = append(statuses, status(d))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"drains": statuses,
+		},
+	})
+}
+
+func CreateLogDrain(c *gin.Context) {
+	var body CreateLogDrainPayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid payload",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	slug := c.Param("slug")
+	if _, err := db.StoreFrom(c).Projects().Get(slug); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Project not found!",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("project lookup query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	if len(body.Sources) == 0 {
+		body.Sources = []string{"build", "runtime"}
+	}
+
+	if err := validateDrain(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	d := utils.LogDrain{
+		ProjectSlug: slug,
+		Name:        body.Name,
+		Kind:        body.Kind,
+		Endpoint:    body.Endpoint,
+		Protocol:    body.Protocol,
+		Token:       body.Token,
+		Sources:     strings.Join(body.Sources, ","),
+		CreatedAt:   time.Now(),
+	}
+
+	id, err := db.StoreFrom(c).Drains().Create(d)
+	if err != nil {
+		log.Printf("log drain insert error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	d.ID = id
+	audit.Record(c, "project.drain.create", "project", slug, nil, d)
+
+	if err := register(d); err != nil {
+		log.Printf("log drain %d (%s) error: %s", d.ID, d.Name, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  true,
+		"message": "Log drain created",
+		"data": gin.H{
+			"drain": d,
+		},
+	})
+}
+
+func DeleteLogDrain(c *gin.Context) {
+	d, ok := lookupDrain(c)
+	if !ok {
+		return
+	}
+
+	if err := db.StoreFrom(c).Drains().Delete(d.ProjectSlug, d.ID); err != nil {
+		log.Printf("log drain delete error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.drain.delete", "project", d.ProjectSlug, d, nil)
+
+	unregister(d.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Log drain deleted",
+	})
+}
+
+// TestLogDrain sends one record straight to the endpoint, bypassing the queue, so
+// configuration mistakes surface immediately.
+func TestLogDrain(c *gin.Context) {
+	d, ok := lookupDrain(c)
+	if !ok {
+		return
+	}
+
+	s, err := newSender(*d)
+	if err == nil {
+		err = s.Send([]utils.LogRecord{{
+			ProjectSlug: d.ProjectSlug,
+			Source:      "build",
+			Timestamp:   time.Now(),
+			Level:       "info",
+			Message:     "infracon log drain test",
+		}})
+		s.Close()
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"message": "Drain test failed",
+			"details": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Test record delivered",
+	})
+}
+
+func lookupDrain(c *gin.Context) (*utils.LogDrain, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid drain id",
+			"status":  false,
+		})
+		return nil, false
+	}
+
+	d, err := db.StoreFrom(c).Drains().Get(c.Param("slug"), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Log drain not found",
+				"status":  false,
+			})
+			return nil, false
+		}
+		log.Printf("log drain query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return nil, false
+	}
+
+	return d, true
+}
+
+func validateDrain(body *CreateLogDrainPayload) error {
+	if err := utils.StringValidator("name", body.Name, utils.ValidatorConfig{
+		NotEmpty:  true,
+		MaxLength: 64,
+	}); err != nil {
+		return err
+	}
+
+	if err := utils.StringValidator("kind", body.Kind, utils.ValidatorConfig{
+		ExpectedValues: []string{"syslog", "http", "loki"},
+	}); err != nil {
+		return err
+	}
+
+	for _, source := range body.Sources {
+		if err := utils.StringValidator("sources", source, utils.ValidatorConfig{
+			ExpectedValues: []string{"build", "runtime"},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if body.Kind == "syslog" {
+		if body.Protocol == "" {
+			body.Protocol = "tcp"
+		}
+		if err := utils.StringValidator("protocol", body.Protocol, utils.ValidatorConfig{
+			ExpectedValues: []string{"tcp", "udp", "tls"},
+		}); err != nil {
+			return err
+		}
+		if _, _, err := net.SplitHostPort(body.Endpoint); err != nil {
+			return errors.New("Syslog endpoint must be host:port")
+		}
+		return nil
+	}
+
+	body.Protocol = ""
+	u, err := url.Parse(body.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("Endpoint must be an http(s) URL")
+	}
+
+	return nil
+}
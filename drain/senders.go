@@ -0,0 +1,234 @@
+package drain
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"infracon/utils"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dialTimeout = 10 * time.Second
+
+func newSender(d utils.LogDrain) (sender, error) {
+	switch d.Kind {
+	case "syslog":
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = "infracon"
+		}
+		network := d.Protocol
+		if network == "tls" || network == "" {
+			network = "tcp"
+		}
+		return &syslogSender{
+			network:  network,
+			addr:     d.Endpoint,
+			tls:      d.Protocol == "tls",
+			hostname: hostname,
+		}, nil
+	case "http":
+		return newHTTPSender(d), nil
+	case "loki":
+		return &lokiSender{*newHTTPSender(d)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown drain kind %q", d.Kind)
+}
+
+func (s *syslogSender) Send(records []utils.LogRecord) error {
+	if s.conn == nil {
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		var conn net.Conn
+		var err error
+		if s.tls {
+			conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{})
+		} else {
+			conn, err = dialer.Dial(s.network, s.addr)
+		}
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	for i, r := range records {
+		msg := formatSyslog(r, s.hostname)
+
+		// Stream transports need framing; RFC 6587 octet counting survives newlines in messages.
+		if s.network != "udp" {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+
+		if _, err := io.WriteString(s.conn, msg); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			// The receiver drops a frame cut off with the connection, so only the
+			// records before this one made it.
+			if i > 0 {
+				return &partialSendError{sent: i, err: err}
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *partialSendError) Error() string {
+	return e.err.Error()
+}
+
+func (e *partialSendError) Unwrap() error {
+	return e.err
+}
+
+func (s *syslogSender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// formatSyslog renders an RFC 5424 message with the user facility.
+func formatSyslog(r utils.LogRecord, hostname string) string {
+	severity := map[string]int{"fatal": 2, "error": 3, "warn": 4, "info": 6, "debug": 7}[r.Level]
+	if severity == 0 {
+		severity = 6
+	}
+
+	sd := "-"
+	if r.Container != "" || r.Stream != "" {
+		sd = fmt.Sprintf(`[infracon@32473 container="%s" stream="%s"]`, escapeSDParam(r.Container), escapeSDParam(r.Stream))
+	}
+
+	return fmt.Sprintf(
+		"<%d>1 %s %s %s - %s %s %s",
+		8+severity,
+		r.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		truncate(hostname, 255),
+		truncate(r.ProjectSlug, 48),
+		truncate(r.Source, 32),
+		sd,
+		r.Message,
+	)
+}
+
+func escapeSDParam(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+func truncate(v string, n int) string {
+	if v == "" {
+		return "-"
+	}
+	if len(v) > n {
+		return v[:n]
+	}
+	return v
+}
+
+func newHTTPSender(d utils.LogDrain) *httpSender {
+	return &httpSender{
+		url:    d.Endpoint,
+		token:  d.Token,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (s *httpSender) Send(records []utils.LogRecord) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return s.post(body)
+}
+
+func (s *httpSender) post(body []byte) error {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// "user:password" tokens use basic auth, which is what hosted Loki expects.
+	if user, password, found := strings.Cut(s.token, ":"); found {
+		req.SetBasicAuth(user, password)
+	} else if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("drain endpoint responded with " + resp.Status)
+	}
+
+	return nil
+}
+
+func (s *httpSender) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// Send pushes one Loki stream per label set, see /loki/api/v1/push.
+func (s *lokiSender) Send(records []utils.LogRecord) error {
+	records = append([]utils.LogRecord(nil), records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+
+	streams := map[string]*lokiStream{}
+	keys := []string{}
+	for _, r := range records {
+		labels := map[string]string{
+			"project": r.ProjectSlug,
+			"source":  r.Source,
+			"level":   r.Level,
+		}
+		if r.Stream != "" {
+			labels["stream"] = r.Stream
+		}
+		if r.Container != "" {
+			labels["container"] = r.Container
+		}
+
+		key := r.Source + "|" + r.Level + "|" + r.Stream + "|" + r.Container
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			keys = append(keys, key)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(r.Timestamp.UnixNano(), 10), r.Message})
+	}
+
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range keys {
+		payload.Streams = append(payload.Streams, streams[key])
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return s.post(body)
+}
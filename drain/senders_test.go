@@ -0,0 +1,272 @@
+package drain
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"infracon/utils"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecords(n int) []utils.LogRecord {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := make([]utils.LogRecord, n)
+	for i := range records {
+		records[i] = utils.LogRecord{
+			ProjectSlug: "web",
+			Source:      "runtime",
+			Timestamp:   start.Add(time.Duration(i) * time.Second),
+			Level:       "info",
+			Stream:      "stdout",
+			Container:   "web-1",
+			Message:     fmt.Sprintf("line %d\nwith a newline", i),
+		}
+	}
+	return records
+}
+
+func TestHTTPSenderPostsRecords(t *testing.T) {
+	var got []utils.LogRecord
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	s := newHTTPSender(utils.LogDrain{Endpoint: server.URL, Token: "secret"})
+	defer s.Close()
+
+	records := testRecords(3)
+	if err := s.Send(records); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("authorization: got %q", auth)
+	}
+	if len(got) != len(records) || got[2].Message != records[2].Message {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestHTTPSenderBasicAuthAndErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "tenant" || password != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := newHTTPSender(utils.LogDrain{Endpoint: server.URL, Token: "tenant:key"}).Send(testRecords(1))
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got %v, want the 503 reported", err)
+	}
+}
+
+func TestLokiSenderGroupsStreams(t *testing.T) {
+	var got struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %s", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s, err := newSender(utils.LogDrain{Kind: "loki", Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	records := testRecords(3)
+	records[1].Level = "error"
+	// Out of order on purpose; Loki rejects entries older than the stream's last one.
+	records[0], records[2] = records[2], records[0]
+	if err := s.Send(records); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Streams) != 2 {
+		t.Fatalf("got %d streams, want 2: %+v", len(got.Streams), got.Streams)
+	}
+	info := got.Streams[0]
+	if info.Stream["level"] != "info" || info.Stream["project"] != "web" || info.Stream["container"] != "web-1" {
+		t.Errorf("labels: %+v", info.Stream)
+	}
+	if len(info.Values) != 2 || info.Values[0][1] != "line 0\nwith a newline" || info.Values[0][0] >= info.Values[1][0] {
+		t.Errorf("values: %+v", info.Values)
+	}
+	if want := strconv.FormatInt(records[2].Timestamp.UnixNano(), 10); info.Values[0][0] != want {
+		t.Errorf("timestamp: got %s, want %s", info.Values[0][0], want)
+	}
+}
+
+// listenSyslog accepts TCP connections and sends every octet-counted frame on the channel.
+func listenSyslog(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	frames := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					length, err := r.ReadString(' ')
+					if err != nil {
+						return
+					}
+					n, err := strconv.Atoi(strings.TrimSpace(length))
+					if err != nil {
+						t.Errorf("bad frame length %q", length)
+						return
+					}
+					msg := make([]byte, n)
+					if _, err := io.ReadFull(r, msg); err != nil {
+						return
+					}
+					frames <- string(msg)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), frames
+}
+
+func receive(t *testing.T, frames <-chan string, n int) []string {
+	t.Helper()
+	var got []string
+	for range n {
+		select {
+		case frame := <-frames:
+			got = append(got, frame)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d frames", len(got), n)
+		}
+	}
+	select {
+	case frame := <-frames:
+		t.Errorf("unexpected extra frame %q", frame)
+	case <-time.After(100 * time.Millisecond):
+	}
+	return got
+}
+
+func TestSyslogSenderTCP(t *testing.T) {
+	addr, frames := listenSyslog(t)
+	s, err := newSender(utils.LogDrain{Kind: "syslog", Protocol: "tcp", Endpoint: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	records := testRecords(2)
+	records[1].Level = "error"
+	if err := s.Send(records); err != nil {
+		t.Fatal(err)
+	}
+
+	got := receive(t, frames, 2)
+	if !strings.HasPrefix(got[0], "<14>1 2026-01-02T03:04:05.000000Z ") {
+		t.Errorf("header: %q", got[0])
+	}
+	if !strings.HasPrefix(got[1], "<11>1 ") {
+		t.Errorf("error severity: %q", got[1])
+	}
+	if !strings.HasSuffix(got[0], ` web - runtime [infracon@32473 container="web-1" stream="stdout"] line 0`+"\nwith a newline") {
+		t.Errorf("body: %q", got[0])
+	}
+}
+
+func TestSyslogSenderUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := newSender(utils.LogDrain{Kind: "syslog", Protocol: "udp", Endpoint: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Send(testRecords(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One message per datagram, so no octet-count prefix.
+	if !strings.HasPrefix(string(buf[:n]), "<14>1 ") {
+		t.Errorf("got %q", buf[:n])
+	}
+}
+
+// brokenConn fails every write after the first ok ones, like a connection that drops mid-batch.
+type brokenConn struct {
+	net.Conn
+	ok int
+}
+
+func (c *brokenConn) Write(p []byte) (int, error) {
+	if c.ok == 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	c.ok--
+	return c.Conn.Write(p)
+}
+
+func TestSyslogRetryResumesAfterPartialWrite(t *testing.T) {
+	addr, frames := listenSyslog(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &syslogSender{network: "tcp", addr: addr, hostname: "host", conn: &brokenConn{Conn: conn, ok: 2}}
+	w := &worker{drain: utils.LogDrain{ID: 1, Name: "test"}, sender: s}
+	defer s.Close()
+
+	records := testRecords(5)
+	w.deliver(records, 2)
+
+	got := receive(t, frames, len(records))
+	for i, frame := range got {
+		if !strings.HasSuffix(frame, records[i].Message) {
+			t.Errorf("frame %d: got %q, want %q", i, frame, records[i].Message)
+		}
+	}
+	if w.dropped.Load() != 0 || w.lastError != "" {
+		t.Errorf("dropped %d, last error %q", w.dropped.Load(), w.lastError)
+	}
+}
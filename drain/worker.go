@@ -0,0 +1,250 @@
+package drain
+
+import (
+	"errors"
+	"infracon/db"
+	"infracon/telemetry"
+	"infracon/utils"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize     = 200
+	flushInterval = time.Second
+)
+
+var (
+	workersMu sync.RWMutex
+	workers   = map[int]*worker{}
+	bySlug    = map[string][]*worker{}
+	startOnce sync.Once
+)
+
+// Start loads the configured drains and subscribes them to build and runtime logs.
+func Start(store db.Store) {
+	startOnce.Do(func() {
+		drains, err := store.Drains().List("")
+		if err != nil {
+			log.Printf("log drain load error: %s", err)
+		}
+		for _, d := range drains {
+			if err := register(d); err != nil {
+				log.Printf("log drain %d (%s) error: %s", d.ID, d.Name, err)
+			}
+		}
+
+		utils.OnLog(dispatch)
+
+		telemetry.NewGaugeFunc(
+			"infracon_log_drain_queue_depth",
+			"Records queued for delivery per log drain.",
+			[]string{"project", "drain"},
+			func(emit func(float64, ...string)) {
+				workersMu.RLock()
+				defer workersMu.RUnlock()
+				for _, w := range workers {
+					emit(float64(len(w.queue)), w.drain.ProjectSlug, w.drain.Name)
+				}
+			},
+		)
+		telemetry.NewGaugeFunc(
+			"infracon_log_drain_dropped_records",
+			"Records a log drain has dropped since it started.",
+			[]string{"project", "drain"},
+			func(emit func(float64, ...string)) {
+				workersMu.RLock()
+				defer workersMu.RUnlock()
+				for _, w := range workers {
+					emit(float64(w.dropped.Load()), w.drain.ProjectSlug, w.drain.Name)
+				}
+			},
+		)
+	})
+}
+
+// dispatch never blocks the caller: a drain that can't keep up drops records and counts them.
+func dispatch(r *utils.LogRecord) {
+	workersMu.RLock()
+	defer workersMu.RUnlock()
+
+	for _, w := range bySlug[r.ProjectSlug] {
+		if !utils.Contains(w.sources, r.Source) {
+			continue
+		}
+		select {
+		case w.queue <- *r:
+		default:
+			if w.dropped.Add(1)%1000 == 1 {
+				log.Printf("log drain %d (%s) is backed up, dropping records", w.drain.ID, w.drain.Name)
+			}
+		}
+	}
+}
+
+func register(d utils.LogDrain) error {
+	s, err := newSender(d)
+	if err != nil {
+		return err
+	}
+
+	w := &worker{
+		drain:   d,
+		sender:  s,
+		sources: strings.Split(d.Sources, ","),
+		queue:   make(chan utils.LogRecord, bufferSize()),
+		done:    make(chan struct{}),
+	}
+
+	workersMu.Lock()
+	workers[d.ID] = w
+	bySlug[d.ProjectSlug] = append(bySlug[d.ProjectSlug], w)
+	workersMu.Unlock()
+
+	go w.run()
+	return nil
+}
+
+// unregister stops the drain after delivering what is already queued.
+func unregister(id int) {
+	workersMu.Lock()
+	w, ok := workers[id]
+	if ok {
+		delete(workers, id)
+		siblings := bySlug[w.drain.ProjectSlug]
+		for i, other := range siblings {
+			if other == w {
+				bySlug[w.drain.ProjectSlug] = append(siblings[:i:i], siblings[i+1:]...)
+				break
+			}
+		}
+		if len(bySlug[w.drain.ProjectSlug]) == 0 {
+			delete(bySlug, w.drain.ProjectSlug)
+		}
+		close(w.queue)
+	}
+	workersMu.Unlock()
+
+	if ok {
+		<-w.done
+	}
+}
+
+// UnregisterProject stops every drain of a project, e.g. when it is deleted.
+func UnregisterProject(slug string) {
+	workersMu.RLock()
+	ids := []int{}
+	for _, w := range bySlug[slug] {
+		ids = append(ids, w.drain.ID)
+	}
+	workersMu.RUnlock()
+
+	for _, id := range ids {
+		unregister(id)
+	}
+}
+
+func status(d utils.LogDrain) DrainStatus {
+	s := DrainStatus{LogDrain: d}
+
+	workersMu.RLock()
+	w, ok := workers[d.ID]
+	workersMu.RUnlock()
+	if !ok {
+		s.LastError = "Drain is not running"
+		return s
+	}
+
+	s.Queued = len(w.queue)
+	s.Dropped = w.dropped.Load()
+	w.mu.Lock()
+	s.LastError = w.lastError
+	s.LastDeliveredAt = w.lastDeliveredAt
+	w.mu.Unlock()
+
+	return s
+}
+
+func (w *worker) run() {
+	defer close(w.done)
+	defer w.sender.Close()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]utils.LogRecord, 0, batchSize)
+	for {
+		select {
+		case r, ok := <-w.queue:
+			if !ok {
+				w.deliver(batch, 1)
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= batchSize {
+				w.deliver(batch, maxRetries())
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.deliver(batch, maxRetries())
+			batch = batch[:0]
+		}
+	}
+}
+
+// deliver retries with exponential backoff. While it waits the queue keeps filling
+// and, once full, dispatch starts dropping, which is the backpressure we want.
+func (w *worker) deliver(batch []utils.LogRecord, attempts int) {
+	if len(batch) == 0 {
+		return
+	}
+
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = w.sender.Send(batch); err == nil {
+			now := time.Now()
+			w.mu.Lock()
+			w.lastError = ""
+			w.lastDeliveredAt = &now
+			w.mu.Unlock()
+			return
+		}
+
+		// Retry only what didn't make it, so the endpoint never sees a record twice.
+		var partial *partialSendError
+		if errors.As(err, &partial) {
+			batch = batch[partial.sent:]
+		}
+
+		if attempt < attempts {
+			time.Sleep(backoff)
+			backoff = min(backoff*2, 30*time.Second)
+		}
+	}
+
+	w.dropped.Add(int64(len(batch)))
+	w.mu.Lock()
+	w.lastError = err.Error()
+	w.mu.Unlock()
+	log.Printf("log drain %d (%s) dropped %d records: %s", w.drain.ID, w.drain.Name, len(batch), err)
+}
+
+func bufferSize() int {
+	size := 10000
+	if v, err := strconv.Atoi(utils.GetEnv("LOG_DRAIN_BUFFER", "")); err == nil && v > 0 {
+		size = v
+	}
+	return size
+}
+
+func maxRetries() int {
+	retries := 5
+	if v, err := strconv.Atoi(utils.GetEnv("LOG_DRAIN_MAX_RETRIES", "")); err == nil && v > 0 {
+		retries = v
+	}
+	return retries
+}
@@ -0,0 +1,19 @@
+package events
+
+import "infracon/utils"
+
+// dockerEvent is one line of `docker events --format {{json .}}`.
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+type subscriber struct {
+	ch   chan utils.SSEEvent
+	slug string
+}
@@ -0,0 +1,144 @@
+package events
+
+import (
+	"infracon/utils"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	feedBacklog      = 512
+	subscriberBuffer = 256
+)
+
+var (
+	feedMu      sync.Mutex
+	feedSeq     int
+	backlog     []utils.SSEEvent
+	subscribers = map[*subscriber]struct{}{}
+)
+
+// Publish hands an event to every dashboard client on the global feed. Clients that
+// fall behind are disconnected and catch up through Last-Event-ID.
+func Publish(e utils.SSEEvent) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	if e.Level == "" {
+		e.Level = "info"
+	}
+
+	feedMu.Lock()
+	defer feedMu.Unlock()
+
+	feedSeq++
+	e.ID = feedSeq
+
+	backlog = append(backlog, e)
+	if len(backlog) > feedBacklog {
+		backlog = backlog[len(backlog)-feedBacklog:]
+	}
+
+	for s := range subscribers {
+		if s.slug != "" && s.slug != e.ProjectSlug {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			delete(subscribers, s)
+			close(s.ch)
+		}
+	}
+}
+
+// PublishStatus announces a change of a project's status.
+func PublishStatus(slug, status, message string) {
+	Publish(utils.SSEEvent{
+		Type:        "status",
+		ProjectSlug: slug,
+		Status:      status,
+		Message:     message,
+	})
+}
+
+// subscribe returns the buffered events after lastId followed by a live channel.
+// An id from before a restart of infracon replays the whole backlog.
+func subscribe(slug string, lastId int) ([]utils.SSEEvent, *subscriber, func()) {
+	s := &subscriber{ch: make(chan utils.SSEEvent, subscriberBuffer), slug: slug}
+
+	feedMu.Lock()
+	if lastId > feedSeq {
+		lastId = 0
+	}
+	var replay []utils.SSEEvent
+	for _, e := range backlog {
+		if e.ID > lastId && (slug == "" || e.ProjectSlug == slug) {
+			replay = append(replay, e)
+		}
+	}
+	subscribers[s] = struct{}{}
+	feedMu.Unlock()
+
+	return replay, s, func() {
+		feedMu.Lock()
+		if _, ok := subscribers[s]; ok {
+			delete(subscribers, s)
+			close(s.ch)
+		}
+		feedMu.Unlock()
+	}
+}
+
+// StreamEvents is the global dashboard feed of project status changes and incidents,
+// optionally narrowed to one project with ?project=<slug>.
+func StreamEvents(c *gin.Context) {
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Query("last_event_id")
+	}
+	lastId, err := strconv.Atoi(lastEventId)
+	if err != nil || lastId < 0 {
+		lastId = 0
+	}
+
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, map[string]any{
+			"status":  false,
+			"message": "SSE not supported",
+		})
+		return
+	}
+
+	stopHeartbeat := utils.StartSSEHeartbeat(c, flusher)
+	defer stopHeartbeat()
+
+	replay, s, unsubscribe := subscribe(c.Query("project"), lastId)
+	defer unsubscribe()
+
+	for _, e := range replay {
+		utils.StreamSSEEvent(e, c, flusher)
+	}
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-s.ch:
+			if !ok {
+				return
+			}
+			utils.StreamSSEEvent(e, c, flusher)
+		}
+	}
+}
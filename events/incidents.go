@@ -0,0 +1,45 @@
+package events
+
+import (
+	"infracon/db"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetIncidents(c *gin.Context) {
+	slug := c.Param("slug")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	incidents, err := db.StoreFrom(c).Incidents().List(slug, limit, offset)
+	if err != nil {
+		log.Printf("incidents query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"incidents": incidents,
+		},
+		"meta": gin.H{
+			"limit":  limit,
+			"offset": offset,
+		},
+	})
+}
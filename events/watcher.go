@@ -0,0 +1,223 @@
+package events
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"infracon/db"
+	"infracon/telemetry"
+	"infracon/utils"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	stateMu sync.Mutex
+	// killed and oomKilled remember why a container is about to die, keyed by container id.
+	killed    = map[string]bool{}
+	oomKilled = map[string]bool{}
+)
+
+// Watch follows the docker events stream for as long as infracon runs, reconnecting
+// from the last seen event if the stream breaks.
+func Watch(store db.Store) {
+	var since int64
+	for {
+		err := watch(store, &since)
+		log.Printf("docker events watcher stopped: %v", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func watch(store db.Store, since *int64) error {
+	args := []string{"events", "--format", "{{json .}}", "--filter", "type=container"}
+	if *since > 0 {
+		args = append(args, "--since", fmt.Sprintf("%d.%09d", *since/int64(time.Second), *since%int64(time.Second)))
+	}
+
+	cmd := exec.Command("docker", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var ev dockerEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			log.Printf("docker event parse error: %s", err)
+			continue
+		}
+		// --since is inclusive, so events at the resume point are skipped.
+		if ev.TimeNano <= *since {
+			continue
+		}
+		*since = ev.TimeNano
+		handle(store, ev)
+	}
+
+	if err := scanner.Err(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	return cmd.Wait()
+}
+
+func handle(store db.Store, ev dockerEvent) {
+	id := ev.Actor.ID
+	action, _, _ := strings.Cut(ev.Action, ":")
+
+	switch action {
+	case "kill":
+		stateMu.Lock()
+		killed[id] = true
+		stateMu.Unlock()
+		return
+	case "destroy":
+		stateMu.Lock()
+		delete(killed, id)
+		delete(oomKilled, id)
+		stateMu.Unlock()
+		return
+	case "start", "die", "oom", "health_status":
+	default:
+		return
+	}
+
+	project := lookupProject(store, ev)
+	if project == nil {
+		return
+	}
+	slug := project.Slug
+	containerName := ev.Actor.Attributes["name"]
+
+	switch action {
+	case "start":
+		stateMu.Lock()
+		delete(killed, id)
+		delete(oomKilled, id)
+		stateMu.Unlock()
+		setStatus(store, project, "running", fmt.Sprintf("%s started", containerName))
+
+	case "oom":
+		stateMu.Lock()
+		oomKilled[id] = true
+		stateMu.Unlock()
+		recordIncident(store, slug, containerName, "oom", nil, fmt.Sprintf("%s ran out of memory", containerName))
+
+	case "die":
+		stateMu.Lock()
+		wasKilled, wasOOM := killed[id], oomKilled[id]
+		delete(killed, id)
+		delete(oomKilled, id)
+		stateMu.Unlock()
+
+		var exitCode *int
+		if n, err := strconv.Atoi(ev.Actor.Attributes["exitCode"]); err == nil {
+			exitCode = &n
+		}
+
+		message := fmt.Sprintf("%s exited", containerName)
+		if exitCode != nil {
+			message = fmt.Sprintf("%s exited with code %d", containerName, *exitCode)
+		}
+
+		// OOM kills were already recorded, and kills come from `docker stop`, `docker rm -f`
+		// or `docker kill`, so only unexpected non-zero exits count as crashes.
+		if !wasOOM && !wasKilled && exitCode != nil && *exitCode != 0 {
+			recordIncident(store, slug, containerName, "crash", exitCode, message)
+		}
+
+		// A deliberate stop keeps its status; the container is expected to be down.
+		if project.Status != nil && *project.Status == "stopped" {
+			return
+		}
+		setStatus(store, project, "exited", message)
+
+	case "health_status":
+		health := strings.TrimSpace(strings.TrimPrefix(ev.Action, "health_status:"))
+		switch health {
+		case "healthy":
+			setStatus(store, project, "running", fmt.Sprintf("%s is healthy", containerName))
+		case "unhealthy":
+			setStatus(store, project, "unhealthy", fmt.Sprintf("%s is unhealthy", containerName))
+		}
+	}
+}
+
+// lookupProject maps an event to the project whose current release emitted it, using
+// the container's labels. Containers started before labelling are matched by name.
+// Containers of releases still being cut over, or already replaced, are ignored.
+func lookupProject(store db.Store, ev dockerEvent) *utils.Project {
+	containerName := ev.Actor.Attributes["name"]
+
+	var project *utils.Project
+	var err error
+	if labels, ok := utils.ParseResourceLabels(ev.Actor.Attributes); ok {
+		project, err = store.Projects().Get(labels.ProjectSlug)
+	} else {
+		project, err = store.Projects().GetByContainer(containerName)
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("docker event project lookup error: %s", err)
+		}
+		return nil
+	}
+
+	if project.ContainerName == nil || *project.ContainerName != containerName {
+		return nil
+	}
+	return project
+}
+
+func setStatus(store db.Store, project *utils.Project, status, message string) {
+	if project.Status != nil && *project.Status == status {
+		return
+	}
+
+	if err := store.Projects().Update(utils.Project{ID: project.ID, Status: &status}); err != nil {
+		log.Printf("project status update error: %s", err)
+		return
+	}
+	project.Status = &status
+	PublishStatus(project.Slug, status, message)
+}
+
+func recordIncident(store db.Store, slug, containerName, kind string, exitCode *int, message string) {
+	incident := utils.Incident{
+		ProjectSlug:   slug,
+		ContainerName: containerName,
+		Kind:          kind,
+		ExitCode:      exitCode,
+		Message:       message,
+	}
+
+	id, err := store.Incidents().Create(incident)
+	if err != nil {
+		log.Printf("incident insert error: %s", err)
+	}
+	incident.ID = id
+	telemetry.ContainerIncidents.Inc(kind)
+
+	// Status carries the incident kind, "crash" or "oom".
+	Publish(utils.SSEEvent{
+		Type:        "incident",
+		Level:       "error",
+		ProjectSlug: slug,
+		Container:   containerName,
+		Status:      kind,
+		ExitCode:    exitCode,
+		Message:     message,
+	})
+}
@@ -0,0 +1,138 @@
+package logstore
+
+import (
+	"infracon/db"
+	"infracon/utils"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	followersMu sync.Mutex
+	followers   = map[string]bool{}
+)
+
+// RunCollector periodically makes sure every project's current container is being
+// followed. Deploys call Follow directly so short-lived containers are not missed.
+func RunCollector(store db.Store) {
+	interval := utils.GetEnvDuration("RUNTIME_LOGS_SCAN_INTERVAL", 15*time.Second)
+
+	for {
+		projects, err := store.Projects().List()
+		if err != nil {
+			log.Printf("runtime log collector error: %s", err)
+		}
+
+		for _, p := range projects {
+			if p.ContainerName != nil && *p.ContainerName != "" {
+				Follow(p.Slug, *p.ContainerName)
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// Follow starts collecting a container's output unless it is already being collected.
+// Collection ends by itself once the container stops or is removed.
+func Follow(slug, container string) {
+	followersMu.Lock()
+	if followers[container] {
+		followersMu.Unlock()
+		return
+	}
+	followers[container] = true
+	followersMu.Unlock()
+
+	go func() {
+		defer func() {
+			followersMu.Lock()
+			delete(followers, container)
+			followersMu.Unlock()
+		}()
+
+		if err := followContainer(slug, container); err != nil {
+			log.Printf("runtime log collection for %s stopped: %s", container, err)
+		}
+	}()
+}
+
+func followContainer(slug, container string) error {
+	cursor := Cursor(slug, container)
+
+	args := []string{"logs", "--timestamps", "--follow"}
+	if !cursor.IsZero() {
+		args = append(args, "--since", cursor.Format(time.RFC3339Nano))
+	}
+	args = append(args, container)
+
+	cmd := exec.Command("docker", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var readers sync.WaitGroup
+	for stream, pipe := range map[string]io.Reader{"stdout": stdout, "stderr": stderr} {
+		readers.Add(1)
+		go func(stream string, r io.Reader) {
+			defer readers.Done()
+			utils.ReadLines(r, utils.MaxLogLineBytes, func(line string) {
+				ts, message, ok := parseDockerLine(line)
+				// --since is inclusive, so the line at the cursor was already stored.
+				if !ok || !ts.After(cursor) {
+					return
+				}
+
+				entry := Entry{
+					Timestamp: ts,
+					Container: container,
+					Stream:    stream,
+					Level:     DetectLevel(message),
+					Message:   message,
+				}
+				if err := Append(slug, entry); err != nil {
+					log.Printf("runtime log append error: %s", err)
+				}
+				utils.EmitLog(&utils.LogRecord{
+					ProjectSlug: slug,
+					Source:      "runtime",
+					Timestamp:   ts,
+					Level:       entry.Level,
+					Stream:      stream,
+					Container:   container,
+					Message:     message,
+				})
+			})
+		}(stream, pipe)
+	}
+
+	readers.Wait()
+	return cmd.Wait()
+}
+
+func parseDockerLine(line string) (time.Time, string, bool) {
+	prefix, message, found := strings.Cut(line, " ")
+	if !found {
+		return time.Time{}, "", false
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, prefix)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	return ts, message, true
+}
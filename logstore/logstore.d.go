@@ -0,0 +1,50 @@
+package logstore
+
+import (
+	"compress/gzip"
+	"os"
+	"regexp"
+	"time"
+)
+
+type Entry struct {
+	Timestamp time.Time `json:"ts"`
+	Container string    `json:"container"`
+	Stream    string    `json:"stream"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+}
+
+type Query struct {
+	From    *time.Time
+	To      *time.Time
+	Text    string
+	Pattern *regexp.Regexp
+	Levels  []string
+	Stream  string
+	Limit   int
+}
+
+type SetRetentionPayload struct {
+	MaxAgeDays int `json:"max_age_days" binding:"required,min=1"`
+	MaxSizeMB  int `json:"max_size_mb" binding:"required,min=1"`
+}
+
+type segment struct {
+	file    *os.File
+	gz      *gzip.Writer
+	path    string
+	opened  time.Time
+	first   time.Time
+	last    time.Time
+	written int64
+	dirty   bool
+}
+
+type segmentInfo struct {
+	path   string
+	start  time.Time
+	end    time.Time
+	size   int64
+	active bool
+}
@@ -0,0 +1,265 @@
+package logstore
+
+import (
+	"database/sql"
+	"errors"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxSearchMatches = 50000
+
+// Search returns entries matching the query in chronological order.
+func Search(slug string, q Query) ([]Entry, error) {
+	infos, err := listSegments(slug)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []Entry{}, nil
+		}
+		return nil, err
+	}
+
+	text := strings.ToLower(q.Text)
+	matches := []Entry{}
+	for _, info := range infos {
+		if q.To != nil && info.start.After(*q.To) {
+			continue
+		}
+		if q.From != nil && info.end.Before(*q.From) {
+			continue
+		}
+
+		err := readSegment(info.path, func(e Entry) bool {
+			if q.From != nil && e.Timestamp.Before(*q.From) {
+				return true
+			}
+			if q.To != nil && e.Timestamp.After(*q.To) {
+				return true
+			}
+			if q.Stream != "" && e.Stream != q.Stream {
+				return true
+			}
+			if len(q.Levels) > 0 && !utils.Contains(q.Levels, e.Level) {
+				return true
+			}
+			if text != "" && !strings.Contains(strings.ToLower(e.Message), text) {
+				return true
+			}
+			if q.Pattern != nil && !q.Pattern.MatchString(e.Message) {
+				return true
+			}
+
+			matches = append(matches, e)
+			return len(matches) < maxSearchMatches
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Timestamp.Before(matches[j].Timestamp)
+	})
+
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+
+	return matches, nil
+}
+
+func SearchLogs(c *gin.Context) {
+	slug := c.Param("slug")
+
+	query := Query{
+		Text:   c.Query("q"),
+		Stream: c.Query("stream"),
+		Limit:  200,
+	}
+
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 && limit <= 1000 {
+		query.Limit = limit
+	}
+
+	if query.Stream != "" {
+		if err := utils.StringValidator("stream", query.Stream, utils.ValidatorConfig{
+			ExpectedValues: []string{"stdout", "stderr"},
+		}); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+				"status":  false,
+			})
+			return
+		}
+	}
+
+	if levels := c.Query("level"); levels != "" {
+		for _, level := range strings.Split(levels, ",") {
+			query.Levels = append(query.Levels, strings.ToLower(strings.TrimSpace(level)))
+		}
+	}
+
+	if pattern := c.Query("regex"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid regex: " + err.Error(),
+				"status":  false,
+			})
+			return
+		}
+		query.Pattern = re
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339Nano, from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "`from` must be an RFC3339 timestamp",
+				"status":  false,
+			})
+			return
+		}
+		query.From = &t
+	}
+
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339Nano, to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "`to` must be an RFC3339 timestamp",
+				"status":  false,
+			})
+			return
+		}
+		query.To = &t
+	}
+
+	entries, err := Search(slug, query)
+	if err != nil {
+		log.Printf("runtime log search error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	meta := gin.H{
+		"limit": query.Limit,
+	}
+	if len(entries) == query.Limit {
+		meta["next_from"] = entries[len(entries)-1].Timestamp.Add(time.Nanosecond).Format(time.RFC3339Nano)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"entries": entries,
+		},
+		"meta": meta,
+	})
+}
+
+func GetRetention(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	policy, err := RetentionPolicy(db.StoreFrom(c), project.Slug)
+	if err != nil {
+		log.Printf("retention policy query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"policy":     policy,
+			"used_bytes": Usage(project.Slug),
+		},
+	})
+}
+
+func SetRetention(c *gin.Context) {
+	var body SetRetentionPayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid payload",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	before, _ := RetentionPolicy(db.StoreFrom(c), project.Slug)
+	policy := utils.LogRetentionPolicy{
+		ProjectSlug: project.Slug,
+		MaxAgeDays:  body.MaxAgeDays,
+		MaxSizeMB:   body.MaxSizeMB,
+	}
+
+	if err := db.StoreFrom(c).Logs().SetRetentionPolicy(policy); err != nil {
+		log.Printf("retention policy update error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.logs.retention.update", "project", project.Slug, before, policy)
+
+	if err := ApplyRetention(policy); err != nil {
+		log.Printf("runtime log retention error for %s: %s", project.Slug, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Retention policy updated",
+		"data": gin.H{
+			"policy": policy,
+		},
+	})
+}
+
+func lookupProject(c *gin.Context) (*utils.Project, bool) {
+	project, err := db.StoreFrom(c).Projects().Get(c.Param("slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Project not found!",
+				"status":  false,
+			})
+			return nil, false
+		}
+		log.Printf("project lookup query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return nil, false
+	}
+
+	return project, true
+}
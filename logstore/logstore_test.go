@@ -0,0 +1,148 @@
+package logstore
+
+import (
+	"infracon/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// useLogsDir stores the test's logs in a temporary directory and forgets the
+// project's cursors and open segment afterwards.
+func useLogsDir(t *testing.T, slug string) {
+	t.Helper()
+	t.Setenv("RUNTIME_LOGS_DIR", t.TempDir())
+	t.Cleanup(func() {
+		if err := Remove(slug); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func appendAll(t *testing.T, slug string, entries []Entry) {
+	t.Helper()
+	for _, e := range entries {
+		if err := Append(slug, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Close(slug); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func messages(entries []Entry) []string {
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Message)
+	}
+	return got
+}
+
+func TestSearchFiltersStoredEntries(t *testing.T) {
+	useLogsDir(t, "search-web")
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	var entries []Entry
+	for i, message := range []string{"listening on :8080", "ERROR db timeout", "GET /health", "WARN slow query", "ERROR db refused"} {
+		entries = append(entries, Entry{
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Container: "web-1",
+			Stream:    "stdout",
+			Level:     DetectLevel(message),
+			Message:   message,
+		})
+	}
+	appendAll(t, "search-web", entries)
+
+	from := start.Add(2 * time.Minute)
+	for _, tt := range []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"everything", Query{}, []string{"listening on :8080", "ERROR db timeout", "GET /health", "WARN slow query", "ERROR db refused"}},
+		{"level", Query{Levels: []string{"error"}}, []string{"ERROR db timeout", "ERROR db refused"}},
+		{"text", Query{Text: "DB"}, []string{"ERROR db timeout", "ERROR db refused"}},
+		{"from", Query{From: &from}, []string{"GET /health", "WARN slow query", "ERROR db refused"}},
+		{"limit", Query{Levels: []string{"error", "warn"}, Limit: 2}, []string{"ERROR db timeout", "WARN slow query"}},
+	} {
+		got, err := Search("search-web", tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(messages(got), tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, messages(got), tt.want)
+		}
+	}
+
+	if got := Cursor("search-web", "web-1"); !got.Equal(entries[len(entries)-1].Timestamp) {
+		t.Errorf("cursor: got %s, want %s", got, entries[len(entries)-1].Timestamp)
+	}
+	if got, err := Search("search-never-logged", Query{}); err != nil || len(got) != 0 {
+		t.Errorf("project without logs: got %v, %v", got, err)
+	}
+}
+
+func TestApplyRetentionDropsExpiredSegments(t *testing.T) {
+	useLogsDir(t, "retention-web")
+
+	old := time.Now().AddDate(0, 0, -10)
+	appendAll(t, "retention-web", []Entry{{Timestamp: old, Container: "web-1", Level: "info", Message: "old"}})
+	appendAll(t, "retention-web", []Entry{{Timestamp: time.Now(), Container: "web-1", Level: "info", Message: "new"}})
+
+	if err := ApplyRetention(utils.LogRetentionPolicy{ProjectSlug: "retention-web", MaxAgeDays: 7, MaxSizeMB: 100}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Search("retention-web", Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(messages(got), []string{"new"}) {
+		t.Errorf("got %q, want only the recent entry", messages(got))
+	}
+}
+
+func TestFollowContainerStoresOnlyLinesAfterTheCursor(t *testing.T) {
+	useLogsDir(t, "follow-web")
+
+	// The fake docker prints the same output on every `docker logs`, as a restarted
+	// container's log does, and records the arguments it got.
+	bin := t.TempDir()
+	args := filepath.Join(t.TempDir(), "args")
+	script := "#!/bin/sh\necho \"$*\" >> " + args + "\n" +
+		"echo '2026-01-02T03:04:05.000000001Z starting'\n" +
+		"echo '2026-01-02T03:04:06.000000001Z error: no config' >&2\n" +
+		"echo 'not a docker line'\n"
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for range 2 {
+		if err := followContainer("follow-web", "web-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Close("follow-web"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Search("follow-web", Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Message != "starting" || got[0].Stream != "stdout" || got[1].Level != "error" || got[1].Stream != "stderr" {
+		t.Errorf("stored %+v", got)
+	}
+	calls, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "logs --timestamps --follow web-1\nlogs --timestamps --follow --since 2026-01-02T03:04:06.000000001Z web-1\n"
+	if string(calls) != want {
+		t.Errorf("docker calls:\n%s\nwant:\n%s", calls, want)
+	}
+}
@@ -0,0 +1,126 @@
+package logstore
+
+import (
+	"database/sql"
+	"errors"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+func DefaultRetentionPolicy(slug string) utils.LogRetentionPolicy {
+	policy := utils.LogRetentionPolicy{
+		ProjectSlug: slug,
+		MaxAgeDays:  7,
+		MaxSizeMB:   100,
+	}
+
+	if days, err := strconv.Atoi(os.Getenv("RUNTIME_LOGS_MAX_AGE_DAYS")); err == nil && days > 0 {
+		policy.MaxAgeDays = days
+	}
+	if size, err := strconv.Atoi(os.Getenv("RUNTIME_LOGS_MAX_SIZE_MB")); err == nil && size > 0 {
+		policy.MaxSizeMB = size
+	}
+
+	return policy
+}
+
+func RetentionPolicy(store db.Store, slug string) (utils.LogRetentionPolicy, error) {
+	policy, err := store.Logs().GetRetentionPolicy(slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultRetentionPolicy(slug), nil
+	}
+	if err != nil {
+		return utils.LogRetentionPolicy{}, err
+	}
+	return *policy, nil
+}
+
+// RunRetention applies each project's retention policy on a fixed interval.
+func RunRetention(store db.Store) {
+	interval := utils.GetEnvDuration("RUNTIME_LOGS_RETENTION_INTERVAL", time.Hour)
+
+	for {
+		dirs, err := os.ReadDir(Root())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("runtime log retention error: %s", err)
+		}
+
+		for _, dir := range dirs {
+			if !dir.IsDir() {
+				continue
+			}
+
+			policy, err := RetentionPolicy(store, dir.Name())
+			if err != nil {
+				log.Printf("runtime log retention policy error: %s", err)
+				continue
+			}
+
+			if err := ApplyRetention(policy); err != nil {
+				log.Printf("runtime log retention error for %s: %s", dir.Name(), err)
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// ApplyRetention drops closed segments older than the policy's age, then the oldest
+// closed segments until the project fits within its size budget.
+func ApplyRetention(policy utils.LogRetentionPolicy) error {
+	infos, err := listSegments(policy.ProjectSlug)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -policy.MaxAgeDays)
+	var kept []segmentInfo
+	var total int64
+	for _, info := range infos {
+		if !info.active && info.end.Before(cutoff) {
+			if err := os.Remove(info.path); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, info)
+		total += info.size
+	}
+
+	budget := int64(policy.MaxSizeMB) << 20
+	for _, info := range kept {
+		if total <= budget {
+			break
+		}
+		if info.active {
+			continue
+		}
+		if err := os.Remove(info.path); err != nil {
+			return err
+		}
+		total -= info.size
+	}
+
+	return nil
+}
+
+// Usage returns the bytes stored for a project.
+func Usage(slug string) int64 {
+	infos, err := listSegments(slug)
+	if err != nil {
+		return 0
+	}
+
+	var total int64
+	for _, info := range infos {
+		total += info.size
+	}
+	return total
+}
@@ -0,0 +1,402 @@
+package logstore
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"infracon/utils"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runtime logs live outside infracon.db as gzip segments, one directory per project:
+//
+//	<RUNTIME_LOGS_DIR>/<slug>/<start-millis>.log.gz               segment being written
+//	<RUNTIME_LOGS_DIR>/<slug>/<start-millis>-<end-millis>.log.gz  closed segment
+//
+// Each segment holds one JSON encoded Entry per line. The time range in the file
+// name lets searches and retention skip segments without opening them.
+
+const (
+	maxSegmentBytes = 8 << 20
+	maxSegmentAge   = time.Hour
+	cursorFile      = "cursor.json"
+)
+
+var (
+	storeMu  sync.Mutex
+	segments = map[string]*segment{}
+	cursors  = map[string]map[string]time.Time{}
+	initOnce sync.Once
+
+	levelPattern = regexp.MustCompile(`(?i)\b(fatal|panic|critical|crit|error|err|warning|warn|info|debug|trace)\b`)
+)
+
+func Root() string {
+	return utils.GetEnv("RUNTIME_LOGS_DIR", "infracon-logs")
+}
+
+func projectDir(slug string) string {
+	return filepath.Join(Root(), slug)
+}
+
+func ensureInit() {
+	initOnce.Do(func() {
+		recoverActiveSegments()
+		go flushLoop()
+	})
+}
+
+// Append stores an entry for the project, rotating the active segment when it grows too large or old.
+func Append(slug string, e Entry) error {
+	ensureInit()
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	seg, ok := segments[slug]
+	if !ok {
+		var err error
+		seg, err = openSegment(slug, e.Timestamp)
+		if err != nil {
+			return err
+		}
+		segments[slug] = seg
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	n, err := seg.gz.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+
+	seg.written += int64(n)
+	seg.dirty = true
+	if e.Timestamp.Before(seg.first) {
+		seg.first = e.Timestamp
+	}
+	if e.Timestamp.After(seg.last) {
+		seg.last = e.Timestamp
+	}
+
+	if cursors[slug] == nil {
+		cursors[slug] = loadCursors(slug)
+	}
+	if e.Timestamp.After(cursors[slug][e.Container]) {
+		cursors[slug][e.Container] = e.Timestamp
+	}
+
+	if seg.written >= maxSegmentBytes || time.Since(seg.opened) >= maxSegmentAge {
+		delete(segments, slug)
+		return closeSegment(seg)
+	}
+
+	return nil
+}
+
+// Cursor returns the timestamp of the last stored line of a container.
+func Cursor(slug, container string) time.Time {
+	ensureInit()
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	if cursors[slug] == nil {
+		cursors[slug] = loadCursors(slug)
+	}
+	return cursors[slug][container]
+}
+
+// Close flushes and closes the project's active segment, e.g. before its logs are deleted.
+func Close(slug string) error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	seg, ok := segments[slug]
+	if !ok {
+		return nil
+	}
+	delete(segments, slug)
+	saveCursors(slug)
+	return closeSegment(seg)
+}
+
+// Remove deletes every stored runtime log line of the project.
+func Remove(slug string) error {
+	if err := Close(slug); err != nil {
+		log.Printf("runtime log segment close error: %s", err)
+	}
+
+	storeMu.Lock()
+	delete(cursors, slug)
+	storeMu.Unlock()
+
+	return os.RemoveAll(projectDir(slug))
+}
+
+func openSegment(slug string, start time.Time) (*segment, error) {
+	dir := projectDir(slug)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d.log.gz", start.UnixMilli()))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &segment{
+		file:   file,
+		gz:     gzip.NewWriter(file),
+		path:   path,
+		opened: time.Now(),
+		first:  start,
+		last:   start,
+	}, nil
+}
+
+func closeSegment(seg *segment) error {
+	if err := seg.gz.Close(); err != nil {
+		seg.file.Close()
+		return err
+	}
+	if err := seg.file.Close(); err != nil {
+		return err
+	}
+
+	if seg.written == 0 {
+		return os.Remove(seg.path)
+	}
+
+	closed := filepath.Join(filepath.Dir(seg.path), fmt.Sprintf("%d-%d.log.gz", seg.first.UnixMilli(), seg.last.UnixMilli()))
+	return os.Rename(seg.path, closed)
+}
+
+// flushLoop makes freshly appended lines visible to searches and persists cursors.
+func flushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		storeMu.Lock()
+		for slug, seg := range segments {
+			if time.Since(seg.opened) >= maxSegmentAge {
+				delete(segments, slug)
+				if err := closeSegment(seg); err != nil {
+					log.Printf("runtime log segment close error: %s", err)
+				}
+				saveCursors(slug)
+				continue
+			}
+
+			if !seg.dirty {
+				continue
+			}
+			if err := seg.gz.Flush(); err != nil {
+				log.Printf("runtime log flush error: %s", err)
+			}
+			seg.dirty = false
+			saveCursors(slug)
+		}
+		storeMu.Unlock()
+	}
+}
+
+// recoverActiveSegments closes out segments left open by a previous process. Their gzip
+// stream has no trailer, which readSegment tolerates.
+func recoverActiveSegments() {
+	dirs, err := os.ReadDir(Root())
+	if err != nil {
+		return
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		infos, err := listSegments(dir.Name())
+		if err != nil {
+			continue
+		}
+
+		for _, info := range infos {
+			if !info.active {
+				continue
+			}
+			stat, err := os.Stat(info.path)
+			if err != nil || stat.Size() == 0 {
+				os.Remove(info.path)
+				continue
+			}
+			closed := strings.TrimSuffix(info.path, ".log.gz") + fmt.Sprintf("-%d.log.gz", stat.ModTime().UnixMilli())
+			os.Rename(info.path, closed)
+		}
+	}
+}
+
+func listSegments(slug string) ([]segmentInfo, error) {
+	files, err := os.ReadDir(projectDir(slug))
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []segmentInfo
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, ".log.gz") {
+			continue
+		}
+
+		bounds := strings.SplitN(strings.TrimSuffix(name, ".log.gz"), "-", 2)
+		start, err := strconv.ParseInt(bounds[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info := segmentInfo{
+			path:  filepath.Join(projectDir(slug), name),
+			start: time.UnixMilli(start),
+			end:   time.Now(),
+		}
+
+		if len(bounds) == 2 {
+			end, err := strconv.ParseInt(bounds[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			info.end = time.UnixMilli(end)
+		} else {
+			// Lines may arrive out of order while a segment is open, so its name is only a hint.
+			info.start = time.Time{}
+			info.active = true
+		}
+
+		if stat, err := f.Info(); err == nil {
+			info.size = stat.Size()
+		}
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].active != infos[j].active {
+			return !infos[i].active
+		}
+		return infos[i].start.Before(infos[j].start)
+	})
+
+	return infos, nil
+}
+
+// readSegment calls fn for each entry until it returns false. Segments still being
+// written end in a partial gzip block; everything flushed before it is returned.
+func readSegment(path string, fn func(Entry) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 2*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !fn(e) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func loadCursors(slug string) map[string]time.Time {
+	values := map[string]time.Time{}
+
+	data, err := os.ReadFile(filepath.Join(projectDir(slug), cursorFile))
+	if err != nil {
+		return values
+	}
+
+	json.Unmarshal(data, &values)
+	return values
+}
+
+func saveCursors(slug string) {
+	values, ok := cursors[slug]
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return
+	}
+
+	if err := os.WriteFile(filepath.Join(projectDir(slug), cursorFile), data, 0644); err != nil {
+		log.Printf("runtime log cursor error: %s", err)
+	}
+}
+
+// DetectLevel guesses a log level from a JSON "level" field or the first level-like word.
+func DetectLevel(message string) string {
+	if strings.HasPrefix(message, "{") {
+		var structured struct {
+			Level    string `json:"level"`
+			Severity string `json:"severity"`
+		}
+		if json.Unmarshal([]byte(message), &structured) == nil {
+			if level := normalizeLevel(structured.Level + structured.Severity); level != "" {
+				return level
+			}
+		}
+	}
+
+	if match := levelPattern.FindString(message); match != "" {
+		return normalizeLevel(match)
+	}
+
+	return "info"
+}
+
+func normalizeLevel(level string) string {
+	switch strings.ToLower(level) {
+	case "fatal", "panic", "critical", "crit":
+		return "fatal"
+	case "error", "err":
+		return "error"
+	case "warning", "warn":
+		return "warn"
+	case "info":
+		return "info"
+	case "debug", "trace":
+		return "debug"
+	}
+	return ""
+}
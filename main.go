@@ -1,13 +1,25 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
+	"infracon/audit"
 	"infracon/auth"
+	"infracon/backup"
 	"infracon/db"
+	"infracon/disk"
+	"infracon/drain"
+	"infracon/events"
+	"infracon/logstore"
+	"infracon/metrics"
 	"infracon/project"
+	"infracon/telemetry"
+	"infracon/utils"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -19,61 +31,34 @@ import (
 func init() {
 	log.SetFlags(log.Ldate | log.Lshortfile)
 	godotenv.Load()
-	db, err := db.GetDatabase()
-	if err != nil {
-		panic(err)
-	}
-	db.Exec(
-		`
-			CREATE TABLE IF NOT EXISTS users (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				email TEXT NOT NULL UNIQUE,
-				password TEXT NOT NULL,
-				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-			);
-
-			CREATE TABLE IF NOT EXISTS github_tokens (
-				user_id INTEGER PRIMARY KEY AUTOINCREMENT,
-				token TEXT NOT NULL,
-				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-			);
-
-			CREATE TABLE IF NOT EXISTS projects (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				name TEXT NOT NULL,
-				slug TEXT NOT NULL UNIQUE,
-				type TEXT,
-				env TEXT,
-				github_repo TEXT,
-				project_path TEXT,
-				top_level_directories TEXT,
-				status TEXT NOT NULL DEFAULT 'building',
-				container_name TEXT,
-				current_image TEXT,
-				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-			);
-
-			CREATE TABLE IF NOT EXISTS docker_images (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				project_slug TEXT NOT NULL,
-				image_tag TEXT,
-				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-			);
-
-			CREATE TABLE IF NOT EXISTS logs (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				project_slug TEXT NOT NULL,
-				log TEXT,
-				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-			);
-		`,
-	)
 }
 
 func main() {
+	conn, err := db.Open(utils.GetEnv("DATABASE_PATH", "./infracon.db"))
+	if err != nil {
+		log.Fatalf("could not open database: %s", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(conn, os.Args[2:])
+		return
+	}
+
+	if err := db.Migrate(conn); err != nil {
+		log.Fatalf("database migration failed: %s", err)
+	}
+	store := db.NewStore(conn)
+
+	if err := auth.CheckOAuthConfig(); err != nil {
+		log.Fatalf("oauth config: %s", err)
+	}
+
 	router := gin.Default()
+	// ClientIP keys the sign-in limiter and the audit trail, so forwarded headers are
+	// only believed from proxies listed in TRUSTED_PROXIES.
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %s", err)
+	}
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
@@ -82,25 +67,122 @@ func main() {
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
+	router.Use(telemetry.Middleware)
+	router.Use(db.Inject(store))
+	router.GET("/metrics", telemetry.Handler)
 	router.POST("/api/auth/sign-in", auth.Signin)
 	router.POST("/api/auth/sign-up", auth.SignUp)
 	router.POST("/api/auth/forgot-password", auth.ResetPassword)
+	router.GET("/api/auth/events", Authenticate, auth.GetAuthEvents)
+	router.GET("/api/auth/providers", auth.GetAuthProviders)
+	router.GET("/api/auth/oauth/:provider", auth.StartOAuth)
+	router.GET("/api/auth/oauth/:provider/callback", auth.OAuthCallback)
 
 	projectRouter := router.Group("/api/project")
 	projectRouter.Use(Authenticate)
 
 	projectRouter.POST("/", project.CreateProject)
 	projectRouter.GET("/:slug", project.GetProject)
-	projectRouter.POST("/source", project.AddProjectSource)
+	projectRouter.DELETE("/:slug", project.DeleteProject)
+	projectRouter.POST("/:slug/stop", project.StopProject)
+	projectRouter.POST("/:slug/start", project.StartProject)
+	projectRouter.POST("/:slug/restart", project.RestartProject)
+	projectRouter.POST("/source", project.UpdateProjectSource)
 	projectRouter.POST("/env", project.SetEnvironmentVariable)
+	projectRouter.POST("/rollback", project.RollDeployment)
+	projectRouter.GET("/:slug/rollbacks", project.GetRollbackTargets)
+	projectRouter.PUT("/:slug/rollbacks/:tag/pin", project.PinImage)
+	projectRouter.DELETE("/:slug/rollbacks/:tag/pin", project.UnpinImage)
+	projectRouter.GET("/:slug/images/retention", project.GetImageRetention)
+	projectRouter.PUT("/:slug/images/retention", project.SetImageRetention)
+	projectRouter.GET("/:slug/deployments", project.GetDeployments)
+	projectRouter.GET("/:slug/deployments/:id/logs", project.GetDeploymentLogs)
+	projectRouter.GET("/:slug/deployments/:id/events", project.StreamDeploymentEvents)
+	projectRouter.GET("/:slug/logs/runtime", project.StreamRuntimeLogs)
+	projectRouter.GET("/:slug/logs/search", logstore.SearchLogs)
+	projectRouter.GET("/:slug/resources", project.GetProjectResources)
+	projectRouter.PUT("/:slug/resources", project.SetProjectResources)
+	projectRouter.GET("/:slug/metrics", metrics.GetProjectMetrics)
+	projectRouter.GET("/:slug/incidents", events.GetIncidents)
+	projectRouter.GET("/:slug/disk", disk.GetProjectDiskUsage)
+	projectRouter.GET("/:slug/volumes", project.GetVolumes)
+	projectRouter.POST("/:slug/volumes", project.CreateVolume)
+	projectRouter.DELETE("/:slug/volumes/:id", project.DeleteVolume)
+	projectRouter.GET("/:slug/links", project.GetLinks)
+	projectRouter.POST("/:slug/links", project.CreateLink)
+	projectRouter.DELETE("/:slug/links/:target", project.DeleteLink)
+	projectRouter.GET("/:slug/backup/targets", backup.GetBackupTargets)
+	projectRouter.POST("/:slug/backup/targets", backup.CreateBackupTarget)
+	projectRouter.DELETE("/:slug/backup/targets/:id", backup.DeleteBackupTarget)
+	projectRouter.POST("/:slug/backup/targets/:id/test", backup.TestBackupTarget)
+	projectRouter.GET("/:slug/backup/policy", backup.GetBackupPolicy)
+	projectRouter.PUT("/:slug/backup/policy", backup.SetBackupPolicy)
+	projectRouter.GET("/:slug/snapshots", backup.GetSnapshots)
+	projectRouter.POST("/:slug/snapshots", backup.CreateSnapshot)
+	projectRouter.DELETE("/:slug/snapshots/:id", backup.DeleteSnapshot)
+	projectRouter.POST("/:slug/snapshots/:id/restore", backup.RestoreSnapshot)
+	projectRouter.GET("/:slug/drains", drain.GetLogDrains)
+	projectRouter.POST("/:slug/drains", drain.CreateLogDrain)
+	projectRouter.DELETE("/:slug/drains/:id", drain.DeleteLogDrain)
+	projectRouter.POST("/:slug/drains/:id/test", drain.TestLogDrain)
+	projectRouter.GET("/:slug/logs/retention", logstore.GetRetention)
+	projectRouter.PUT("/:slug/logs/retention", logstore.SetRetention)
 	projectRouter.POST("/github/token", project.AddGithubToken)
 	projectRouter.GET("/github/token", project.GetGithubTokens)
 	projectRouter.POST("/github/repos", project.GetGithubRepos)
 	projectRouter.POST("/github/repos/branches", project.GetGithubRepoBranches)
 
+	addonRouter := router.Group("/api/addons")
+	addonRouter.Use(Authenticate)
+
+	addonRouter.GET("/", project.GetAddons)
+	addonRouter.POST("/", project.CreateAddon)
+
+	auditRouter := router.Group("/api/audit")
+	auditRouter.Use(Authenticate)
+
+	auditRouter.GET("/", audit.GetAuditLogs)
+	auditRouter.GET("/export", audit.ExportAuditLogs)
+
+	eventsRouter := router.Group("/api/events")
+	eventsRouter.Use(Authenticate)
+
+	eventsRouter.GET("/", events.StreamEvents)
+
+	systemRouter := router.Group("/api/system")
+	systemRouter.Use(Authenticate)
+
+	systemRouter.GET("/reconcile", project.GetReconcileReport)
+	systemRouter.POST("/reconcile", project.RunReconcile)
+	systemRouter.GET("/disk", disk.GetDiskUsage)
+	systemRouter.GET("/gc", project.GetGCReport)
+	systemRouter.POST("/gc", project.RunGC)
+
+	drain.Start(store)
+	go logstore.RunCollector(store)
+	go logstore.RunRetention(store)
+	go metrics.RunCollector(store)
+	go project.RunReconciler(store)
+	go project.RunImageGC(store)
+	go events.Watch(store)
+	go disk.RunMonitor()
+	go backup.RunScheduler(store)
+
 	router.Run(":3000")
 }
 
+// trustedProxies reads the comma-separated TRUSTED_PROXIES (IPs or CIDRs). Unset means
+// no proxy is trusted and the client IP is always the connection's remote address.
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
 func Authenticate(c *gin.Context) {
 	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
@@ -152,3 +234,38 @@ func Authenticate(c *gin.Context) {
 
 	c.Next()
 }
+
+// runMigrateCommand handles `infracon migrate [status|up]`: status prints every
+// migration and whether it is applied, up applies the pending ones.
+func runMigrateCommand(conn *sql.DB, args []string) {
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if err := db.Migrate(conn); err != nil {
+			log.Fatalf("database migration failed: %s", err)
+		}
+		fmt.Println("database is up to date")
+	case "status":
+		statuses, err := db.GetMigrationStatus(conn)
+		if err != nil {
+			log.Fatalf("could not read migration status: %s", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, m := range statuses {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, applied)
+		}
+		w.Flush()
+	default:
+		fmt.Fprintf(os.Stderr, "usage: %s migrate [status|up]\n", os.Args[0])
+		os.Exit(2)
+	}
+}
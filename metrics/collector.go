@@ -0,0 +1,281 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"infracon/db"
+	"infracon/telemetry"
+	"infracon/utils"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	rollups = []struct {
+		from, to  string
+		size      time.Duration
+		retention time.Duration
+	}{
+		{"1m", "1h", time.Hour, 48 * time.Hour},
+		{"1h", "1d", 24 * time.Hour, 60 * 24 * time.Hour},
+	}
+	dailyRetention = 2 * 365 * 24 * time.Hour
+
+	samplesMu   sync.RWMutex
+	latest      = map[string]Sample{}
+	tracked     []trackedContainer
+	lastCollect time.Time
+	previous    = map[string]Sample{}
+	minutes     = map[string]*minute{}
+)
+
+func init() {
+	telemetry.NewGaugeFunc(
+		"infracon_container_up",
+		"Whether the project's current container was running at the last sample.",
+		[]string{"project", "container"},
+		func(emit func(float64, ...string)) {
+			samplesMu.RLock()
+			defer samplesMu.RUnlock()
+			for _, c := range tracked {
+				up := 0.0
+				if s, ok := latest[c.slug]; ok && s.Container == c.name && s.Timestamp.Equal(lastCollect) {
+					up = 1
+				}
+				emit(up, c.slug, c.name)
+			}
+		},
+	)
+}
+
+// RunCollector samples every project's current container each METRICS_INTERVAL,
+// keeps one minute buckets up to date and rolls them up into hours and days.
+func RunCollector(store db.Store) {
+	interval := utils.GetEnvDuration("METRICS_INTERVAL", 15*time.Second)
+	var lastPrune time.Time
+
+	for {
+		collect(store)
+
+		now := time.Now()
+		for _, r := range rollups {
+			// The previous bucket is included so it is completed right after a boundary.
+			if err := store.Metrics().Rollup(r.from, r.to, r.size, now.Add(-r.size)); err != nil {
+				log.Printf("metrics rollup error: %s", err)
+			}
+		}
+
+		if now.Sub(lastPrune) >= time.Hour {
+			for _, r := range rollups {
+				store.Metrics().Prune(r.from, now.Add(-r.retention))
+			}
+			store.Metrics().Prune("1d", now.Add(-dailyRetention))
+			lastPrune = now
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func collect(store db.Store) {
+	projects, err := store.Projects().List()
+	if err != nil {
+		log.Printf("metrics collector error: %s", err)
+		return
+	}
+
+	containers := map[string]string{}
+	for _, p := range projects {
+		if p.ContainerName != nil && *p.ContainerName != "" {
+			containers[*p.ContainerName] = p.Slug
+		}
+	}
+	if len(containers) == 0 {
+		return
+	}
+
+	samples, err := sample(containers)
+	if err != nil {
+		log.Printf("metrics collector error: %s", err)
+	}
+
+	samplesMu.Lock()
+	tracked = tracked[:0]
+	for name, slug := range containers {
+		tracked = append(tracked, trackedContainer{slug: slug, name: name})
+	}
+	lastCollect = time.Time{}
+	if len(samples) > 0 {
+		lastCollect = samples[0].Timestamp
+	}
+	samplesMu.Unlock()
+
+	for _, s := range samples {
+		if err := record(store, s); err != nil {
+			log.Printf("metrics write error for %s: %s", s.ProjectSlug, err)
+		}
+	}
+}
+
+func sample(containers map[string]string) ([]Sample, error) {
+	args := []string{"stats", "--no-stream", "--format", "{{json .}}"}
+	for name := range containers {
+		args = append(args, name)
+	}
+
+	// docker stats fails as a whole when one container is gone but still prints the rest.
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	now := time.Now()
+	var samples []Sample
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		var stats dockerStats
+		if err := json.Unmarshal(scanner.Bytes(), &stats); err != nil {
+			continue
+		}
+
+		slug, ok := containers[stats.Name]
+		if !ok {
+			continue
+		}
+
+		s, err := parseStats(stats)
+		if err != nil {
+			log.Printf("metrics parse error for %s: %s", stats.Name, err)
+			continue
+		}
+		s.ProjectSlug = slug
+		s.Timestamp = now
+		samples = append(samples, s)
+	}
+
+	if runErr != nil && len(samples) == 0 {
+		return nil, fmt.Errorf("%s: %s", runErr, strings.TrimSpace(stderr.String()))
+	}
+
+	return samples, nil
+}
+
+// record folds a sample into the project's current minute and upserts it. Network and
+// block I/O are cumulative per container, so buckets store the growth since the last sample.
+func record(store db.Store, s Sample) error {
+	samplesMu.Lock()
+	prev, hasPrev := previous[s.ProjectSlug]
+	previous[s.ProjectSlug] = s
+	latest[s.ProjectSlug] = s
+
+	bucket := s.Timestamp.Truncate(time.Minute)
+	m, ok := minutes[s.ProjectSlug]
+	if !ok || !m.bucket.Equal(bucket) {
+		m = &minute{bucket: bucket}
+		minutes[s.ProjectSlug] = m
+	}
+
+	m.samples++
+	m.cpuSum += s.CPUPercent
+	m.cpuMax = max(m.cpuMax, s.CPUPercent)
+	m.memSum += s.MemUsage
+	m.memMax = max(m.memMax, s.MemUsage)
+	m.limit = s.MemLimit
+	if hasPrev {
+		m.netRx += counterDelta(prev, s, prev.NetRx, s.NetRx)
+		m.netTx += counterDelta(prev, s, prev.NetTx, s.NetTx)
+		m.blockRead += counterDelta(prev, s, prev.BlockRead, s.BlockRead)
+		m.blockWrite += counterDelta(prev, s, prev.BlockWrite, s.BlockWrite)
+	}
+
+	point := utils.MetricPoint{
+		ProjectSlug: s.ProjectSlug,
+		Resolution:  "1m",
+		Bucket:      m.bucket,
+		Samples:     m.samples,
+		CPUAvg:      m.cpuSum / float64(m.samples),
+		CPUMax:      m.cpuMax,
+		MemAvg:      m.memSum / int64(m.samples),
+		MemMax:      m.memMax,
+		MemLimit:    m.limit,
+		NetRx:       m.netRx,
+		NetTx:       m.netTx,
+		BlockRead:   m.blockRead,
+		BlockWrite:  m.blockWrite,
+	}
+	samplesMu.Unlock()
+
+	return store.Metrics().Upsert(point)
+}
+
+// counterDelta treats a new container, or a counter that went backwards, as a reset.
+func counterDelta(prev, cur Sample, before, after int64) int64 {
+	if prev.Container != cur.Container || after < before {
+		return after
+	}
+	return after - before
+}
+
+// Latest returns the most recent sample of a project, if it has been sampled yet.
+func Latest(slug string) (Sample, bool) {
+	samplesMu.RLock()
+	defer samplesMu.RUnlock()
+	s, ok := latest[slug]
+	return s, ok
+}
+
+func parseStats(stats dockerStats) (Sample, error) {
+	s := Sample{Container: stats.Name}
+
+	var err error
+	if s.CPUPercent, err = parsePercent(stats.CPUPerc); err != nil {
+		return s, err
+	}
+	if s.MemPercent, err = parsePercent(stats.MemPerc); err != nil {
+		return s, err
+	}
+	if s.MemUsage, s.MemLimit, err = parseBytePair(stats.MemUsage); err != nil {
+		return s, err
+	}
+	if s.NetRx, s.NetTx, err = parseBytePair(stats.NetIO); err != nil {
+		return s, err
+	}
+	if s.BlockRead, s.BlockWrite, err = parseBytePair(stats.BlockIO); err != nil {
+		return s, err
+	}
+	s.PIDs, _ = strconv.Atoi(stats.PIDs)
+
+	return s, nil
+}
+
+func parsePercent(v string) (float64, error) {
+	v = strings.TrimSuffix(strings.TrimSpace(v), "%")
+	if v == "" || v == "--" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+func parseBytePair(v string) (int64, int64, error) {
+	left, right, found := strings.Cut(v, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("unexpected value %q", v)
+	}
+
+	a, err := utils.ParseDockerSize(left)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := utils.ParseDockerSize(right)
+	if err != nil {
+		return 0, 0, err
+	}
+	return a, b, nil
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"infracon/db/dbtest"
+	"infracon/utils"
+	"testing"
+	"time"
+)
+
+func TestRecordAndRollupBuckets(t *testing.T) {
+	store := dbtest.OpenStore(t)
+	hour := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	// record keeps the last sample and the current minute of each project in memory.
+	samplesMu.Lock()
+	delete(previous, "rollup-web")
+	delete(minutes, "rollup-web")
+	samplesMu.Unlock()
+
+	for _, s := range []Sample{
+		{Container: "web-1", Timestamp: hour, CPUPercent: 10, MemUsage: 100, MemLimit: 1000, NetRx: 1000},
+		{Container: "web-1", Timestamp: hour.Add(30 * time.Second), CPUPercent: 30, MemUsage: 300, MemLimit: 1000, NetRx: 1500},
+		// A new container starts its counters from zero.
+		{Container: "web-2", Timestamp: hour.Add(90 * time.Second), CPUPercent: 50, MemUsage: 500, MemLimit: 2000, NetRx: 40},
+	} {
+		s.ProjectSlug = "rollup-web"
+		if err := record(store, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	minutes, err := store.Metrics().List("rollup-web", "1m", hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []utils.MetricPoint{
+		{Bucket: hour, Samples: 2, CPUAvg: 20, CPUMax: 30, MemAvg: 200, MemMax: 300, MemLimit: 1000, NetRx: 500},
+		{Bucket: hour.Add(time.Minute), Samples: 1, CPUAvg: 50, CPUMax: 50, MemAvg: 500, MemMax: 500, MemLimit: 2000, NetRx: 40},
+	}
+	if len(minutes) != len(want) {
+		t.Fatalf("minute buckets: got %+v", minutes)
+	}
+	for i := range want {
+		if !samePoint(minutes[i], want[i]) {
+			t.Errorf("minute %d: got %+v, want %+v", i, minutes[i], want[i])
+		}
+	}
+
+	if err := store.Metrics().Rollup("1m", "1h", time.Hour, hour); err != nil {
+		t.Fatal(err)
+	}
+	hours, err := store.Metrics().List("rollup-web", "1h", hour, hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Averages are weighted by how many samples each minute holds.
+	wantHour := utils.MetricPoint{Bucket: hour, Samples: 3, CPUAvg: 30, CPUMax: 50, MemAvg: 300, MemMax: 500, MemLimit: 2000, NetRx: 540}
+	if len(hours) != 1 || !samePoint(hours[0], wantHour) {
+		t.Errorf("hour buckets: got %+v, want %+v", hours, wantHour)
+	}
+
+	if err := store.Metrics().Prune("1m", hour.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	minutes, err = store.Metrics().List("rollup-web", "1m", hour, hour.Add(time.Hour))
+	if err != nil || len(minutes) != 1 || !minutes[0].Bucket.Equal(hour.Add(time.Minute)) {
+		t.Errorf("after pruning: got %+v, %v", minutes, err)
+	}
+}
+
+func samePoint(got, want utils.MetricPoint) bool {
+	got.ProjectSlug, got.Resolution = want.ProjectSlug, want.Resolution
+	if !got.Bucket.Equal(want.Bucket) {
+		return false
+	}
+	got.Bucket = want.Bucket
+	return got == want
+}
+
+func TestParseStats(t *testing.T) {
+	s, err := parseStats(dockerStats{
+		Name:     "web-1",
+		CPUPerc:  "12.50%",
+		MemUsage: "64MiB / 1GiB",
+		MemPerc:  "6.25%",
+		NetIO:    "1.5kB / 2MB",
+		BlockIO:  "0B / 4.1MB",
+		PIDs:     "7",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Sample{Container: "web-1", CPUPercent: 12.5, MemUsage: 64 << 20, MemLimit: 1 << 30, MemPercent: 6.25, NetRx: 1500, NetTx: 2000000, BlockWrite: 4100000, PIDs: 7}
+	if s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import "time"
+
+// Sample is one reading of a container, as reported by `docker stats`.
+type Sample struct {
+	ProjectSlug string    `json:"project_slug"`
+	Container   string    `json:"container"`
+	Timestamp   time.Time `json:"ts"`
+	CPUPercent  float64   `json:"cpu_percent"`
+	MemUsage    int64     `json:"mem_usage"`
+	MemLimit    int64     `json:"mem_limit"`
+	MemPercent  float64   `json:"mem_percent"`
+	NetRx       int64     `json:"net_rx"`
+	NetTx       int64     `json:"net_tx"`
+	BlockRead   int64     `json:"block_read"`
+	BlockWrite  int64     `json:"block_write"`
+	PIDs        int       `json:"pids"`
+}
+
+type dockerStats struct {
+	Name     string `json:"Name"`
+	CPUPerc  string `json:"CPUPerc"`
+	MemUsage string `json:"MemUsage"`
+	MemPerc  string `json:"MemPerc"`
+	NetIO    string `json:"NetIO"`
+	BlockIO  string `json:"BlockIO"`
+	PIDs     string `json:"PIDs"`
+}
+
+// minute accumulates the samples of the current one minute bucket of a project.
+type minute struct {
+	bucket     time.Time
+	samples    int
+	cpuSum     float64
+	cpuMax     float64
+	memSum     int64
+	memMax     int64
+	limit      int64
+	netRx      int64
+	netTx      int64
+	blockRead  int64
+	blockWrite int64
+}
+
+type trackedContainer struct {
+	slug string
+	name string
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetProjectMetrics(c *gin.Context) {
+	slug := c.Param("slug")
+
+	if _, err := db.StoreFrom(c).Projects().Get(slug); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Project not found!",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("project lookup query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	to := time.Now()
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "`to` must be an RFC3339 timestamp",
+				"status":  false,
+			})
+			return
+		}
+		to = t
+	}
+
+	from := to.Add(-time.Hour)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "`from` must be an RFC3339 timestamp",
+				"status":  false,
+			})
+			return
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "`from` must be before `to`",
+			"status":  false,
+		})
+		return
+	}
+
+	// Without an explicit resolution pick the finest one that keeps the series short.
+	resolution := c.Query("resolution")
+	if resolution == "" {
+		switch span := to.Sub(from); {
+		case span <= 6*time.Hour:
+			resolution = "1m"
+		case span <= 14*24*time.Hour:
+			resolution = "1h"
+		default:
+			resolution = "1d"
+		}
+	}
+
+	if err := utils.StringValidator("resolution", resolution, utils.ValidatorConfig{
+		ExpectedValues: []string{"1m", "1h", "1d"},
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	series, err := db.StoreFrom(c).Metrics().List(slug, resolution, from, to)
+	if err != nil {
+		log.Printf("metrics query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	var current *Sample
+	if s, ok := Latest(slug); ok {
+		current = &s
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"current":    current,
+			"resolution": resolution,
+			"series":     series,
+		},
+		"meta": gin.H{
+			"from": from,
+			"to":   to,
+		},
+	})
+}
@@ -0,0 +1,270 @@
+package project
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var addonVersionRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+var addonEngines = map[string]addonEngine{
+	"postgres": {
+		image:          "postgres",
+		defaultVersion: "16",
+		port:           5432,
+		dataPath:       "/var/lib/postgresql/data",
+		envVar:         "DATABASE_URL",
+		scheme:         "postgres",
+		healthCmd:      `pg_isready -h 127.0.0.1 -U "$POSTGRES_USER" -d "$POSTGRES_DB"`,
+		env: func(a utils.Addon) string {
+			return fmt.Sprintf("POSTGRES_USER=%s\nPOSTGRES_PASSWORD=%s\nPOSTGRES_DB=%s", a.Username, a.Password, a.Database)
+		},
+	},
+	"mysql": {
+		image:          "mysql",
+		defaultVersion: "8.4",
+		port:           3306,
+		dataPath:       "/var/lib/mysql",
+		envVar:         "DATABASE_URL",
+		scheme:         "mysql",
+		healthCmd:      `mysqladmin ping -h 127.0.0.1 -u "$MYSQL_USER" -p"$MYSQL_PASSWORD" --silent`,
+		env: func(a utils.Addon) string {
+			return fmt.Sprintf("MYSQL_ROOT_PASSWORD=%s\nMYSQL_USER=%s\nMYSQL_PASSWORD=%s\nMYSQL_DATABASE=%s", a.Password, a.Username, a.Password, a.Database)
+		},
+	},
+	"redis": {
+		image:          "redis",
+		defaultVersion: "7",
+		port:           6379,
+		dataPath:       "/data",
+		envVar:         "REDIS_URL",
+		scheme:         "redis",
+		healthCmd:      `redis-cli -a "$REDIS_PASSWORD" --no-auth-warning ping | grep -q PONG`,
+		env: func(a utils.Addon) string {
+			return fmt.Sprintf("REDIS_PASSWORD=%s", a.Password)
+		},
+		command: func(a utils.Addon) []string {
+			return []string{"redis-server", "--appendonly", "yes", "--requirepass", a.Password}
+		},
+	},
+}
+
+// addonURL is the connection URL linked projects receive, using the add-on's slug as
+// host name on the link's network.
+func addonURL(a utils.Addon) string {
+	engine := addonEngines[a.Engine]
+	u := url.URL{
+		Scheme: engine.scheme,
+		User:   url.UserPassword(a.Username, a.Password),
+		Host:   fmt.Sprintf("%s:%d", a.ProjectSlug, engine.port),
+		Path:   "/" + a.Database,
+	}
+	return u.String()
+}
+
+func randomSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// addonContainerArgs adds the health check to `docker create` flags and returns the
+// command to run for add-ons, or nothing for other projects.
+func addonContainerArgs(store db.Store, slug string, args []string) ([]string, []string) {
+	a, err := store.Addons().Get(slug)
+	if err != nil {
+		return args, nil
+	}
+	engine := addonEngines[a.Engine]
+
+	args = append(args,
+		"--health-cmd", engine.healthCmd,
+		"--health-interval", "2s",
+		"--health-timeout", "5s",
+		"--health-retries", "5",
+		"--health-start-period", "60s",
+	)
+
+	var command []string
+	if engine.command != nil {
+		command = engine.command(*a)
+	}
+	return args, command
+}
+
+func GetAddons(c *gin.Context) {
+	store := db.StoreFrom(c)
+
+	addons, err := store.Addons().List()
+	if err != nil {
+		log.Printf("addons query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	statuses := []AddonStatus{}
+	for _, a := range addons {
+		p, err := store.Projects().Get(a.ProjectSlug)
+		if err != nil {
+			log.Printf("addon project query error: %s", err)
+			continue
+		}
+		engine := addonEngines[a.Engine]
+		statuses = append(statuses, AddonStatus{
+			Addon:   a,
+			Name:    p.Name,
+			Status:  p.Status,
+			Host:    a.ProjectSlug,
+			Port:    engine.port,
+			EnvVar:  engine.envVar,
+			Network: projectNetwork(a.ProjectSlug),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"addons": statuses,
+		},
+	})
+}
+
+// CreateAddon provisions a database from an official image with a generated password
+// and a persistent "data" volume. The add-on is a project of type "addon", so it is
+// stopped, restarted, deleted, logged and backed up through the project endpoints.
+func CreateAddon(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, map[string]any{
+			"status":  false,
+			"message": "SSE not supported",
+		})
+		return
+	}
+	stopHeartbeat := utils.StartSSEHeartbeat(c, flusher)
+	defer stopHeartbeat()
+
+	var body CreateAddonPayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		utils.StreamSSEError(err.Error(), c, flusher)
+		return
+	}
+
+	engine, ok := addonEngines[body.Engine]
+	if !ok {
+		utils.StreamSSEError("`engine` must be one of postgres, mysql, redis", c, flusher)
+		return
+	}
+	if body.Version == "" {
+		body.Version = engine.defaultVersion
+	}
+	if !addonVersionRegex.MatchString(body.Version) {
+		utils.StreamSSEError("Invalid `version`", c, flusher)
+		return
+	}
+
+	password, err := randomSecret()
+	if err != nil {
+		utils.StreamSSEError(err.Error(), c, flusher)
+		return
+	}
+
+	slug := fmt.Sprintf("%s-%d", utils.Slugify(body.Name), time.Now().UnixMilli())
+	image := engine.image + ":" + body.Version
+	projectPath := filepath.Join("infracon-apps", slug)
+
+	addon := utils.Addon{
+		ProjectSlug: slug,
+		Engine:      body.Engine,
+		Version:     body.Version,
+		Username:    "app",
+		Password:    password,
+		Database:    "app",
+	}
+	if body.Engine == "redis" {
+		addon.Username, addon.Database = "default", "0"
+	}
+
+	addonType := "addon"
+	env := engine.env(addon)
+	project := utils.Project{
+		Name: body.Name,
+		Slug: slug,
+		Type: &addonType,
+		Env:  &env,
+	}
+	store := db.StoreFrom(c)
+
+	project.ID, err = store.Projects().Create(project)
+	if err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error saving add-on to db: %s", err)}, c, flusher)
+		return
+	}
+	if err := store.Addons().Create(addon); err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error saving add-on to db: %s", err)}, c, flusher)
+		return
+	}
+	if _, err := store.Volumes().Create(utils.ProjectVolume{
+		ProjectSlug: slug,
+		Name:        "data",
+		MountPath:   engine.dataPath,
+		DockerName:  fmt.Sprintf("infracon-%s-data", slug),
+	}); err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error saving volume to db: %s", err)}, c, flusher)
+		return
+	}
+	audit.Record(c, "addon.create", "project", slug, nil, gin.H{"engine": addon.Engine, "version": addon.Version})
+
+	deploymentId, err := store.Deployments().Create(utils.Deployment{
+		ProjectSlug:   slug,
+		Kind:          "deploy",
+		Source:        "addon",
+		ImageTag:      image,
+		ContainerName: slug,
+		Status:        "building",
+	})
+	if err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error saving deployment to db: %s", err)}, c, flusher)
+		return
+	}
+	logWriter := startBuildLog(store, slug, deploymentId)
+	defer logWriter.Close()
+
+	utils.WriteSSEData(slug, deploymentId, []string{"INFO", fmt.Sprintf("Pulling %s", image)}, c, flusher)
+	if err := utils.ExecCommandAndStreamViaSSE(slug, deploymentId, "BUILD", exec.Command("docker", "pull", image), c, flusher); err != nil {
+		failDeployment(store, slug, deploymentId, fmt.Sprintf("Error pulling %s: %s", image, err), c, flusher)
+		return
+	}
+
+	Cutover(store, Release{
+		Project:       &project,
+		DeploymentID:  deploymentId,
+		Kind:          "deploy",
+		Image:         image,
+		ContainerName: slug,
+		ProjectPath:   projectPath,
+	}, c, flusher)
+}
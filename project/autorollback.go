@@ -0,0 +1,155 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	watchersMu sync.Mutex
+	watchers   = map[string]context.CancelFunc{}
+)
+
+// WatchRelease monitors a freshly released container for AUTO_ROLLBACK_WINDOW and
+// restores previousImage through Rollback if it crash loops, exits or turns unhealthy.
+// A newer release of the same project cancels the watch.
+func WatchRelease(store db.Store, slug string, deploymentId int, image, containerName, previousImage string) {
+	window := utils.GetEnvDuration("AUTO_ROLLBACK_WINDOW", 5*time.Minute)
+	if window <= 0 {
+		return
+	}
+
+	interval := utils.GetEnvDuration("AUTO_ROLLBACK_INTERVAL", 5*time.Second)
+	maxRestarts := 3
+	if n, err := strconv.Atoi(utils.GetEnv("AUTO_ROLLBACK_MAX_RESTARTS", "3")); err == nil && n > 0 {
+		maxRestarts = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), window)
+	defer cancel()
+
+	watchersMu.Lock()
+	if previous, ok := watchers[slug]; ok {
+		previous()
+	}
+	watchers[slug] = cancel
+	watchersMu.Unlock()
+
+	defer func() {
+		watchersMu.Lock()
+		if _, ok := watchers[slug]; ok && ctx.Err() != context.Canceled {
+			delete(watchers, slug)
+		}
+		watchersMu.Unlock()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reason := releaseFailure(containerName, maxRestarts)
+		if reason == "" {
+			continue
+		}
+
+		project, err := store.Projects().Get(slug)
+		if err != nil {
+			log.Printf("auto rollback project lookup error: %s", err)
+			return
+		}
+
+		// The release was already replaced, stopped or deleted; nothing to restore.
+		if ctx.Err() != nil || project.ContainerName == nil || *project.ContainerName != containerName {
+			return
+		}
+
+		autoRollback(store, project, deploymentId, image, previousImage, reason)
+		return
+	}
+}
+
+// cancelWatch stops watching the project's release, e.g. because it is stopped on purpose.
+func cancelWatch(slug string) {
+	watchersMu.Lock()
+	if cancel, ok := watchers[slug]; ok {
+		cancel()
+		delete(watchers, slug)
+	}
+	watchersMu.Unlock()
+}
+
+func releaseFailure(containerName string, maxRestarts int) string {
+	ct, err := utils.GetDeploymentStatusetDockerContainer(containerName)
+	if err != nil {
+		return "container disappeared"
+	}
+
+	if ct.RestartCount >= maxRestarts {
+		return fmt.Sprintf("crash loop: restarted %d times", ct.RestartCount)
+	}
+
+	if ct.State.OOMKilled {
+		return "container was killed after running out of memory"
+	}
+
+	if !ct.State.Running && !ct.State.Restarting {
+		return fmt.Sprintf("container exited with code %d", ct.State.ExitCode)
+	}
+
+	if ct.State.Health != nil && ct.State.Health.Status == "unhealthy" {
+		return "container health check failed"
+	}
+
+	return ""
+}
+
+func autoRollback(store db.Store, project *utils.Project, deploymentId int, image, previousImage, reason string) {
+	slug := project.Slug
+	logWriter := startBuildLog(store, slug, deploymentId)
+	utils.WriteSSEData(slug, deploymentId, []string{"ERROR", fmt.Sprintf("Release %s failed: %s. Rolling back to %s", image, reason, previousImage)}, nil, nil)
+	finishDeployment(store, slug, deploymentId, "failed", reason, nil, nil)
+	logWriter.Close()
+
+	details := map[string]any{
+		"project":        slug,
+		"deployment_id":  deploymentId,
+		"failed_image":   image,
+		"restored_image": previousImage,
+		"reason":         reason,
+	}
+
+	err := Rollback(store, project, previousImage, "automatic rollback: "+reason, nil, nil)
+	after := gin.H{"current_image": previousImage}
+	if err != nil {
+		after = gin.H{"current_image": image, "error": err.Error()}
+	}
+	audit.RecordSystem(store, "project.rollback.auto", "project", slug, gin.H{"current_image": image, "reason": reason}, after)
+
+	if err != nil {
+		log.Printf("auto rollback of %s failed: %s", slug, err)
+		details["error"] = err.Error()
+		if err := utils.SendNotification("deployment.auto_rollback_failed", fmt.Sprintf("Release %s of %s failed (%s) and the automatic rollback to %s failed: %s", image, slug, reason, previousImage, err), details); err != nil {
+			log.Printf("notification error: %s", err)
+		}
+	} else {
+		if err := utils.SendNotification("deployment.auto_rollback", fmt.Sprintf("Release %s of %s failed (%s); rolled back to %s", image, slug, reason, previousImage), details); err != nil {
+			log.Printf("notification error: %s", err)
+		}
+	}
+
+}
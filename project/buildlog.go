@@ -0,0 +1,398 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"infracon/db"
+	"infracon/telemetry"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	buildLogBatchSize     = 100
+	buildLogFlushInterval = 500 * time.Millisecond
+	buildLogMaxPending    = 10000
+	subscriberBuffer      = 256
+)
+
+// buildLog persists the build stream of one deployment while it runs. Lines written
+// through utils.WriteSSEData with its deployment id go to it, and it numbers them and
+// tags them with the pipeline phase.
+type buildLog struct {
+	store        db.Store
+	slug         string
+	deploymentId int
+
+	mu      sync.Mutex
+	seq     int
+	phase   string
+	pending []utils.DeploymentLog
+
+	flushMu sync.Mutex
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+var (
+	buildLogsMu sync.Mutex
+	buildLogs   = map[int]*buildLog{}
+	subscribers = map[int]map[chan utils.LogRecord]struct{}{}
+)
+
+func init() {
+	telemetry.NewGaugeFunc(
+		"infracon_build_log_pending_lines",
+		"Build log lines waiting to be written, per running deployment.",
+		[]string{"project"},
+		func(emit func(float64, ...string)) {
+			buildLogsMu.Lock()
+			defer buildLogsMu.Unlock()
+			pending := map[string]int{}
+			for _, w := range buildLogs {
+				w.mu.Lock()
+				pending[w.slug] += len(w.pending)
+				w.mu.Unlock()
+			}
+			for slug, n := range pending {
+				emit(float64(n), slug)
+			}
+		},
+	)
+
+	utils.OnLog(func(r *utils.LogRecord) {
+		if r.Source != "build" || r.DeploymentID == 0 {
+			return
+		}
+
+		buildLogsMu.Lock()
+		defer buildLogsMu.Unlock()
+
+		w, ok := buildLogs[r.DeploymentID]
+		if !ok {
+			return
+		}
+		w.append(r)
+
+		// A subscriber that can't keep up is cut off; it reconnects with Last-Event-ID.
+		for ch := range subscribers[r.DeploymentID] {
+			select {
+			case ch <- *r:
+			default:
+				delete(subscribers[r.DeploymentID], ch)
+				close(ch)
+			}
+		}
+	})
+}
+
+func startBuildLog(store db.Store, slug string, deploymentId int) *buildLog {
+	seq, err := store.Logs().LastDeploymentLogSeq(deploymentId)
+	if err != nil {
+		log.Printf("build log sequence error for deployment %d: %s", deploymentId, err)
+	}
+
+	w := &buildLog{
+		store:        store,
+		slug:         slug,
+		deploymentId: deploymentId,
+		seq:          seq,
+		phase:        "prepare",
+		kick:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	buildLogsMu.Lock()
+	buildLogs[deploymentId] = w
+	buildLogsMu.Unlock()
+
+	go w.run()
+	return w
+}
+
+// Close flushes what is left and detaches the writer from the deployment.
+func (w *buildLog) Close() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *buildLog) append(r *utils.LogRecord) {
+	w.mu.Lock()
+	switch r.Kind {
+	case "BUILD":
+		w.phase = "build"
+	case "RUN":
+		w.phase = "run"
+	}
+	w.seq++
+	r.Seq = w.seq
+	r.Phase = w.phase
+	w.pending = append(w.pending, utils.DeploymentLog{
+		DeploymentID: w.deploymentId,
+		Seq:          r.Seq,
+		Kind:         r.Kind,
+		Phase:        r.Phase,
+		Message:      r.Message,
+		CreatedAt:    r.Timestamp,
+	})
+	full := len(w.pending) >= buildLogBatchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *buildLog) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(buildLogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.flush()
+		case <-w.kick:
+			w.flush()
+		case <-w.stop:
+			buildLogsMu.Lock()
+			if buildLogs[w.deploymentId] == w {
+				delete(buildLogs, w.deploymentId)
+			}
+			buildLogsMu.Unlock()
+
+			w.flush()
+			return
+		}
+	}
+}
+
+// flush writes pending lines. When it returns, every line appended before the call is
+// in the database, which is what replay relies on.
+func (w *buildLog) flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := w.store.Logs().AppendDeploymentLogs(batch); err != nil {
+		log.Printf("build log write error for deployment %d: %s", w.deploymentId, err)
+
+		// Keep the lines for the next tick unless the database has been failing for a while.
+		w.mu.Lock()
+		if len(batch)+len(w.pending) <= buildLogMaxPending {
+			w.pending = append(batch, w.pending...)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// finishDeployment records the outcome and ends the deployment's event stream.
+func finishDeployment(store db.Store, slug string, deploymentId int, status, message string, c *gin.Context, f http.Flusher) {
+	// A release that fails after going live is finished twice; only the first one counts.
+	if d, err := store.Deployments().Get(slug, deploymentId); err == nil && d.FinishedAt == nil {
+		telemetry.DeploymentsTotal.Inc(d.Kind, status)
+		telemetry.DeploymentDuration.Observe(time.Since(d.CreatedAt).Seconds(), d.Kind, status)
+	}
+
+	if err := store.Deployments().Finish(deploymentId, status, message); err != nil {
+		log.Printf("deployment %d finish error: %s", deploymentId, err)
+	}
+	utils.WriteSSEData(slug, deploymentId, []string{"DONE", status}, c, f)
+}
+
+// subscribe returns live records of a deployment, flushing its writer first so that
+// nothing falls between a replay from the database and the live feed.
+func subscribe(deploymentId int) (chan utils.LogRecord, func()) {
+	ch := make(chan utils.LogRecord, subscriberBuffer)
+
+	buildLogsMu.Lock()
+	if subscribers[deploymentId] == nil {
+		subscribers[deploymentId] = map[chan utils.LogRecord]struct{}{}
+	}
+	subscribers[deploymentId][ch] = struct{}{}
+
+	writer := buildLogs[deploymentId]
+	buildLogsMu.Unlock()
+
+	if writer != nil {
+		writer.flush()
+	}
+
+	return ch, func() {
+		buildLogsMu.Lock()
+		if _, ok := subscribers[deploymentId][ch]; ok {
+			delete(subscribers[deploymentId], ch)
+			close(ch)
+		}
+		if len(subscribers[deploymentId]) == 0 {
+			delete(subscribers, deploymentId)
+		}
+		buildLogsMu.Unlock()
+	}
+}
+
+// StreamDeploymentEvents replays a deployment's events after Last-Event-ID from the
+// persisted log and then follows it live until its done event.
+func StreamDeploymentEvents(c *gin.Context) {
+	slug := c.Param("slug")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid deployment id",
+			"status":  false,
+		})
+		return
+	}
+
+	store := db.StoreFrom(c)
+
+	deployment, err := store.Deployments().Get(slug, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Deployment not found",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("deployment query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Query("last_event_id")
+	}
+	lastSeq, err := strconv.Atoi(lastEventId)
+	if err != nil || lastSeq < 0 {
+		lastSeq = 0
+	}
+
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, map[string]any{
+			"status":  false,
+			"message": "SSE not supported",
+		})
+		return
+	}
+
+	stopHeartbeat := utils.StartSSEHeartbeat(c, flusher)
+	defer stopHeartbeat()
+
+	followDeployment(store, deployment, lastSeq, c, flusher)
+}
+
+// followDeployment streams the deployment's events after lastSeq to c, first from the
+// persisted log and then live, until its done event or until the client goes away.
+func followDeployment(store db.Store, d *utils.Deployment, lastSeq int, c *gin.Context, flusher http.Flusher) {
+	live, unsubscribe := subscribe(d.ID)
+	defer unsubscribe()
+
+	for {
+		lines, err := store.Logs().GetDeploymentLogs(d.ID, lastSeq, 1000)
+		if err != nil {
+			log.Printf("deployment logs query error: %s", err)
+			utils.StreamSSEError("Error reading deployment logs", c, flusher)
+			return
+		}
+		if len(lines) == 0 {
+			break
+		}
+
+		for _, l := range lines {
+			level := "info"
+			if l.Kind == "ERROR" {
+				level = "error"
+			}
+			r := utils.LogRecord{
+				ProjectSlug:  d.ProjectSlug,
+				Source:       "build",
+				DeploymentID: l.DeploymentID,
+				Seq:          l.Seq,
+				Timestamp:    l.CreatedAt,
+				Kind:         l.Kind,
+				Phase:        l.Phase,
+				Level:        level,
+				Message:      l.Message,
+			}
+			utils.StreamSSEEvent(utils.LogRecordEvent(r), c, flusher)
+			lastSeq = l.Seq
+			if l.Kind == "DONE" {
+				return
+			}
+		}
+	}
+
+	// Deployments finished before done events existed never get one from the log.
+	if d.FinishedAt != nil {
+		utils.StreamSSEEvent(utils.SSEEvent{
+			Type:         "done",
+			DeploymentID: d.ID,
+			Level:        "info",
+			Message:      d.Status,
+			Timestamp:    *d.FinishedAt,
+		}, c, flusher)
+		return
+	}
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case r, ok := <-live:
+			if !ok {
+				return
+			}
+			if r.Seq <= lastSeq {
+				continue
+			}
+			utils.StreamSSEEvent(utils.LogRecordEvent(r), c, flusher)
+			lastSeq = r.Seq
+			if r.Kind == "DONE" {
+				return
+			}
+		}
+	}
+}
+
+// detachDeployment runs the rest of a deployment's pipeline off the request that
+// started it and follows its events meanwhile. A client that disconnects only stops
+// following; the pipeline carries on and StreamDeploymentEvents picks the stream up again.
+func detachDeployment(store db.Store, d *utils.Deployment, w *buildLog, pipeline func(), c *gin.Context, flusher http.Flusher) {
+	go func() {
+		defer w.Close()
+		pipeline()
+	}()
+	followDeployment(store, d, 0, c, flusher)
+}
@@ -0,0 +1,235 @@
+package project
+
+import (
+	"infracon/db"
+	"infracon/db/dbtest"
+	"infracon/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDocker stands in for the docker CLI. Containers are files under
+// $FAKE_DOCKER/containers holding their state, and containers named *broken* exit as
+// soon as they start. `docker ps` prints $FAKE_DOCKER/ps. Every call is appended to
+// $FAKE_DOCKER/calls.
+const fakeDocker = `#!/bin/sh
+dir="$FAKE_DOCKER"
+echo "$*" >> "$dir/calls"
+eval "last=\${$#}"
+case "$1" in
+network)
+	case "$2" in
+	inspect) test -e "$dir/networks/$3" ;;
+	create) touch "$dir/networks/$last" ;;
+	esac
+	;;
+create)
+	while [ "$1" != --name ]; do shift; done
+	echo created > "$dir/containers/$2"
+	;;
+start)
+	test -e "$dir/containers/$2" || exit 1
+	case "$2" in
+	*broken*) echo exited > "$dir/containers/$2" ;;
+	*) echo running > "$dir/containers/$2" ;;
+	esac
+	;;
+stop)
+	test -e "$dir/containers/$2" && echo exited > "$dir/containers/$2"
+	;;
+rm)
+	rm -f "$dir/containers/$last"
+	;;
+inspect)
+	test -e "$dir/containers/$2" || exit 1
+	state=$(cat "$dir/containers/$2")
+	running=false
+	code=1
+	if [ "$state" = running ]; then
+		running=true
+		code=0
+	fi
+	printf '[{"Name":"/%s","State":{"Status":"%s","Running":%s,"ExitCode":%d,"StartedAt":"2000-01-01T00:00:00Z"}}]\n' "$2" "$state" "$running" "$code"
+	;;
+ps)
+	cat "$dir/ps" 2>/dev/null
+	;;
+image)
+	exit 1
+	;;
+esac
+exit 0
+`
+
+// installFakeDocker puts fakeDocker first on PATH and returns its state directory.
+func installFakeDocker(t *testing.T) string {
+	t.Helper()
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(fakeDocker), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, sub := range []string{"containers", "networks"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_DOCKER", dir)
+	t.Setenv("RUNTIME_LOGS_DIR", t.TempDir())
+	t.Setenv("HEALTHCHECK_STABLE_PERIOD", "0")
+	t.Setenv("AUTO_ROLLBACK_INTERVAL", "1h")
+	return dir
+}
+
+// stopWatching waits for the automatic rollback watch a release starts in the
+// background and cancels it, so it does not outlive the test.
+func stopWatching(t *testing.T, slug string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		watchersMu.Lock()
+		_, watched := watchers[slug]
+		watchersMu.Unlock()
+		if watched {
+			cancelWatch(slug)
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("release of %s is not being watched", slug)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func setContainer(t *testing.T, dir, name, state string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "containers", name), []byte(state+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func containerState(dir, name string) string {
+	state, err := os.ReadFile(filepath.Join(dir, "containers", name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(state))
+}
+
+func dockerCalls(t *testing.T, dir string) []string {
+	t.Helper()
+	calls, err := os.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(calls)), "\n")
+}
+
+// liveProject records a project whose release image is running in container.
+func liveProject(t *testing.T, store db.Store, slug, image, container string) *utils.Project {
+	t.Helper()
+	status := "running"
+	path := t.TempDir()
+	p := utils.Project{Name: slug, Slug: slug, Status: &status, ContainerName: &container, CurrentImage: &image, ProjectPath: &path}
+	var err error
+	if p.ID, err = store.Projects().Create(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Projects().Update(p); err != nil {
+		t.Fatal(err)
+	}
+	return &p
+}
+
+func newRelease(t *testing.T, store db.Store, p *utils.Project, image, container string) Release {
+	t.Helper()
+	id, err := store.Deployments().Create(utils.Deployment{ProjectSlug: p.Slug, Kind: "deploy", Source: "zip-upload", ImageTag: image, ContainerName: container, Status: "building"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Release{Project: p, DeploymentID: id, Kind: "deploy", Image: image, ContainerName: container, ProjectPath: t.TempDir()}
+}
+
+func TestCutoverSwapsInHealthyRelease(t *testing.T) {
+	dir := installFakeDocker(t)
+	store := dbtest.OpenStore(t)
+
+	liveProject(t, store, "pg", "postgres:16", "pg-1")
+	setContainer(t, dir, "pg-1", "running")
+	p := liveProject(t, store, "web", "web:1", "web-1")
+	setContainer(t, dir, "web-1", "running")
+	if err := store.Links().Create(utils.ProjectLink{ProjectSlug: "web", TargetSlug: "pg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRelease(t, store, p, "web:2", "web-2")
+	if err := Cutover(store, r, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	stopWatching(t, "web")
+
+	if containerState(dir, "web-2") != "running" || containerState(dir, "web-1") != "" {
+		t.Errorf("containers: web-1 %q, web-2 %q", containerState(dir, "web-1"), containerState(dir, "web-2"))
+	}
+	got, err := store.Projects().Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.ContainerName != "web-2" || *got.CurrentImage != "web:2" || *got.Status != "running" {
+		t.Errorf("project: container %s, image %s, status %s", *got.ContainerName, *got.CurrentImage, *got.Status)
+	}
+	d, err := store.Deployments().Get("web", r.DeploymentID)
+	if err != nil || d.Status != "running" || d.FinishedAt == nil {
+		t.Errorf("deployment: %+v, %v", d, err)
+	}
+	images, err := store.Images().List("web")
+	if err != nil || len(images) != 1 || images[0].ImageTag != "web:2" {
+		t.Errorf("retained images: %+v, %v", images, err)
+	}
+
+	// The link gets a network of its own that only web and pg join.
+	calls := strings.Join(dockerCalls(t, dir), "\n")
+	for _, want := range []string{
+		"network connect --alias pg infracon-web--pg pg-1",
+		"network connect infracon-web--pg web-2",
+	} {
+		if !strings.Contains(calls, want) {
+			t.Errorf("missing docker %s in:\n%s", want, calls)
+		}
+	}
+	if strings.Contains(calls, "infracon-pg web-2") {
+		t.Errorf("web joined pg's own network:\n%s", calls)
+	}
+}
+
+func TestCutoverKeepsLiveContainerWhenReleaseFails(t *testing.T) {
+	dir := installFakeDocker(t)
+	store := dbtest.OpenStore(t)
+
+	p := liveProject(t, store, "web", "web:1", "web-1")
+	setContainer(t, dir, "web-1", "running")
+
+	r := newRelease(t, store, p, "web:2", "web-broken-2")
+	if err := Cutover(store, r, nil, nil); err == nil {
+		t.Fatal("a release that exits on start went live")
+	}
+
+	if containerState(dir, "web-1") != "running" || containerState(dir, "web-broken-2") != "" {
+		t.Errorf("containers: web-1 %q, web-broken-2 %q", containerState(dir, "web-1"), containerState(dir, "web-broken-2"))
+	}
+	got, err := store.Projects().Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.ContainerName != "web-1" || *got.CurrentImage != "web:1" {
+		t.Errorf("project changed: container %s, image %s", *got.ContainerName, *got.CurrentImage)
+	}
+	d, err := store.Deployments().Get("web", r.DeploymentID)
+	if err != nil || d.Status != "failed" {
+		t.Errorf("deployment: %+v, %v", d, err)
+	}
+}
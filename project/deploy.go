@@ -0,0 +1,299 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/db"
+	"infracon/events"
+	"infracon/logstore"
+	"infracon/telemetry"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Release struct {
+	Project       *utils.Project
+	DeploymentID  int
+	Kind          string
+	Image         string
+	ContainerName string
+	ProjectPath   string
+	CommitSha     *string
+	Type          *string
+}
+
+// Cutover starts the release next to the live container and only swaps it in once
+// it passes WaitHealthy. A failed release is removed and the live container is left
+// untouched. Projects with volumes, add-ons among them, stop the live container first
+// and start it again if the release fails. On success r.Project is updated to reflect
+// the new release.
+func Cutover(store db.Store, r Release, c *gin.Context, f http.Flusher) error {
+	p := r.Project
+
+	var env string
+	if p.Env != nil {
+		env = *p.Env
+	}
+
+	injected, err := linkedEnv(store, p.Slug)
+	if err != nil {
+		return failRelease(store, r, fmt.Errorf("error loading linked services: %w", err), c, f)
+	}
+	envPath, err := utils.WriteEnvFile(r.ProjectPath, env, injected)
+	if err != nil {
+		return failRelease(store, r, fmt.Errorf("error writing env file: %w", err), c, f)
+	}
+
+	stopped, err := stopForCutover(store, r, c, f)
+	if err != nil {
+		return failRelease(store, r, err, c, f)
+	}
+
+	start := time.Now()
+	labels := utils.ResourceLabels{ProjectSlug: p.Slug, DeploymentID: r.DeploymentID, CommitSha: r.CommitSha}
+	status, err := RunContainer(store, p.Slug, r.Image, r.ContainerName, envPath, labels, c, f)
+	telemetry.ObservePhase("start", start, err)
+	if err == nil {
+		start = time.Now()
+		err = WaitHealthy(p.Slug, r.DeploymentID, r.ContainerName, c, f)
+		telemetry.ObservePhase("health", start, err)
+	}
+	if err != nil {
+		discardContainer(r.ContainerName)
+		restartStopped(r, stopped, c, f)
+		return failRelease(store, r, err, c, f)
+	}
+
+	oldContainer := p.ContainerName
+	oldImage := p.CurrentImage
+	update := utils.Project{
+		ID:            p.ID,
+		Status:        &status,
+		ContainerName: &r.ContainerName,
+		CurrentImage:  &r.Image,
+		ProjectPath:   &r.ProjectPath,
+		Type:          r.Type,
+	}
+
+	supersededStatus := "superseded"
+	if r.Kind == "rollback" {
+		supersededStatus = "rolled_back"
+	}
+	// The project, its retained image and the deployments it replaces change together.
+	err = store.InTx(func(tx db.Store) error {
+		if err := tx.Projects().Update(update); err != nil {
+			return err
+		}
+		if err := tx.Images().Add(utils.ProjectImage{
+			ProjectSlug:  p.Slug,
+			ImageTag:     r.Image,
+			CommitSha:    r.CommitSha,
+			DeploymentID: &r.DeploymentID,
+		}); err != nil {
+			return err
+		}
+		return tx.Deployments().Supersede(p.Slug, r.DeploymentID, supersededStatus)
+	})
+	if err != nil {
+		discardContainer(r.ContainerName)
+		restartStopped(r, stopped, c, f)
+		return failRelease(store, r, fmt.Errorf("error saving release to db: %w", err), c, f)
+	}
+
+	events.PublishStatus(p.Slug, status, fmt.Sprintf("%s %s is live", r.Kind, r.Image))
+
+	p.Status = update.Status
+	p.ContainerName = update.ContainerName
+	p.CurrentImage = update.CurrentImage
+	p.ProjectPath = update.ProjectPath
+	if r.Type != nil {
+		p.Type = r.Type
+	}
+
+	if oldContainer != nil && *oldContainer != "" && *oldContainer != r.ContainerName {
+		if err := RemoveContainer(p.Slug, r.DeploymentID, *oldContainer, c, f); err != nil {
+			utils.WriteSSEData(p.Slug, r.DeploymentID, []string{"ERROR", fmt.Sprintf("Error removing old docker container: %s", err)}, c, f)
+		}
+	}
+
+	if r.Kind == "deploy" && oldImage != nil && *oldImage != "" && *oldImage != r.Image {
+		go WatchRelease(store, p.Slug, r.DeploymentID, r.Image, r.ContainerName, *oldImage)
+	}
+
+	finishDeployment(store, p.Slug, r.DeploymentID, "running", "", c, f)
+	return nil
+}
+
+// Rollback redeploys a retained image of the project as a new "rollback" deployment.
+func Rollback(store db.Store, p *utils.Project, tag, message string, c *gin.Context, f http.Flusher) error {
+	image, err := store.Images().Get(p.Slug, tag)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = errors.New("Docker image not found")
+		}
+		utils.WriteSSEData(p.Slug, 0, []string{"ERROR", fmt.Sprintf("Error getting docker image: %s", err)}, c, f)
+		return err
+	}
+
+	if _, err := utils.GetDockerImage(tag); err != nil {
+		utils.WriteSSEData(p.Slug, 0, []string{"ERROR", "Docker image is no longer available on this host"}, c, f)
+		return err
+	}
+
+	if p.ProjectPath == nil {
+		utils.WriteSSEData(p.Slug, 0, []string{"ERROR", "Project has no source directory"}, c, f)
+		return errors.New("project has no source directory")
+	}
+
+	newContainerName := fmt.Sprintf("%s-%d", p.Slug, time.Now().UnixMilli())
+	deploymentId, err := store.Deployments().Create(utils.Deployment{
+		ProjectSlug:   p.Slug,
+		Kind:          "rollback",
+		Source:        "rollback",
+		ImageTag:      tag,
+		ContainerName: newContainerName,
+		CommitSha:     image.CommitSha,
+		PreviousImage: p.CurrentImage,
+		Status:        "deploying",
+		Message:       message,
+	})
+	if err != nil {
+		utils.WriteSSEData(p.Slug, 0, []string{"ERROR", fmt.Sprintf("Error saving deployment to db: %s", err)}, c, f)
+		return err
+	}
+	logWriter := startBuildLog(store, p.Slug, deploymentId)
+	defer logWriter.Close()
+
+	utils.WriteSSEData(p.Slug, deploymentId, []string{"INFO", fmt.Sprintf("Rolling back to %s", tag)}, c, f)
+
+	return Cutover(store, Release{
+		Project:       p,
+		DeploymentID:  deploymentId,
+		Kind:          "rollback",
+		Image:         tag,
+		ContainerName: newContainerName,
+		ProjectPath:   *p.ProjectPath,
+		CommitSha:     image.CommitSha,
+	}, c, f)
+}
+
+// Restart replaces the running container with a fresh one of the same image, e.g. to
+// apply new resource limits. It returns once the deployment is recorded; the cutover
+// continues in the background and can be followed through the deployment's events.
+func Restart(store db.Store, p *utils.Project, message string) (int, error) {
+	return restart(store, p, message, nil)
+}
+
+// restart is Restart with then, when set, called with the result of the cutover.
+func restart(store db.Store, p *utils.Project, message string, then func(error)) (int, error) {
+	if p.CurrentImage == nil || *p.CurrentImage == "" || p.ProjectPath == nil {
+		return 0, errors.New("project has no running release")
+	}
+
+	containerName := fmt.Sprintf("%s-%d", p.Slug, time.Now().UnixMilli())
+	deploymentId, err := store.Deployments().Create(utils.Deployment{
+		ProjectSlug:   p.Slug,
+		Kind:          "restart",
+		Source:        "restart",
+		ImageTag:      *p.CurrentImage,
+		ContainerName: containerName,
+		PreviousImage: p.CurrentImage,
+		Status:        "deploying",
+		Message:       message,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	image, err := store.Images().Get(p.Slug, *p.CurrentImage)
+	var commitSha *string
+	if err == nil {
+		commitSha = image.CommitSha
+	}
+
+	release := Release{
+		Project:       p,
+		DeploymentID:  deploymentId,
+		Kind:          "restart",
+		Image:         *p.CurrentImage,
+		ContainerName: containerName,
+		ProjectPath:   *p.ProjectPath,
+		CommitSha:     commitSha,
+	}
+
+	go func() {
+		logWriter := startBuildLog(store, p.Slug, deploymentId)
+		defer logWriter.Close()
+
+		utils.WriteSSEData(p.Slug, deploymentId, []string{"INFO", "Restarting: " + message}, nil, nil)
+		err := Cutover(store, release, nil, nil)
+		if err != nil {
+			log.Printf("restart of %s failed: %s", p.Slug, err)
+		}
+		if then != nil {
+			then(err)
+		}
+	}()
+
+	return deploymentId, nil
+}
+
+func failRelease(store db.Store, r Release, err error, c *gin.Context, f http.Flusher) error {
+	utils.WriteSSEData(r.Project.Slug, r.DeploymentID, []string{"ERROR", fmt.Sprintf("Release %s failed, keeping the current release: %s", r.Image, err)}, c, f)
+	finishDeployment(store, r.Project.Slug, r.DeploymentID, "failed", err.Error(), c, f)
+	return err
+}
+
+// stopForCutover stops the live container of a project with volumes before its
+// replacement starts and returns its name. Two containers on one volume can corrupt
+// it, e.g. two database servers on the same data directory, so these projects take a
+// short downtime instead.
+func stopForCutover(store db.Store, r Release, c *gin.Context, f http.Flusher) (string, error) {
+	p := r.Project
+	if p.ContainerName == nil || *p.ContainerName == "" || *p.ContainerName == r.ContainerName {
+		return "", nil
+	}
+
+	volumes, err := store.Volumes().List(p.Slug)
+	if err != nil {
+		return "", fmt.Errorf("error loading volumes: %w", err)
+	}
+	if len(volumes) == 0 {
+		return "", nil
+	}
+
+	containerName := *p.ContainerName
+	if ct, err := utils.GetDeploymentStatusetDockerContainer(containerName); err != nil || !ct.State.Running {
+		return "", nil
+	}
+
+	utils.WriteSSEData(p.Slug, r.DeploymentID, []string{"INFO", fmt.Sprintf("Stopping %s, which uses the same volumes", containerName)}, c, f)
+	if err := utils.ExecCommandAndStreamViaSSE(p.Slug, r.DeploymentID, "RUN", exec.Command("docker", "stop", containerName), c, f); err != nil {
+		return "", fmt.Errorf("could not stop %s: %w", containerName, err)
+	}
+	return containerName, nil
+}
+
+// restartStopped starts the container stopForCutover stopped again after the release failed.
+func restartStopped(r Release, containerName string, c *gin.Context, f http.Flusher) {
+	if containerName == "" {
+		return
+	}
+
+	utils.WriteSSEData(r.Project.Slug, r.DeploymentID, []string{"INFO", fmt.Sprintf("Starting %s again", containerName)}, c, f)
+	if err := utils.ExecCommandAndStreamViaSSE(r.Project.Slug, r.DeploymentID, "RUN", exec.Command("docker", "start", containerName), c, f); err != nil {
+		utils.WriteSSEData(r.Project.Slug, r.DeploymentID, []string{"ERROR", fmt.Sprintf("Could not start %s again: %s", containerName, err)}, c, f)
+		return
+	}
+	logstore.Follow(r.Project.Slug, containerName)
+}
+
+func discardContainer(containerName string) {
+	exec.Command("docker", "rm", "-f", containerName).Run()
+}
@@ -0,0 +1,218 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/db"
+	"infracon/disk"
+	"infracon/utils"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	gcMu           sync.Mutex
+	lastGCReportMu sync.Mutex
+	lastGCReport   *utils.GCReport
+)
+
+func DefaultImageRetentionPolicy(slug string) utils.ImageRetentionPolicy {
+	policy := utils.ImageRetentionPolicy{
+		ProjectSlug: slug,
+		KeepLast:    5,
+		KeepDays:    7,
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("IMAGE_RETENTION_KEEP_LAST")); err == nil && n > 0 {
+		policy.KeepLast = n
+	}
+	if days, err := strconv.Atoi(os.Getenv("IMAGE_RETENTION_KEEP_DAYS")); err == nil && days >= 0 {
+		policy.KeepDays = days
+	}
+
+	return policy
+}
+
+func ImageRetentionPolicy(store db.Store, slug string) (utils.ImageRetentionPolicy, error) {
+	policy, err := store.Images().GetRetentionPolicy(slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultImageRetentionPolicy(slug), nil
+	}
+	if err != nil {
+		return utils.ImageRetentionPolicy{}, err
+	}
+	return *policy, nil
+}
+
+// RunImageGC collects garbage every IMAGE_GC_INTERVAL.
+func RunImageGC(store db.Store) {
+	interval := utils.GetEnvDuration("IMAGE_GC_INTERVAL", 6*time.Hour)
+	if interval <= 0 {
+		return
+	}
+
+	for {
+		time.Sleep(interval)
+		if _, err := CollectGarbage(store); err != nil {
+			log.Printf("image gc error: %s", err)
+		}
+	}
+}
+
+// CollectGarbage removes images outside each project's retention policy, source
+// directories of replaced releases and build cache older than BUILD_CACHE_MAX_AGE.
+// The current image and pinned images are always kept, and projects with a
+// deployment in progress are skipped.
+func CollectGarbage(store db.Store) (*utils.GCReport, error) {
+	gcMu.Lock()
+	defer gcMu.Unlock()
+
+	report := &utils.GCReport{
+		StartedAt:     time.Now(),
+		RemovedImages: []string{},
+		RemovedDirs:   []string{},
+		Errors:        []string{},
+	}
+
+	projects, err := store.Projects().List()
+	if err != nil {
+		return nil, err
+	}
+
+	// Removing an image frees only the layers no other image shares, so what was
+	// reclaimed is measured rather than summed from image sizes.
+	imagesBefore, sizeErr := disk.ImagesBytes()
+
+	for i := range projects {
+		p := &projects[i]
+		if isBusy(p.Slug) {
+			continue
+		}
+		if err := collectImages(store, p, report); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", p.Slug, err))
+		}
+		collectSourceDirs(p, report)
+	}
+
+	if sizeErr == nil && len(report.RemovedImages) > 0 {
+		var imagesAfter int64
+		imagesAfter, sizeErr = disk.ImagesBytes()
+		if sizeErr == nil && imagesAfter < imagesBefore {
+			report.ReclaimedBytes += imagesBefore - imagesAfter
+		}
+	}
+	if sizeErr != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("could not measure reclaimed image space: %s", sizeErr))
+	}
+
+	pruneBuildCache(report)
+
+	report.FinishedAt = time.Now()
+
+	lastGCReportMu.Lock()
+	lastGCReport = report
+	lastGCReportMu.Unlock()
+
+	log.Printf("image gc: removed %d images and %d directories, reclaimed %d bytes and %d bytes of build cache", len(report.RemovedImages), len(report.RemovedDirs), report.ReclaimedBytes, report.BuildCacheBytes)
+	for _, e := range report.Errors {
+		log.Printf("image gc: %s", e)
+	}
+
+	return report, nil
+}
+
+func collectImages(store db.Store, p *utils.Project, report *utils.GCReport) error {
+	policy, err := ImageRetentionPolicy(store, p.Slug)
+	if err != nil {
+		return err
+	}
+	pinned, err := store.Images().Pinned(p.Slug)
+	if err != nil {
+		return err
+	}
+	images, err := store.Images().List(p.Slug)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -policy.KeepDays)
+
+	// Images come newest first.
+	for i, img := range images {
+		current := p.CurrentImage != nil && *p.CurrentImage == img.ImageTag
+		if current || pinned[img.ImageTag] || i < policy.KeepLast || img.CreatedAt.After(cutoff) {
+			continue
+		}
+
+		if _, err := utils.GetDockerImage(img.ImageTag); err == nil {
+			// Without -f docker refuses to remove images still used by a container.
+			if output, err := exec.Command("docker", "rmi", img.ImageTag).CombinedOutput(); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: could not remove image %s: %s", p.Slug, img.ImageTag, strings.TrimSpace(string(output))))
+				continue
+			}
+		}
+
+		if err := store.Images().Delete(p.Slug, img.ImageTag); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: could not forget image %s: %s", p.Slug, img.ImageTag, err))
+			continue
+		}
+
+		report.RemovedImages = append(report.RemovedImages, img.ImageTag)
+	}
+
+	return nil
+}
+
+// collectSourceDirs removes the source directories of releases that were replaced.
+// Rollbacks only need images, so only the current release's directory is kept.
+func collectSourceDirs(p *utils.Project, report *utils.GCReport) {
+	if p.ProjectPath == nil || *p.ProjectPath == "" {
+		return
+	}
+
+	rel, err := filepath.Rel("infracon-apps", *p.ProjectPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return
+	}
+	current := strings.Split(filepath.ToSlash(rel), "/")[0]
+
+	for _, dir := range utils.ProjectSourceDirs(p.Slug) {
+		if filepath.Base(dir) == current {
+			continue
+		}
+
+		size := utils.DirSize(dir)
+		if err := os.RemoveAll(dir); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: could not remove %s: %s", p.Slug, dir, err))
+			continue
+		}
+		report.RemovedDirs = append(report.RemovedDirs, dir)
+		report.ReclaimedBytes += size
+	}
+}
+
+func pruneBuildCache(report *utils.GCReport) {
+	maxAge := utils.GetEnvDuration("BUILD_CACHE_MAX_AGE", 24*time.Hour)
+
+	output, err := exec.Command("docker", "builder", "prune", "-f", "--filter", "until="+maxAge.String()).CombinedOutput()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("could not prune build cache: %s", strings.TrimSpace(string(output))))
+		return
+	}
+
+	// The last line reads e.g. "Total:\t1.2GB" or "Total reclaimed space: 0B".
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	last := lines[len(lines)-1]
+	if i := strings.LastIndex(last, ":"); i >= 0 && strings.HasPrefix(last, "Total") {
+		if size, err := utils.ParseDockerSize(last[i+1:]); err == nil {
+			report.BuildCacheBytes = size
+		}
+	}
+}
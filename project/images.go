@@ -0,0 +1,207 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetImageRetention(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	policy, err := ImageRetentionPolicy(db.StoreFrom(c), project.Slug)
+	if err != nil {
+		log.Printf("image retention policy query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"policy": policy,
+		},
+	})
+}
+
+func SetImageRetention(c *gin.Context) {
+	var body SetImageRetentionPayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid payload",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	before, _ := ImageRetentionPolicy(db.StoreFrom(c), project.Slug)
+	policy := utils.ImageRetentionPolicy{
+		ProjectSlug: project.Slug,
+		KeepLast:    body.KeepLast,
+		KeepDays:    body.KeepDays,
+	}
+
+	if err := db.StoreFrom(c).Images().SetRetentionPolicy(policy); err != nil {
+		log.Printf("image retention policy update error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.images.retention.update", "project", project.Slug, before, policy)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Retention policy updated",
+		"data": gin.H{
+			"policy": policy,
+		},
+	})
+}
+
+// PinImage protects a retained image from garbage collection so it stays available
+// as a rollback target.
+func PinImage(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	tag := c.Param("tag")
+	if _, err := db.StoreFrom(c).Images().Get(project.Slug, tag); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Docker image not found",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("docker image query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	if err := db.StoreFrom(c).Images().Pin(project.Slug, tag); err != nil {
+		log.Printf("pin image error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.image.pin", "project", project.Slug, nil, gin.H{"image_tag": tag})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Image pinned",
+		"status":  true,
+	})
+}
+
+func UnpinImage(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	tag := c.Param("tag")
+	if err := db.StoreFrom(c).Images().Unpin(project.Slug, tag); err != nil {
+		log.Printf("unpin image error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.image.unpin", "project", project.Slug, gin.H{"image_tag": tag}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Image unpinned",
+		"status":  true,
+	})
+}
+
+func GetGCReport(c *gin.Context) {
+	lastGCReportMu.Lock()
+	report := lastGCReport
+	lastGCReportMu.Unlock()
+
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Garbage collection has not run yet",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data":   report,
+	})
+}
+
+func RunGC(c *gin.Context) {
+	report, err := CollectGarbage(db.StoreFrom(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	audit.Record(c, "system.gc", "system", "gc", nil, gin.H{
+		"removed_images":    report.RemovedImages,
+		"removed_dirs":      report.RemovedDirs,
+		"reclaimed_bytes":   report.ReclaimedBytes,
+		"build_cache_bytes": report.BuildCacheBytes,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Garbage collection finished",
+		"status":  true,
+		"data":    report,
+	})
+}
+
+func lookupProject(c *gin.Context) (*utils.Project, bool) {
+	project, err := db.StoreFrom(c).Projects().Get(c.Param("slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Project not found!",
+				"status":  false,
+			})
+			return nil, false
+		}
+		log.Printf("project lookup query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return nil, false
+	}
+
+	return project, true
+}
@@ -0,0 +1,359 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/drain"
+	"infracon/events"
+	"infracon/logstore"
+	"infracon/utils"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	maintenanceMu sync.Mutex
+	maintenance   = map[string]bool{}
+)
+
+// openLifecycleStream starts an SSE response and loads the project named in the path.
+func openLifecycleStream(c *gin.Context) (*utils.Project, http.Flusher, func(), bool) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, map[string]any{
+			"status":  false,
+			"message": "SSE not supported",
+		})
+		return nil, nil, nil, false
+	}
+
+	stopHeartbeat := utils.StartSSEHeartbeat(c, flusher)
+
+	project, err := db.StoreFrom(c).Projects().Get(c.Param("slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.StreamSSEError("Project not found", c, flusher)
+		} else {
+			utils.StreamSSEError(err.Error(), c, flusher)
+		}
+		stopHeartbeat()
+		return nil, nil, nil, false
+	}
+
+	return project, flusher, stopHeartbeat, true
+}
+
+func StopProject(c *gin.Context) {
+	project, flusher, stopHeartbeat, ok := openLifecycleStream(c)
+	if !ok {
+		return
+	}
+	defer stopHeartbeat()
+
+	if project.ContainerName == nil || *project.ContainerName == "" {
+		utils.StreamSSEError("Project has no container", c, flusher)
+		return
+	}
+
+	end, err := beginMaintenance(project.Slug)
+	if err != nil {
+		utils.StreamSSEError(err.Error(), c, flusher)
+		return
+	}
+	defer end()
+
+	cancelWatch(project.Slug)
+	before := audit.ProjectSummary(project)
+
+	utils.WriteSSEData(project.Slug, 0, []string{"INFO", fmt.Sprintf("Stopping %s", *project.ContainerName)}, c, flusher)
+	if err := utils.ExecCommandAndStreamViaSSE(project.Slug, 0, "RUN", exec.Command("docker", "stop", *project.ContainerName), c, flusher); err != nil {
+		utils.WriteSSEData(project.Slug, 0, []string{"ERROR", fmt.Sprintf("Error stopping container: %s", err)}, c, flusher)
+		audit.Record(c, "project.stop", "project", project.Slug, before, gin.H{"error": err.Error()})
+		return
+	}
+
+	setProjectStatus(db.StoreFrom(c), project, "stopped", c, flusher)
+	audit.Record(c, "project.stop", "project", project.Slug, before, audit.ProjectSummary(project))
+	utils.WriteSSEData(project.Slug, 0, []string{"DONE", "stopped"}, c, flusher)
+}
+
+func StartProject(c *gin.Context) {
+	project, flusher, stopHeartbeat, ok := openLifecycleStream(c)
+	if !ok {
+		return
+	}
+	defer stopHeartbeat()
+
+	runProjectContainer(db.StoreFrom(c), project, "start", c, flusher)
+}
+
+func RestartProject(c *gin.Context) {
+	project, flusher, stopHeartbeat, ok := openLifecycleStream(c)
+	if !ok {
+		return
+	}
+	defer stopHeartbeat()
+
+	runProjectContainer(db.StoreFrom(c), project, "restart", c, flusher)
+}
+
+// runProjectContainer starts or restarts the project's existing container in place and
+// waits for it to become healthy.
+func runProjectContainer(store db.Store, project *utils.Project, action string, c *gin.Context, f http.Flusher) {
+	if project.ContainerName == nil || *project.ContainerName == "" {
+		utils.StreamSSEError("Project has no container", c, f)
+		return
+	}
+
+	end, err := beginMaintenance(project.Slug)
+	if err != nil {
+		utils.StreamSSEError(err.Error(), c, f)
+		return
+	}
+	defer end()
+
+	before := audit.ProjectSummary(project)
+	containerName := *project.ContainerName
+
+	utils.WriteSSEData(project.Slug, 0, []string{"INFO", fmt.Sprintf("Running docker %s on %s", action, containerName)}, c, f)
+	err = utils.ExecCommandAndStreamViaSSE(project.Slug, 0, "RUN", exec.Command("docker", action, containerName), c, f)
+	if err == nil {
+		err = WaitHealthy(project.Slug, 0, containerName, c, f)
+	}
+	if err != nil {
+		utils.WriteSSEData(project.Slug, 0, []string{"ERROR", fmt.Sprintf("Error during %s: %s", action, err)}, c, f)
+		setProjectStatus(store, project, "failed", c, f)
+		after := audit.ProjectSummary(project)
+		after["error"] = err.Error()
+		audit.Record(c, "project."+action, "project", project.Slug, before, after)
+		utils.WriteSSEData(project.Slug, 0, []string{"DONE", "failed"}, c, f)
+		return
+	}
+
+	logstore.Follow(project.Slug, containerName)
+	setProjectStatus(store, project, "running", c, f)
+	audit.Record(c, "project."+action, "project", project.Slug, before, audit.ProjectSummary(project))
+	utils.WriteSSEData(project.Slug, 0, []string{"DONE", "running"}, c, f)
+}
+
+func setProjectStatus(store db.Store, project *utils.Project, status string, c *gin.Context, f http.Flusher) {
+	if err := store.Projects().Update(utils.Project{ID: project.ID, Status: &status}); err != nil {
+		utils.WriteSSEData(project.Slug, 0, []string{"ERROR", fmt.Sprintf("Error saving project status: %s", err)}, c, f)
+		return
+	}
+	project.Status = &status
+	events.PublishStatus(project.Slug, status, "")
+}
+
+// beginMaintenance marks the project as maintained, so the reconciler, the garbage
+// collector and other lifecycle actions leave it alone, and returns the func that ends
+// it. It fails while the project is being deployed or maintained already.
+func beginMaintenance(slug string) (func(), error) {
+	if isBusy(slug) {
+		return nil, fmt.Errorf("%s is being deployed or maintained", slug)
+	}
+
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	if maintenance[slug] {
+		return nil, fmt.Errorf("%s is being maintained", slug)
+	}
+	maintenance[slug] = true
+
+	return func() {
+		maintenanceMu.Lock()
+		delete(maintenance, slug)
+		maintenanceMu.Unlock()
+	}, nil
+}
+
+// WhileStopped stops the project's running container, calls fn and starts the
+// container again, e.g. so volume data can be replaced consistently. The reconciler
+// and garbage collector leave the project alone in the meantime.
+func WhileStopped(store db.Store, project *utils.Project, fn func() error, c *gin.Context, f http.Flusher) error {
+	end, err := beginMaintenance(project.Slug)
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	cancelWatch(project.Slug)
+
+	containerName := ""
+	if project.ContainerName != nil && *project.ContainerName != "" {
+		if ct, err := utils.GetDeploymentStatusetDockerContainer(*project.ContainerName); err == nil && ct.State.Running {
+			containerName = *project.ContainerName
+		}
+	}
+
+	if containerName != "" {
+		utils.WriteSSEData(project.Slug, 0, []string{"INFO", fmt.Sprintf("Stopping %s", containerName)}, c, f)
+		if err := utils.ExecCommandAndStreamViaSSE(project.Slug, 0, "RUN", exec.Command("docker", "stop", containerName), c, f); err != nil {
+			return fmt.Errorf("could not stop %s: %w", containerName, err)
+		}
+		setProjectStatus(store, project, "stopped", c, f)
+	}
+
+	err = fn()
+
+	if containerName != "" {
+		utils.WriteSSEData(project.Slug, 0, []string{"INFO", fmt.Sprintf("Starting %s", containerName)}, c, f)
+		startErr := utils.ExecCommandAndStreamViaSSE(project.Slug, 0, "RUN", exec.Command("docker", "start", containerName), c, f)
+		if startErr == nil {
+			startErr = WaitHealthy(project.Slug, 0, containerName, c, f)
+		}
+		if startErr != nil {
+			setProjectStatus(store, project, "failed", c, f)
+			return errors.Join(err, fmt.Errorf("could not start %s: %w", containerName, startErr))
+		}
+		logstore.Follow(project.Slug, containerName)
+		setProjectStatus(store, project, "running", c, f)
+	}
+
+	return err
+}
+
+// DeleteProject removes the project's containers, source directories, logs and records.
+// With ?purge_images=true its images and rollback targets go as well. Volumes and their
+// records are kept unless ?purge_volumes=true; snapshots stay in their backup targets
+// and stay on record.
+func DeleteProject(c *gin.Context) {
+	project, flusher, stopHeartbeat, ok := openLifecycleStream(c)
+	if !ok {
+		return
+	}
+	defer stopHeartbeat()
+
+	slug := project.Slug
+	purgeImages := c.Query("purge_images") == "true"
+	purgeVolumes := c.Query("purge_volumes") == "true"
+	before := audit.ProjectSummary(project)
+
+	store := db.StoreFrom(c)
+
+	linkedFrom, err := store.Links().Dependents(slug)
+	if err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error listing linked projects: %s", err)}, c, flusher)
+		return
+	}
+	if len(linkedFrom) > 0 {
+		var names []string
+		for _, l := range linkedFrom {
+			names = append(names, l.ProjectSlug)
+		}
+		utils.StreamSSEError(fmt.Sprintf("Project is still linked from %s, unlink it first", strings.Join(names, ", ")), c, flusher)
+		return
+	}
+	addon := project.Type != nil && *project.Type == "addon"
+
+	cancelWatch(slug)
+
+	containers, err := projectContainers(project)
+	if err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error listing containers: %s", err)}, c, flusher)
+		return
+	}
+	for _, name := range containers {
+		if err := RemoveContainer(slug, 0, name, c, flusher); err != nil {
+			utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error removing container %s: %s", name, err)}, c, flusher)
+			return
+		}
+	}
+
+	utils.WriteSSEData(slug, 0, []string{"INFO", fmt.Sprintf("Removing network %s", projectNetwork(slug))}, c, flusher)
+	if err := removeNetwork(projectNetwork(slug)); err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error removing network %s: %s", projectNetwork(slug), err)}, c, flusher)
+	}
+	links, err := store.Links().List(slug)
+	if err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error listing links: %s", err)}, c, flusher)
+		return
+	}
+	for _, l := range links {
+		name := linkNetwork(l.ProjectSlug, l.TargetSlug)
+		utils.WriteSSEData(slug, 0, []string{"INFO", fmt.Sprintf("Removing network %s", name)}, c, flusher)
+		if err := removeLinkNetwork(store, l); err != nil {
+			utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error removing network %s: %s", name, err)}, c, flusher)
+		}
+	}
+
+	// Add-ons run shared official images, which other add-ons may still use.
+	if purgeImages && !addon {
+		images, err := store.Images().List(slug)
+		if err != nil {
+			utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error listing images: %s", err)}, c, flusher)
+			return
+		}
+		for _, image := range images {
+			utils.WriteSSEData(slug, 0, []string{"INFO", fmt.Sprintf("Removing image %s", image.ImageTag)}, c, flusher)
+			if err := utils.ExecCommandAndStreamViaSSE(slug, 0, "RUN", exec.Command("docker", "rmi", "-f", image.ImageTag), c, flusher); err != nil {
+				utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error removing image %s: %s", image.ImageTag, err)}, c, flusher)
+			}
+		}
+	}
+
+	if purgeVolumes {
+		volumes, err := store.Volumes().List(slug)
+		if err != nil {
+			utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error listing volumes: %s", err)}, c, flusher)
+			return
+		}
+		for _, v := range volumes {
+			utils.WriteSSEData(slug, 0, []string{"INFO", fmt.Sprintf("Removing volume %s", v.DockerName)}, c, flusher)
+			if err := removeVolume(v.DockerName); err != nil {
+				utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error removing volume %s: %s", v.DockerName, err)}, c, flusher)
+			}
+		}
+	}
+
+	for _, dir := range utils.ProjectSourceDirs(slug) {
+		utils.WriteSSEData(slug, 0, []string{"INFO", fmt.Sprintf("Removing %s", dir)}, c, flusher)
+		if err := os.RemoveAll(dir); err != nil {
+			utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error removing %s: %s", dir, err)}, c, flusher)
+		}
+	}
+
+	drain.UnregisterProject(slug)
+	if err := logstore.Remove(slug); err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error removing runtime logs: %s", err)}, c, flusher)
+	}
+
+	if err := store.Projects().Delete(slug, purgeImages, purgeVolumes); err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error deleting project from db: %s", err)}, c, flusher)
+		return
+	}
+
+	audit.Record(c, "project.delete", "project", slug, before, gin.H{"purge_images": purgeImages, "purge_volumes": purgeVolumes})
+	events.PublishStatus(slug, "deleted", "")
+	utils.WriteSSEData(slug, 0, []string{"DONE", "deleted"}, c, flusher)
+}
+
+// projectContainers lists every container created for the project, running or not,
+// plus its current container in case that predates labelling.
+func projectContainers(p *utils.Project) ([]string, error) {
+	output, err := exec.Command("docker", "ps", "-a", "--format", "{{.Names}}", "--filter", "label="+utils.ProjectLabel+"="+p.Slug).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	names := strings.Fields(string(output))
+	if p.ContainerName != nil && *p.ContainerName != "" && !utils.Contains(names, *p.ContainerName) {
+		if _, err := utils.GetDeploymentStatusetDockerContainer(*p.ContainerName); err == nil {
+			names = append(names, *p.ContainerName)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,236 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+var envVarRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func GetLinks(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	links, err := db.StoreFrom(c).Links().List(project.Slug)
+	if err != nil {
+		log.Printf("project links query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	linkedFrom, err := db.StoreFrom(c).Links().Dependents(project.Slug)
+	if err != nil {
+		log.Printf("project links query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"links":       links,
+			"linked_from": linkedFrom,
+		},
+	})
+}
+
+// CreateLink makes the project depend on another project or add-on: the two share a
+// network of their own, on which the project reaches the target under its slug, and
+// unless inject_env is false the project receives its URL as env_var (DATABASE_URL,
+// REDIS_URL or <NAME>_URL by default). A running project is restarted to pick the link up.
+func CreateLink(c *gin.Context) {
+	var body CreateLinkPayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid payload",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	if body.Target == project.Slug {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "A project can't link to itself",
+			"status":  false,
+		})
+		return
+	}
+
+	target, err := db.StoreFrom(c).Projects().Get(body.Target)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Target project not found",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("project query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	if body.InjectEnv != nil && !*body.InjectEnv {
+		body.EnvVar = ""
+	} else {
+		if body.EnvVar == "" {
+			body.EnvVar = linkEnvVar(db.StoreFrom(c), target)
+		}
+		if !envVarRegex.MatchString(body.EnvVar) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "`env_var` must be a valid environment variable name",
+				"status":  false,
+			})
+			return
+		}
+	}
+
+	existing, err := db.StoreFrom(c).Links().List(project.Slug)
+	if err != nil {
+		log.Printf("project links query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	for _, l := range existing {
+		if l.EnvVar == body.EnvVar && l.TargetSlug != body.Target {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": fmt.Sprintf("%s is already injected for %s, pick another `env_var`", body.EnvVar, l.TargetSlug),
+				"status":  false,
+			})
+			return
+		}
+	}
+
+	link := utils.ProjectLink{ProjectSlug: project.Slug, TargetSlug: body.Target, EnvVar: body.EnvVar}
+	if err := db.StoreFrom(c).Links().Create(link); err != nil {
+		log.Printf("project link insert error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.link.create", "project", project.Slug, nil, link)
+
+	restartForLink(c, project, link, fmt.Sprintf("linked to %s", link.TargetSlug), "Link created", http.StatusCreated, nil)
+}
+
+// DeleteLink removes a link. A running project is restarted without the link's
+// network and variable, and the network is removed.
+func DeleteLink(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	var link *utils.ProjectLink
+	links, err := db.StoreFrom(c).Links().List(project.Slug)
+	if err != nil {
+		log.Printf("project links query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	for i := range links {
+		if links[i].TargetSlug == c.Param("target") {
+			link = &links[i]
+		}
+	}
+	if link == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Link not found",
+			"status":  false,
+		})
+		return
+	}
+
+	if err := db.StoreFrom(c).Links().Delete(project.Slug, link.TargetSlug); err != nil {
+		log.Printf("project link delete error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.link.delete", "project", project.Slug, link, nil)
+
+	// The link's network goes once the project's container has left it.
+	store := db.StoreFrom(c)
+	removed := *link
+	restartForLink(c, project, removed, fmt.Sprintf("unlinked from %s", link.TargetSlug), "Link deleted", http.StatusOK, func(err error) {
+		if err != nil {
+			return
+		}
+		if err := removeLinkNetwork(store, removed); err != nil {
+			log.Printf("could not remove network %s: %s", linkNetwork(removed.ProjectSlug, removed.TargetSlug), err)
+		}
+	})
+}
+
+// restartForLink answers with status when the project has no container yet, and
+// otherwise restarts it so the change takes effect. then, when set, is called once
+// the change has taken effect or failed to.
+func restartForLink(c *gin.Context, project *utils.Project, link utils.ProjectLink, reason, message string, status int, then func(error)) {
+	if project.ContainerName == nil || *project.ContainerName == "" {
+		if then != nil {
+			then(nil)
+		}
+		c.JSON(status, gin.H{
+			"status":  true,
+			"message": message + ", it applies from the next deploy",
+			"data": gin.H{
+				"link": link,
+			},
+		})
+		return
+	}
+
+	deploymentId, err := restart(db.StoreFrom(c), project, reason, then)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": message + ", but the project could not be restarted: " + err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  true,
+		"message": message + ", restarting project",
+		"data": gin.H{
+			"link":          link,
+			"deployment_id": deploymentId,
+		},
+	})
+}
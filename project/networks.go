@@ -0,0 +1,161 @@
+package project
+
+import (
+	"fmt"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var envNameRegex = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// projectNetwork is the private network a project runs on, reachable there under its
+// slug.
+func projectNetwork(slug string) string {
+	return "infracon-" + slug
+}
+
+// linkNetwork is the network of one link. Only the source and the target join it, so a
+// service's dependents can reach the service but not each other.
+func linkNetwork(source, target string) string {
+	return "infracon-" + source + "--" + target
+}
+
+// linkEnvVar is the variable a link to target is injected as by default: the add-on's
+// DATABASE_URL or REDIS_URL, or <NAME>_URL for projects.
+func linkEnvVar(store db.Store, target *utils.Project) string {
+	if a, err := store.Addons().Get(target.Slug); err == nil {
+		return addonEngines[a.Engine].envVar
+	}
+	name := strings.Trim(envNameRegex.ReplaceAllString(strings.ToUpper(target.Name), "_"), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "SERVICE_" + name
+	}
+	return name + "_URL"
+}
+
+// ensureNetwork creates the project's network. Add-on networks are internal: they have
+// no route out of the host.
+func ensureNetwork(slug string, deploymentId int, name string, internal bool, c *gin.Context, f http.Flusher) error {
+	if err := exec.Command("docker", "network", "inspect", name).Run(); err == nil {
+		return nil
+	}
+
+	utils.WriteSSEData(slug, deploymentId, []string{"INFO", fmt.Sprintf("Creating network %s", name)}, c, f)
+	args := []string{"network", "create", "--driver", "bridge"}
+	if internal {
+		args = append(args, "--internal")
+	}
+	args = append(args, utils.DockerLabelArgs(utils.ResourceLabels{ProjectSlug: slug})...)
+	args = append(args, name)
+	if err := utils.ExecCommandAndStreamViaSSE(slug, deploymentId, "RUN", exec.Command("docker", args...), c, f); err != nil {
+		return fmt.Errorf("could not create network %s: %w", name, err)
+	}
+	return nil
+}
+
+func removeNetwork(name string) error {
+	output, err := exec.Command("docker", "network", "rm", name).CombinedOutput()
+	if err != nil && !strings.Contains(string(output), "not found") {
+		return fmt.Errorf("%s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// attachToNetwork connects a project's live container to a link network under its
+// slug, so a source deployed after the link was made reaches a target that is already
+// running. It is a no-op once connected.
+func attachToNetwork(p *utils.Project, network string) {
+	if p.ContainerName == nil || *p.ContainerName == "" {
+		return
+	}
+	output, err := exec.Command("docker", "network", "connect", "--alias", p.Slug, network, *p.ContainerName).CombinedOutput()
+	if err != nil && !strings.Contains(string(output), "already exists") {
+		log.Printf("could not connect %s to %s: %s", *p.ContainerName, network, strings.TrimSpace(string(output)))
+	}
+}
+
+// removeLinkNetwork drops the network of a link that is gone. The source must have
+// left it already; the target is disconnected here.
+func removeLinkNetwork(store db.Store, l utils.ProjectLink) error {
+	name := linkNetwork(l.ProjectSlug, l.TargetSlug)
+	if target, err := store.Projects().Get(l.TargetSlug); err == nil && target.ContainerName != nil && *target.ContainerName != "" {
+		exec.Command("docker", "network", "disconnect", "--force", name, *target.ContainerName).Run()
+	}
+	return removeNetwork(name)
+}
+
+// containerNetworks decides where a project's container is attached: its own network,
+// reachable there under its slug, plus the network of every link it is the source or
+// the target of. Targets are reachable on a link network under their slug. Containers
+// no longer use the default bridge, so unrelated projects can't reach each other.
+func containerNetworks(store db.Store, slug string, deploymentId int, c *gin.Context, f http.Flusher) (*containerNetwork, []containerNetwork, error) {
+	_, err := store.Addons().Get(slug)
+	addon := err == nil
+	primary := &containerNetwork{name: projectNetwork(slug), aliases: []string{slug}}
+	if err := ensureNetwork(slug, deploymentId, primary.name, addon, c, f); err != nil {
+		return nil, nil, err
+	}
+
+	links, err := store.Links().List(slug)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var extra []containerNetwork
+	for _, l := range links {
+		target, err := store.Projects().Get(l.TargetSlug)
+		if err != nil {
+			return nil, nil, fmt.Errorf("linked service %s: %w", l.TargetSlug, err)
+		}
+		_, err = store.Addons().Get(target.Slug)
+		network := containerNetwork{name: linkNetwork(slug, target.Slug)}
+		if err := ensureNetwork(slug, deploymentId, network.name, err == nil, c, f); err != nil {
+			return nil, nil, err
+		}
+		attachToNetwork(target, network.name)
+		extra = append(extra, network)
+	}
+
+	dependents, err := store.Links().Dependents(slug)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, l := range dependents {
+		network := containerNetwork{name: linkNetwork(l.ProjectSlug, slug), aliases: []string{slug}}
+		if err := ensureNetwork(slug, deploymentId, network.name, addon, c, f); err != nil {
+			return nil, nil, err
+		}
+		extra = append(extra, network)
+	}
+
+	return primary, extra, nil
+}
+
+// linkedEnv returns the URLs of the services the project links to, keyed by the
+// variable each is injected as. Links without a variable only share the network.
+func linkedEnv(store db.Store, slug string) (map[string]string, error) {
+	links, err := store.Links().List(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	env := map[string]string{}
+	for _, l := range links {
+		if l.EnvVar == "" {
+			continue
+		}
+		if a, err := store.Addons().Get(l.TargetSlug); err == nil {
+			env[l.EnvVar] = addonURL(*a)
+			continue
+		}
+		env[l.EnvVar] = fmt.Sprintf("http://%s:%d", l.TargetSlug, utils.AppPort)
+	}
+	return env, nil
+}
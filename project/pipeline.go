@@ -3,29 +3,40 @@ package project
 import (
 	"errors"
 	"fmt"
+	"infracon/db"
+	"infracon/disk"
+	"infracon/logstore"
+	"infracon/telemetry"
 	"infracon/utils"
 	"net/http"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func BuildImage(slug, imageName, src string, useCustomDockerfile bool, c *gin.Context, flusher http.Flusher) {
+func BuildImage(slug, imageName, src string, useCustomDockerfile bool, labels utils.ResourceLabels, c *gin.Context, flusher http.Flusher) {
+	if warning := disk.BuildWarning(); warning != "" {
+		utils.WriteSSEData(slug, labels.DeploymentID, []string{"INFO", "Warning: " + warning}, c, flusher)
+	}
+
+	start := time.Now()
 	if useCustomDockerfile {
 		if !utils.PathExists(filepath.Join(src, "Dockerfile")) {
-			utils.WriteSSEData(slug, []string{"ERROR", "Custom docker file doesn't exist"}, c, flusher)
+			utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", "Custom docker file doesn't exist"}, c, flusher)
+			telemetry.ObservePhase("build", start, errors.New("missing Dockerfile"))
 			return
 		}
 
-		cmd := exec.Command(
-			"docker",
-			"build",
-			"-t", imageName,
-			"-f", filepath.Join(src, "Dockerfile"), src,
-		)
+		args := []string{"build", "-t", imageName}
+		args = append(args, utils.DockerLabelArgs(labels)...)
+		args = append(args, "-f", filepath.Join(src, "Dockerfile"), src)
+		cmd := exec.Command("docker", args...)
 
-		utils.ExecCommandAndStreamViaSSE(slug, cmd, c, flusher)
+		err := utils.ExecCommandAndStreamViaSSE(slug, labels.DeploymentID, "BUILD", cmd, c, flusher)
+		telemetry.ObservePhase("build", start, err)
 	} else {
 		cmd := exec.Command(
 			"railpack",
@@ -36,37 +47,110 @@ func BuildImage(slug, imageName, src string, useCustomDockerfile bool, c *gin.Co
 			"--verbose",
 		)
 
-		utils.ExecCommandAndStreamViaSSE(slug, cmd, c, flusher)
+		err := utils.ExecCommandAndStreamViaSSE(slug, labels.DeploymentID, "BUILD", cmd, c, flusher)
+		if err == nil {
+			err = labelImage(slug, imageName, labels, c, flusher)
+		}
+		telemetry.ObservePhase("build", start, err)
 	}
 
 }
 
-func RunContainer(slug, imageName, containerName, envPath string, c *gin.Context, f http.Flusher) (string, error) {
-	cmd := exec.Command(
-		"docker",
-		"run",
+// labelImage adds infracon's labels to an image built by a tool that can't set them,
+// by rebuilding it as a single FROM layer under the same name.
+func labelImage(slug, imageName string, labels utils.ResourceLabels, c *gin.Context, flusher http.Flusher) error {
+	args := []string{"build", "-t", imageName}
+	args = append(args, utils.DockerLabelArgs(labels)...)
+	args = append(args, "-")
+
+	cmd := exec.Command("docker", args...)
+	cmd.Stdin = strings.NewReader("FROM " + imageName + "\n")
+	if err := utils.ExecCommandAndStreamViaSSE(slug, labels.DeploymentID, "BUILD", cmd, c, flusher); err != nil {
+		utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", fmt.Sprintf("Error labelling docker image: %s", err)}, c, flusher)
+		return err
+	}
+	return nil
+}
+
+func RunContainer(store db.Store, slug, imageName, containerName, envPath string, labels utils.ResourceLabels, c *gin.Context, f http.Flusher) (string, error) {
+	resources, err := store.Resources().Get(slug)
+	if err != nil {
+		utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", fmt.Sprintf("Error loading resource limits: %s", err)}, c, f)
+		return "", err
+	}
+
+	args := []string{
+		"create",
 		"--name", containerName,
 		"--env-file", envPath,
-		imageName,
-	)
+	}
+	args = append(args, utils.DockerLabelArgs(labels)...)
+	args = append(args, utils.DockerResourceArgs(resources)...)
+
+	mounts, err := volumeMountArgs(store, slug, labels.DeploymentID, c, f)
+	if err != nil {
+		utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", fmt.Sprintf("Error preparing volumes: %s", err)}, c, f)
+		return "", err
+	}
+	args = append(args, mounts...)
 
-	utils.ExecCommandAndStreamViaSSE(slug, cmd, c, f)
+	primary, extra, err := containerNetworks(store, slug, labels.DeploymentID, c, f)
+	if err != nil {
+		utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", fmt.Sprintf("Error preparing networks: %s", err)}, c, f)
+		return "", err
+	}
+	if primary != nil {
+		args = append(args, "--network", primary.name)
+		for _, alias := range primary.aliases {
+			args = append(args, "--network-alias", alias)
+		}
+	}
+
+	args, command := addonContainerArgs(store, slug, args)
+	args = append(args, imageName)
+	args = append(args, command...)
+
+	if err := utils.ExecCommandAndStreamViaSSE(slug, labels.DeploymentID, "RUN", exec.Command("docker", args...), c, f); err != nil {
+		utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", fmt.Sprintf("Error creating docker container: %s", err)}, c, f)
+		return "", err
+	}
+
+	// Networks are joined before the first start so linked services resolve right away.
+	for _, network := range extra {
+		connectArgs := []string{"network", "connect"}
+		for _, alias := range network.aliases {
+			connectArgs = append(connectArgs, "--alias", alias)
+		}
+		connectArgs = append(connectArgs, network.name, containerName)
+		if err := utils.ExecCommandAndStreamViaSSE(slug, labels.DeploymentID, "RUN", exec.Command("docker", connectArgs...), c, f); err != nil {
+			discardContainer(containerName)
+			utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", fmt.Sprintf("Error connecting to network %s: %s", network.name, err)}, c, f)
+			return "", err
+		}
+	}
+
+	if err := utils.ExecCommandAndStreamViaSSE(slug, labels.DeploymentID, "RUN", exec.Command("docker", "start", containerName), c, f); err != nil {
+		utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", fmt.Sprintf("Error running docker container: %s", err)}, c, f)
+		return "", err
+	}
 
 	ct, err := utils.GetDeploymentStatusetDockerContainer(containerName)
 	if err != nil {
-		utils.WriteSSEData(slug, []string{"ERROR", fmt.Sprintf("Error running docker container: %s", err)}, c, f)
+		utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", fmt.Sprintf("Error running docker container: %s", err)}, c, f)
 		return "", err
 	}
 
 	if !ct.State.Running {
-		utils.WriteSSEData(slug, []string{"ERROR", "Container is not running"}, c, f)
+		utils.WriteSSEData(slug, labels.DeploymentID, []string{"ERROR", "Container is not running"}, c, f)
 		return "", errors.New("Container is not running")
 	}
 
+	logstore.Follow(slug, containerName)
+
 	return ct.State.Status, nil
 }
 
-func RemoveContainer(slug, containerName string, c *gin.Context, f http.Flusher) error {
+func RemoveContainer(slug string, deploymentId int, containerName string, c *gin.Context, f http.Flusher) error {
 
 	cmd := exec.Command(
 		"docker",
@@ -74,13 +158,57 @@ func RemoveContainer(slug, containerName string, c *gin.Context, f http.Flusher)
 		"-f", // force stop and remove if running
 		containerName,
 	)
-	utils.ExecCommandAndStreamViaSSE(slug, cmd, c, f)
+	utils.ExecCommandAndStreamViaSSE(slug, deploymentId, "RUN", cmd, c, f)
 	_, err := utils.GetDeploymentStatusetDockerContainer(containerName)
 	if err == nil {
-		utils.WriteSSEData(slug, []string{"ERROR", "Container still exists after removal"}, c, f)
+		utils.WriteSSEData(slug, deploymentId, []string{"ERROR", "Container still exists after removal"}, c, f)
 		return errors.New("failed to remove container")
 	}
-	utils.WriteSSEData(slug, []string{"INFO", "Container removed successfully"}, c, f)
+	utils.WriteSSEData(slug, deploymentId, []string{"INFO", "Container removed successfully"}, c, f)
 	return nil
 
 }
+
+// WaitHealthy blocks until the container reports healthy, or, for images without a
+// HEALTHCHECK, until it has stayed up without restarting for HEALTHCHECK_STABLE_PERIOD.
+func WaitHealthy(slug string, deploymentId int, containerName string, c *gin.Context, f http.Flusher) error {
+	timeout := utils.GetEnvDuration("HEALTHCHECK_TIMEOUT", 60*time.Second)
+	stablePeriod := utils.GetEnvDuration("HEALTHCHECK_STABLE_PERIOD", 10*time.Second)
+	deadline := time.Now().Add(timeout)
+
+	utils.WriteSSEData(slug, deploymentId, []string{"INFO", fmt.Sprintf("Waiting for %s to become healthy", containerName)}, c, f)
+
+	for {
+		ct, err := utils.GetDeploymentStatusetDockerContainer(containerName)
+		if err != nil {
+			return err
+		}
+
+		if !ct.State.Running || ct.State.Restarting {
+			return fmt.Errorf("container exited with code %d", ct.State.ExitCode)
+		}
+
+		if ct.RestartCount > 0 {
+			return fmt.Errorf("container restarted %d times", ct.RestartCount)
+		}
+
+		if ct.State.Health != nil {
+			switch ct.State.Health.Status {
+			case "healthy":
+				utils.WriteSSEData(slug, deploymentId, []string{"INFO", "Container is healthy"}, c, f)
+				return nil
+			case "unhealthy":
+				return errors.New("container health check failed")
+			}
+		} else if time.Since(ct.State.StartedAt) >= stablePeriod {
+			utils.WriteSSEData(slug, deploymentId, []string{"INFO", "Container is running"}, c, f)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container was not healthy after %s", timeout)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
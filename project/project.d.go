@@ -1,6 +1,9 @@
 package project
 
-import "time"
+import (
+	"infracon/utils"
+	"time"
+)
 
 type CreateProjectPayload struct {
 	Name                string `form:"name" binding:"required"`
@@ -68,3 +71,85 @@ type Project struct {
 	ProjectPath *string   `json:"project_path" db:"project_path"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
+
+type RollbackTarget struct {
+	ImageTag   string            `json:"image_tag"`
+	CommitSha  *string           `json:"commit_sha"`
+	Available  bool              `json:"available"`
+	Pinned     bool              `json:"pinned"`
+	Deployment *utils.Deployment `json:"deployment"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+type SetResourcesPayload struct {
+	MemoryMB      int     `json:"memory_mb" binding:"min=0"`
+	CPUs          float64 `json:"cpus" binding:"min=0"`
+	PidsLimit     int     `json:"pids_limit" binding:"min=0"`
+	RestartPolicy string  `json:"restart_policy" binding:"required"`
+	MaxRetries    int     `json:"max_retries" binding:"min=0"`
+}
+
+// daemonContainer is a row of `docker ps --format {{json .}}`.
+type daemonContainer struct {
+	Names  string `json:"Names"`
+	Image  string `json:"Image"`
+	State  string `json:"State"`
+	Labels string `json:"Labels"`
+}
+
+type SetImageRetentionPayload struct {
+	KeepLast int `json:"keep_last" binding:"required,min=1"`
+	KeepDays int `json:"keep_days" binding:"min=0"`
+}
+
+type CreateVolumePayload struct {
+	Name      string `json:"name" binding:"required"`
+	MountPath string `json:"mount_path" binding:"required"`
+}
+
+type VolumeStatus struct {
+	utils.ProjectVolume
+	Exists    bool   `json:"exists"`
+	SizeBytes *int64 `json:"size_bytes"`
+}
+
+type CreateAddonPayload struct {
+	Name    string `json:"name" binding:"required"`
+	Engine  string `json:"engine" binding:"required"`
+	Version string `json:"version"`
+}
+
+type AddonStatus struct {
+	utils.Addon
+	Name    string  `json:"name"`
+	Status  *string `json:"status"`
+	Host    string  `json:"host"`
+	Port    int     `json:"port"`
+	EnvVar  string  `json:"env_var"`
+	Network string  `json:"network"`
+}
+
+type CreateLinkPayload struct {
+	Target    string `json:"target" binding:"required"`
+	EnvVar    string `json:"env_var"`
+	InjectEnv *bool  `json:"inject_env"`
+}
+
+// addonEngine describes how to run an official database image.
+type addonEngine struct {
+	image          string
+	defaultVersion string
+	port           int
+	dataPath       string
+	envVar         string
+	scheme         string
+	healthCmd      string
+	env            func(a utils.Addon) string
+	command        func(a utils.Addon) []string
+}
+
+// containerNetwork is a docker network a container joins, reachable there under aliases.
+type containerNetwork struct {
+	name    string
+	aliases []string
+}
@@ -5,14 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"infracon/audit"
 	"infracon/db"
+	"infracon/telemetry"
 	"infracon/utils"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -32,12 +33,12 @@ func CreateProject(c *gin.Context) {
 		})
 		return
 	}
+	stopHeartbeat := utils.StartSSEHeartbeat(c, flusher)
+	defer stopHeartbeat()
 
 	var body CreateProjectPayload
 	if err := c.ShouldBind(&body); err != nil {
-		line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", err.Error()}, utils.InfraconLogSeparator)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-		flusher.Flush()
+		utils.StreamSSEError(err.Error(), c, flusher)
 		return
 	}
 
@@ -50,113 +51,136 @@ func CreateProject(c *gin.Context) {
 		Name: body.Name,
 		Slug: uniqueSlug,
 		Type: &body.Type,
+		Env:  &body.Env,
 	}
+	store := db.StoreFrom(c)
+
 	var err error
-	project.ID, err = db.CreateProject(project)
+	project.ID, err = store.Projects().Create(project)
 	if err != nil {
-		utils.WriteSSEData(uniqueSlug, []string{"ERROR", fmt.Sprintf("Error saving project to db: %s", err)}, c, flusher)
+		utils.WriteSSEData(uniqueSlug, 0, []string{"ERROR", fmt.Sprintf("Error saving project to db: %s", err)}, c, flusher)
 		return
 	}
+	audit.Record(c, "project.create", "project", uniqueSlug, nil, audit.ProjectSummary(&project))
 
-	var useCustomDockerfile = body.UseCustomDockerfile == "true"
+	deployment := utils.Deployment{
+		ProjectSlug:   uniqueSlug,
+		Kind:          "deploy",
+		Source:        body.Type,
+		ImageTag:      imageName,
+		ContainerName: containerName,
+		Status:        "building",
+	}
+	deploymentId, err := store.Deployments().Create(deployment)
+	if err != nil {
+		utils.WriteSSEData(uniqueSlug, 0, []string{"ERROR", fmt.Sprintf("Error saving deployment to db: %s", err)}, c, flusher)
+		return
+	}
+	deployment.ID = deploymentId
+	logWriter := startBuildLog(store, uniqueSlug, deploymentId)
 
 	if body.Type == "zip-upload" {
-		file, err := c.FormFile("file")
-		if err != nil {
-			utils.WriteSSEData(uniqueSlug, []string{"ERROR", fmt.Sprintf("error uploading file: %s", err)}, c, flusher)
-			return
-		}
-
-		folders, err := utils.UnzipFileFromMultipartFile(file, projectPath)
+		projectPath, err = unpackUpload(c, projectPath)
 		if err != nil {
-			utils.WriteSSEData(uniqueSlug, []string{"ERROR", fmt.Sprintf("error unziping file: %s", err)}, c, flusher)
-			return
-		}
-
-		if len(folders) != 1 {
-			os.RemoveAll(projectPath)
-			utils.WriteSSEData(uniqueSlug, []string{"ERROR", "The uploaded zip file must contain exactly one root folder, but none or multiple were found."}, c, flusher)
+			failDeployment(store, uniqueSlug, deploymentId, err.Error(), c, flusher)
+			logWriter.Close()
+			audit.Record(c, "project.deploy", "project", uniqueSlug, nil, gin.H{"error": err.Error()})
 			return
 		}
-
-		projectPath = filepath.Join(projectPath, folders[0])
 	}
 
-	if body.Type == "github" {
-		if body.RepoName == "" {
-			utils.WriteSSEData(uniqueSlug, []string{"ERROR", "`repo_name` is required"}, c, flusher)
-			return
-		}
-
-		if body.RepoOwner == "" {
-			utils.WriteSSEData(uniqueSlug, []string{"ERROR", "`repo_owner` is required"}, c, flusher)
-			return
+	auditCtx := c.Copy()
+	deploy := func() error {
+		var useCustomDockerfile = body.UseCustomDockerfile == "true"
+		var commitSha *string
+
+		if body.Type == "github" {
+			if body.RepoName == "" {
+				return failDeployment(store, uniqueSlug, deploymentId, "`repo_name` is required", nil, nil)
+			}
+
+			if body.RepoOwner == "" {
+				return failDeployment(store, uniqueSlug, deploymentId, "`repo_owner` is required", nil, nil)
+			}
+
+			if body.RepoRef == "" {
+				return failDeployment(store, uniqueSlug, deploymentId, "`repo_ref` is required", nil, nil)
+			}
+
+			accessToken, err := store.Tokens().GetGithubToken()
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return failDeployment(store, uniqueSlug, deploymentId, fmt.Sprintf("error getting github token: %s", err.Error()), nil, nil)
+			}
+
+			payload := utils.PullfromGithub{
+				Owner:       body.RepoOwner,
+				Repo:        body.RepoName,
+				Ref:         body.RepoRef,
+				AccessToken: accessToken,
+				Destination: projectPath,
+			}
+
+			commithash, rootDir, err := utils.PullFromGithub(payload)
+			if err != nil {
+				return failDeployment(store, uniqueSlug, deploymentId, fmt.Sprintf("error pulling repo from github: %s", err.Error()), nil, nil)
+			}
+
+			projectPath = filepath.Join(projectPath, rootDir)
+			commitSha = &commithash
+			store.Deployments().SetCommit(deploymentId, commithash)
+
+			githubRepo := body.RepoOwner + "/" + body.RepoName
+			store.Projects().Update(utils.Project{ID: project.ID, GithubRepo: &githubRepo})
 		}
 
-		if body.RepoRef == "" {
-			utils.WriteSSEData(uniqueSlug, []string{"ERROR", "`repo_ref` is required"}, c, flusher)
-			return
+		BuildImage(uniqueSlug, imageName, projectPath, useCustomDockerfile, utils.ResourceLabels{ProjectSlug: uniqueSlug, DeploymentID: deploymentId, CommitSha: commitSha}, nil, nil)
+		if _, err := utils.GetDockerImage(imageName); err != nil {
+			return failDeployment(store, uniqueSlug, deploymentId, fmt.Sprintf("Error building docker image: %s", err), nil, nil)
 		}
 
-		accessToken, err := db.GetGithubToken()
-		if err != nil {
-			utils.WriteSSEData(uniqueSlug, []string{"ERROR", fmt.Sprintf("error getting github token: %s", err.Error())}, c, flusher)
-			return
+		release := Release{
+			Project:       &project,
+			DeploymentID:  deploymentId,
+			Kind:          "deploy",
+			Image:         imageName,
+			ContainerName: containerName,
+			ProjectPath:   projectPath,
+			CommitSha:     commitSha,
 		}
 
-		payload := utils.PullfromGithub{
-			Owner:       body.RepoOwner,
-			Repo:        body.Name,
-			Ref:         body.RepoRef,
-			AccessToken: accessToken,
-			Destination: projectPath,
-		}
+		return Cutover(store, release, nil, nil)
+	}
 
-		commithash, err := utils.PullFromGithub(payload)
+	detachDeployment(store, &deployment, logWriter, func() {
+		err := deploy()
+		after := audit.ProjectSummary(&project)
 		if err != nil {
-			utils.WriteSSEData(uniqueSlug, []string{"ERROR", fmt.Sprintf("error pulling repo from github: %s", err.Error())}, c, flusher)
-			return
+			after["error"] = err.Error()
 		}
+		audit.Record(auditCtx, "project.deploy", "project", uniqueSlug, nil, after)
+	}, c, flusher)
+}
 
-		projectPath = filepath.Join(projectPath, commithash)
-	}
-
-	BuildImage(uniqueSlug, imageName, projectPath, useCustomDockerfile, c, flusher)
-	if _, err := utils.GetDockerImage(uniqueSlug); err != nil {
-		utils.WriteSSEData(uniqueSlug, []string{"ERROR", fmt.Sprintf("Error building docker image: %s", err)}, c, flusher)
-		return
-	}
-
-	envPath := filepath.Join(projectPath, ".env")
-	if body.Env != "" {
-		utils.WriteEnvFile(projectPath, body.Env)
-	}
-
-	status, _ := RunContainer(uniqueSlug, imageName, containerName, envPath, c, flusher)
-
-	project = utils.Project{
-		Env:           &body.Env,
-		ProjectPath:   &projectPath,
-		Status:        &status,
-		ContainerName: &uniqueSlug,
-		CurrentImage:  &uniqueSlug,
+// unpackUpload extracts the uploaded zip into dir and returns its root folder. It runs
+// before the pipeline detaches, since the upload is removed along with the request.
+func unpackUpload(c *gin.Context, dir string) (string, error) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return "", fmt.Errorf("error uploading file: %s", err)
 	}
 
-	if err := db.UpdateProject(project); err != nil {
-		utils.WriteSSEData(uniqueSlug, []string{"ERROR", fmt.Sprintf("Error saving project to db: %s", err)}, c, flusher)
-		return
+	folders, err := utils.UnzipFileFromMultipartFile(file, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("error unziping file: %s", err)
 	}
 
-	logs := utils.GetLogs(uniqueSlug)
-	defer utils.DeleteLogs(uniqueSlug)
-	if len(logs) > 0 {
-		if err := db.SaveLogs(uniqueSlug, logs); err != nil {
-			line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", err.Error()}, utils.InfraconLogSeparator)
-			fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-			flusher.Flush()
-		}
+	if len(folders) != 1 {
+		os.RemoveAll(dir)
+		return "", errors.New("The uploaded zip file must contain exactly one root folder, but none or multiple were found.")
 	}
 
+	return filepath.Join(dir, folders[0]), nil
 }
 
 func GetProject(c *gin.Context) {
@@ -171,7 +195,7 @@ func GetProject(c *gin.Context) {
 		return
 	}
 
-	project, err := db.GetProject(slug)
+	project, err := db.StoreFrom(c).Projects().Get(slug)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -200,7 +224,7 @@ func GetProject(c *gin.Context) {
 
 func GetProjects(c *gin.Context) {
 
-	projects, err := db.GetProjects()
+	projects, err := db.StoreFrom(c).Projects().List()
 	if err != nil {
 		log.Printf("project fetch query error: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -233,6 +257,8 @@ func UpdateProjectSource(c *gin.Context) {
 		})
 		return
 	}
+	stopHeartbeat := utils.StartSSEHeartbeat(c, flusher)
+	defer stopHeartbeat()
 
 	slug := c.PostForm("slug")
 	source := c.PostForm("source")
@@ -241,9 +267,7 @@ func UpdateProjectSource(c *gin.Context) {
 	if err := utils.StringValidator("slug", slug, utils.ValidatorConfig{
 		NotEmpty: true,
 	}); err != nil {
-		line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", err.Error()}, utils.InfraconLogSeparator)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-		flusher.Flush()
+		utils.StreamSSEError(err.Error(), c, flusher)
 		return
 	}
 
@@ -251,146 +275,216 @@ func UpdateProjectSource(c *gin.Context) {
 		NotEmpty:       true,
 		ExpectedValues: []string{"github", "zip-upload"},
 	}); err != nil {
-		line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", err.Error()}, utils.InfraconLogSeparator)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-		flusher.Flush()
+		utils.StreamSSEError(err.Error(), c, flusher)
 		return
 	}
 
-	project, err := db.GetProject(slug)
+	store := db.StoreFrom(c)
+
+	project, err := store.Projects().Get(slug)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", "Project nor found"}, utils.InfraconLogSeparator)
-			fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-			flusher.Flush()
+			utils.StreamSSEError("Project not found", c, flusher)
 			return
 		} else {
-			line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", "Project nor found"}, utils.InfraconLogSeparator)
-			fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-			flusher.Flush()
+			utils.StreamSSEError("Project not found", c, flusher)
 			return
 		}
 	}
 
+	if project.Type != nil && *project.Type == "addon" {
+		utils.StreamSSEError("Add-ons run an official image and have no source to deploy", c, flusher)
+		return
+	}
+
+	before := audit.ProjectSummary(project)
 	deploymentId := fmt.Sprintf("%s-%s", slug, strconv.Itoa(int(time.Now().UnixMilli())))
 	newProjectPath := filepath.Join("infracon-apps", deploymentId)
 	containerName := deploymentId
 	imageName := deploymentId
 
+	deployment := utils.Deployment{
+		ProjectSlug:   slug,
+		Kind:          "deploy",
+		Source:        source,
+		ImageTag:      imageName,
+		ContainerName: containerName,
+		Status:        "building",
+	}
+	deploymentRecordId, err := store.Deployments().Create(deployment)
+	if err != nil {
+		utils.WriteSSEData(slug, 0, []string{"ERROR", fmt.Sprintf("Error saving deployment to db: %s", err)}, c, flusher)
+		return
+	}
+	deployment.ID = deploymentRecordId
+	logWriter := startBuildLog(store, slug, deploymentRecordId)
+
 	if source == "zip-upload" {
-		file, err := c.FormFile("file")
+		newProjectPath, err = unpackUpload(c, newProjectPath)
 		if err != nil {
-			utils.WriteSSEData(slug, []string{"ERROR", fmt.Sprintf("error uploading file: %s", err)}, c, flusher)
+			failDeployment(store, slug, deploymentRecordId, err.Error(), c, flusher)
+			logWriter.Close()
+			audit.Record(c, "project.deploy", "project", slug, before, gin.H{"error": err.Error()})
 			return
 		}
+	}
 
-		folders, err := utils.UnzipFileFromMultipartFile(file, newProjectPath)
-		if err != nil {
-			os.RemoveAll(newProjectPath)
-			utils.WriteSSEData(slug, []string{"ERROR", fmt.Sprintf("error unziping file: %s", err)}, c, flusher)
-			return
-		}
+	repoName := c.PostForm("github_repo_name")
+	repoOwner := c.PostForm("github_owner")
+	repoBranch := c.PostForm("github_branch")
+	auditCtx := c.Copy()
 
-		if len(folders) != 1 {
-			os.RemoveAll(newProjectPath)
-			utils.WriteSSEData(slug, []string{"ERROR", "The uploaded zip file must contain exactly one root folder, but none or multiple were found."}, c, flusher)
-			return
-		}
+	deploy := func() error {
+		var commitSha *string
 
-		newProjectPath = filepath.Join(newProjectPath, folders[0])
-	}
+		if source == "github" {
+			if repoName == "" {
+				return failDeployment(store, slug, deploymentRecordId, "`repo_name` is required", nil, nil)
+			}
 
-	if source == "github" {
-		repoName := c.PostForm("github_repo_name")
-		repoOwner := c.PostForm("github_owner")
-		repoBranch := c.PostForm("github_branch")
+			if repoOwner == "" {
+				return failDeployment(store, slug, deploymentRecordId, "`repo_owner` is required", nil, nil)
+			}
 
-		if repoName == "" {
-			utils.WriteSSEData(project.Slug, []string{"ERROR", "`repo_name` is required"}, c, flusher)
-			return
-		}
+			if repoBranch == "" {
+				return failDeployment(store, slug, deploymentRecordId, "`repo_ref` is required", nil, nil)
+			}
 
-		if repoOwner == "" {
-			utils.WriteSSEData(project.Slug, []string{"ERROR", "`repo_owner` is required"}, c, flusher)
-			return
+			accessToken, err := store.Tokens().GetGithubToken()
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return failDeployment(store, slug, deploymentRecordId, fmt.Sprintf("error getting github token: %s", err.Error()), nil, nil)
+			}
+
+			payload := utils.PullfromGithub{
+				Owner:       repoOwner,
+				Repo:        repoName,
+				Ref:         repoBranch,
+				AccessToken: accessToken,
+				Destination: newProjectPath,
+			}
+
+			commithash, rootDir, err := utils.PullFromGithub(payload)
+			if err != nil {
+				return failDeployment(store, slug, deploymentRecordId, fmt.Sprintf("error pulling repo from github: %s", err.Error()), nil, nil)
+			}
+
+			newProjectPath = filepath.Join(newProjectPath, rootDir)
+			commitSha = &commithash
+			store.Deployments().SetCommit(deploymentRecordId, commithash)
+
+			githubRepo := repoOwner + "/" + repoName
+			store.Projects().Update(utils.Project{ID: project.ID, GithubRepo: &githubRepo})
 		}
 
-		if repoBranch == "" {
-			utils.WriteSSEData(project.Slug, []string{"ERROR", "`repo_ref` is required"}, c, flusher)
-			return
+		BuildImage(slug, imageName, newProjectPath, useCustomDockerfile, utils.ResourceLabels{ProjectSlug: slug, DeploymentID: deploymentRecordId, CommitSha: commitSha}, nil, nil)
+		if _, err := utils.GetDockerImage(imageName); err != nil {
+			return failDeployment(store, slug, deploymentRecordId, fmt.Sprintf("Error building docker image: %s", err), nil, nil)
 		}
 
-		accessToken, err := db.GetGithubToken()
-		if err != nil {
-			utils.WriteSSEData(project.Slug, []string{"ERROR", fmt.Sprintf("error getting github token: %s", err.Error())}, c, flusher)
-			return
+		oldProjectPath := project.ProjectPath
+		release := Release{
+			Project:       project,
+			DeploymentID:  deploymentRecordId,
+			Kind:          "deploy",
+			Image:         imageName,
+			ContainerName: containerName,
+			ProjectPath:   newProjectPath,
+			CommitSha:     commitSha,
+			Type:          &source,
 		}
 
-		payload := utils.PullfromGithub{
-			Owner:       repoOwner,
-			Repo:        repoName,
-			Ref:         repoBranch,
-			AccessToken: accessToken,
-			Destination: newProjectPath,
+		if err := Cutover(store, release, nil, nil); err != nil {
+			return err
 		}
+		if oldProjectPath != nil && *oldProjectPath != newProjectPath {
+			os.RemoveAll(*oldProjectPath)
+		}
+		return nil
+	}
 
-		commithash, err := utils.PullFromGithub(payload)
+	detachDeployment(store, &deployment, logWriter, func() {
+		err := deploy()
+		after := audit.ProjectSummary(project)
 		if err != nil {
-			utils.WriteSSEData(project.Slug, []string{"ERROR", fmt.Sprintf("error pulling repo from github: %s", err.Error())}, c, flusher)
-			return
+			after["error"] = err.Error()
 		}
+		audit.Record(auditCtx, "project.deploy", "project", project.Slug, before, after)
+	}, c, flusher)
+}
 
-		newProjectPath = filepath.Join(newProjectPath, commithash)
-	}
-
-	BuildImage(deploymentId, deploymentId, newProjectPath, useCustomDockerfile, c, flusher)
-	if _, err := utils.GetDockerImage(deploymentId); err != nil {
-		utils.WriteSSEData(project.Slug, []string{"ERROR", fmt.Sprintf("Error building docker image: %s", err)}, c, flusher)
+// SetEnvironmentVariable saves the project's env and restarts it so the next release,
+// like every later one, starts with it.
+func SetEnvironmentVariable(c *gin.Context) {
+	var body SetEnvironmentVariablePayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid payload",
+			"details": err.Error(),
+		})
 		return
 	}
 
-	envPath := filepath.Join(newProjectPath, ".env")
-	if project.Env != nil {
-		utils.WriteEnvFile(newProjectPath, *project.Env)
+	project, err := db.StoreFrom(c).Projects().Get(body.Slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Project not found!",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("project query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
 	}
 
-	status, _ := RunContainer(project.Slug, imageName, containerName, envPath, c, flusher)
-	oldProjectPath := project.ProjectPath
-	oldDockerImage := project.CurrentImage
-	oldDockerContainer := project.ContainerName
-
-	project.Status = &status
-	project.ContainerName = &containerName
-	project.CurrentImage = &imageName
-	project.Type = &source
-	project.ProjectPath = &newProjectPath
-
-	if err := RemoveContainer(slug, *oldDockerContainer, c, flusher); err != nil {
-		utils.WriteSSEData(project.Slug, []string{"ERROR", fmt.Sprintf("Error removing old docker container: %s", err)}, c, flusher)
+	if err := db.StoreFrom(c).Projects().Update(utils.Project{ID: project.ID, Env: &body.Env}); err != nil {
+		log.Printf("project env update error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
 		return
 	}
 
-	if err := db.UpdateProject(*project); err != nil {
-		utils.WriteSSEData(project.Slug, []string{"ERROR", fmt.Sprintf("Error saving project to db: %s", err)}, c, flusher)
-		return
+	var oldEnv string
+	if project.Env != nil {
+		oldEnv = *project.Env
 	}
+	audit.Record(c, "project.env.update", "project", project.Slug, gin.H{"env_keys": audit.EnvKeys(oldEnv)}, gin.H{"env_keys": audit.EnvKeys(body.Env)})
+	project.Env = &body.Env
 
-	os.RemoveAll(*oldProjectPath)
-	if err := db.AddDockerImage(slug, *oldDockerImage); err != nil {
-		utils.WriteSSEData(slug, []string{"ERROR", fmt.Sprintf("Error archiving old docker image: %s", err)}, c, flusher)
+	if project.ContainerName == nil || *project.ContainerName == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  true,
+			"message": "Environment variables saved, they apply from the next deploy",
+		})
+		return
 	}
 
-	logs := utils.GetLogs(project.Slug)
-	defer utils.DeleteLogs(project.Slug)
-	if len(logs) > 0 {
-		if err := db.SaveLogs(project.Slug, logs); err != nil {
-			utils.WriteSSEData(slug, []string{"ERROR", fmt.Sprintf("Error saving logs: %s", err)}, c, flusher)
-		}
+	deploymentId, err := Restart(db.StoreFrom(c), project, "environment variables changed")
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Environment variables saved, but the project could not be restarted: " + err.Error(),
+			"status":  false,
+		})
+		return
 	}
 
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  true,
+		"message": "Environment variables saved, restarting project",
+		"data": gin.H{
+			"deployment_id": deploymentId,
+		},
+	})
 }
 
-func SetEnvironmentVariable(c *gin.Context) {
+func RollDeployment(c *gin.Context) {
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	c.Writer.Header().Set("Cache-Control", "no-cache")
 	c.Writer.Header().Set("Connection", "keep-alive")
@@ -404,144 +498,194 @@ func SetEnvironmentVariable(c *gin.Context) {
 		})
 		return
 	}
+	stopHeartbeat := utils.StartSSEHeartbeat(c, flusher)
+	defer stopHeartbeat()
 
-	var body SetEnvironmentVariablePayload
+	var body RollDeploymentPayload
 	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
-		line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", err.Error()}, utils.InfraconLogSeparator)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-		flusher.Flush()
+		utils.StreamSSEError(err.Error(), c, flusher)
 		return
 	}
 
-	project, err := db.GetProject(body.Slug)
+	project, err := db.StoreFrom(c).Projects().Get(body.Slug)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", "Project not found"}, utils.InfraconLogSeparator)
-			fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-			flusher.Flush()
+			utils.StreamSSEError("Project not found", c, flusher)
 			return
 		} else {
-			line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", err.Error()}, utils.InfraconLogSeparator)
-			fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-			flusher.Flush()
+			utils.StreamSSEError(err.Error(), c, flusher)
 			return
 		}
 	}
 
-	envPath := filepath.Join(*project.ProjectPath, ".env")
-	if body.Env != "" {
-		utils.WriteEnvFile(*project.ProjectPath, body.Env)
+	if project.CurrentImage != nil && *project.CurrentImage == body.Tag {
+		utils.StreamSSEError("Current image already deployed", c, flusher)
+		return
 	}
 
-	status, _ := RunContainer(body.Slug, *project.CurrentImage, *project.ContainerName, envPath, c, flusher)
-	update := utils.Project{
-		ID:     project.ID,
-		Status: &status,
-		Env:    &body.Env,
+	before := audit.ProjectSummary(project)
+	err = Rollback(db.StoreFrom(c), project, body.Tag, "", c, flusher)
+	after := audit.ProjectSummary(project)
+	if err != nil {
+		after["error"] = err.Error()
 	}
-
-	if err := db.UpdateProject(update); err != nil {
-		utils.WriteSSEData(project.Slug, []string{"ERROR", fmt.Sprintf("Error saving project to db: %s", err)}, c, flusher)
-		return
+	audit.Record(c, "project.rollback", "project", project.Slug, before, after)
+	if err == nil {
+		utils.WriteSSEData(project.Slug, 0, []string{"INFO", "Rollback complete"}, c, flusher)
 	}
 
-	utils.WriteSSEData(body.Slug, []string{"ERROR", "Environment variable set"}, c, flusher)
-
 }
 
-func RollDeployment(c *gin.Context) {
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
-	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+func GetRollbackTargets(c *gin.Context) {
+	store := db.StoreFrom(c)
 
-	flusher, ok := c.Writer.(http.Flusher)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, map[string]any{
+	project, err := store.Projects().Get(c.Param("slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Project not found!",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("project lookup query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
 			"status":  false,
-			"message": "SSE not supported",
 		})
 		return
 	}
 
-	var body RollDeploymentPayload
-	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
-		line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", err.Error()}, utils.InfraconLogSeparator)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-		flusher.Flush()
+	images, err := store.Images().List(project.Slug)
+	if err != nil {
+		log.Printf("docker images query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
 		return
 	}
 
-	project, err := db.GetProject(body.Slug)
+	pinned, err := store.Images().Pinned(project.Slug)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", "Project not found"}, utils.InfraconLogSeparator)
-			fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-			flusher.Flush()
-			return
-		} else {
-			line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", err.Error()}, utils.InfraconLogSeparator)
-			fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-			flusher.Flush()
-			return
+		log.Printf("pinned images query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	targets := []RollbackTarget{}
+	for _, img := range images {
+		if project.CurrentImage != nil && img.ImageTag == *project.CurrentImage {
+			continue
+		}
+
+		target := RollbackTarget{
+			ImageTag:  img.ImageTag,
+			CommitSha: img.CommitSha,
+			CreatedAt: img.CreatedAt,
+			Pinned:    pinned[img.ImageTag],
+		}
+
+		if img.DeploymentID != nil {
+			if d, err := store.Deployments().Get(project.Slug, *img.DeploymentID); err == nil {
+				target.Deployment = d
+			}
 		}
+
+		_, err := utils.GetDockerImage(img.ImageTag)
+		target.Available = err == nil
+
+		targets = append(targets, target)
 	}
 
-	if *project.CurrentImage == body.Tag {
-		line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", "Current image already deployed"}, utils.InfraconLogSeparator)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-		flusher.Flush()
-		return
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"current_image": project.CurrentImage,
+			"targets":       targets,
+		},
+	})
+}
+
+func GetDeployments(c *gin.Context) {
+	slug := c.Param("slug")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
 	}
 
-	imageTagExists, err := db.HasDockerImage(project.Slug, body.Tag)
-	if err != nil {
-		line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", fmt.Sprintf("Error getting docker image: %s", err)}, utils.InfraconLogSeparator)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-		flusher.Flush()
-		return
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
 	}
 
-	if !imageTagExists {
-		line := strings.Join([]string{strconv.Itoa(int(time.Now().UnixMilli())), "ERROR", "Docker image not found"}, utils.InfraconLogSeparator)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-		flusher.Flush()
+	deployments, err := db.StoreFrom(c).Deployments().List(slug, limit, offset)
+	if err != nil {
+		log.Printf("deployments query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
 		return
 	}
 
-	envPath := filepath.Join(*project.ProjectPath, ".env")
-	oldContainerName := project.ContainerName
-	oldImageName := *project.CurrentImage
-	newContainerName := fmt.Sprintf("%s-%s", body.Tag, strconv.Itoa(int(time.Now().UnixMilli())))
-	status, _ := RunContainer(body.Slug, body.Tag, *project.ContainerName, envPath, c, flusher)
-	update := utils.Project{
-		ID:            project.ID,
-		Status:        &status,
-		ContainerName: &newContainerName,
-		CurrentImage:  &body.Tag,
-	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"deployments": deployments,
+		},
+		"meta": gin.H{
+			"limit":  limit,
+			"offset": offset,
+		},
+	})
+}
 
-	if err := db.UpdateProject(update); err != nil {
-		utils.WriteSSEData(project.Slug, []string{"ERROR", fmt.Sprintf("Error saving project to db: %s", err)}, c, flusher)
+func GetDeploymentLogs(c *gin.Context) {
+	slug := c.Param("slug")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid deployment id",
+			"status":  false,
+		})
 		return
 	}
 
-	if err := db.AddDockerImage(body.Slug, oldImageName); err != nil {
-		utils.WriteSSEData(project.Slug, []string{"ERROR", fmt.Sprintf("Error archiving docker image: %s", err)}, c, flusher)
+	if _, err := db.StoreFrom(c).Deployments().Get(slug, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Deployment not found",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("deployment query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
 		return
 	}
 
-	if err := RemoveContainer(body.Slug, *oldContainerName, c, flusher); err != nil {
-		utils.WriteSSEData(project.Slug, []string{"ERROR", fmt.Sprintf("Error removing old docker container: %s", err)}, c, flusher)
-		return
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil || limit <= 0 || limit > 5000 {
+		limit = 500
 	}
 
-}
+	afterSeq, err := strconv.Atoi(c.DefaultQuery("after_seq", "0"))
+	if err != nil || afterSeq < 0 {
+		afterSeq = 0
+	}
 
-func GetGithubTokens(c *gin.Context) {
-	db, err := db.GetDatabase()
+	lines, err := db.StoreFrom(c).Logs().GetDeploymentLogs(id, afterSeq, limit)
 	if err != nil {
-		log.Printf("db error: %s", err)
+		log.Printf("deployment logs query error: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Something went wrong",
 			"status":  false,
@@ -549,8 +693,33 @@ func GetGithubTokens(c *gin.Context) {
 		return
 	}
 
-	var token string
-	if err := db.QueryRow("SELECT token FROM github_tokens").Scan(&token); err != nil && !errors.Is(err, sql.ErrNoRows) {
+	meta := gin.H{
+		"limit":     limit,
+		"after_seq": afterSeq,
+	}
+	if len(lines) == limit {
+		meta["next_after_seq"] = lines[len(lines)-1].Seq
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"logs": lines,
+		},
+		"meta": meta,
+	})
+}
+
+// failDeployment records the deployment as failed and returns message as an error.
+func failDeployment(store db.Store, slug string, deploymentId int, message string, c *gin.Context, f http.Flusher) error {
+	utils.WriteSSEData(slug, deploymentId, []string{"ERROR", message}, c, f)
+	finishDeployment(store, slug, deploymentId, "failed", message, c, f)
+	return errors.New(message)
+}
+
+func GetGithubTokens(c *gin.Context) {
+	token, err := db.StoreFrom(c).Tokens().GetGithubToken()
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		log.Printf("query error: %s", err)
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Something went wrong",
@@ -600,9 +769,9 @@ func AddGithubToken(c *gin.Context) {
 		return
 	}
 
-	db, err := db.GetDatabase()
+	replaced, err := db.StoreFrom(c).Tokens().SetGithubToken(body.Token)
 	if err != nil {
-		log.Printf("db error: %s", err)
+		log.Printf("token query error: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Something went wrong",
 			"status":  false,
@@ -610,37 +779,7 @@ func AddGithubToken(c *gin.Context) {
 		return
 	}
 
-	var existingTokenId int
-	if err := db.QueryRow("SELECT user_id FROM github_tokens").Scan(&existingTokenId); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			log.Printf("query error: %s", err)
-			c.JSON(http.StatusOK, gin.H{
-				"message": "Something went wrong",
-				"status":  false,
-			})
-			return
-		}
-	}
-
-	if existingTokenId > 0 {
-		if err := db.QueryRow("UPDATE github_tokens SET token = $1 WHERE user_id = $2 RETURNING user_id", body.Token, existingTokenId).Scan(new(int)); err != nil {
-			log.Printf("update query error: %s", err)
-			c.JSON(http.StatusOK, gin.H{
-				"message": "Something went wrong",
-				"status":  false,
-			})
-			return
-		}
-	} else {
-		if err := db.QueryRow("INSERT INTO github_tokens (user_id, token) VALUES (1,$1) RETURNING user_id", body.Token, existingTokenId).Scan(new(int)); err != nil {
-			log.Printf("insert query error: %s", err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Something went wrong",
-				"status":  false,
-			})
-			return
-		}
-	}
+	audit.Record(c, "github_token.update", "github_token", "github", gin.H{"configured": replaced}, gin.H{"configured": true})
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  true,
@@ -660,18 +799,8 @@ func GetGithubRepos(c *gin.Context) {
 		return
 	}
 
-	db, err := db.GetDatabase()
+	token, err := db.StoreFrom(c).Tokens().GetGithubToken()
 	if err != nil {
-		log.Printf("db error: %s", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Something went wrong",
-			"status":  false,
-		})
-		return
-	}
-
-	var token string
-	if err := db.QueryRow("SELECT token FROM github_tokens").Scan(&token); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			c.JSON(http.StatusNotFound, gin.H{
 				"message": "Github token not found!",
@@ -714,6 +843,7 @@ func GetGithubRepos(c *gin.Context) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
+		telemetry.GithubAPIErrors.Inc("list_repos")
 		log.Printf("http error 2: %s", err)
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Something went wrong",
@@ -724,6 +854,7 @@ func GetGithubRepos(c *gin.Context) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
+		telemetry.GithubAPIErrors.Inc("list_repos")
 		log.Printf("http error 3: %s", err)
 		var response map[string]string
 		json.NewDecoder(resp.Body).Decode(&response)
@@ -769,18 +900,8 @@ func GetGithubRepoBranches(c *gin.Context) {
 		return
 	}
 
-	db, err := db.GetDatabase()
+	token, err := db.StoreFrom(c).Tokens().GetGithubToken()
 	if err != nil {
-		log.Printf("db error: %s", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Something went wrong",
-			"status":  false,
-		})
-		return
-	}
-
-	var token string
-	if err := db.QueryRow("SELECT token FROM github_tokens").Scan(&token); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			c.JSON(http.StatusNotFound, gin.H{
 				"message": "Github token not found!",
@@ -815,6 +936,7 @@ func GetGithubRepoBranches(c *gin.Context) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
+		telemetry.GithubAPIErrors.Inc("list_branches")
 		log.Printf("http error 2: %s", err)
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Something went wrong",
@@ -825,6 +947,7 @@ func GetGithubRepoBranches(c *gin.Context) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
+		telemetry.GithubAPIErrors.Inc("list_branches")
 		log.Printf("http error 3: %s", err)
 		var response map[string]string
 		json.NewDecoder(resp.Body).Decode(&response)
@@ -870,11 +993,13 @@ func validateGithubToken(token string) error {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
+		telemetry.GithubAPIErrors.Inc("validate_token")
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		telemetry.GithubAPIErrors.Inc("validate_token")
 		return errors.New("invalid github token")
 	}
 
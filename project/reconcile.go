@@ -0,0 +1,395 @@
+package project
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/events"
+	"infracon/logstore"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	reconcileMu  sync.Mutex
+	lastReportMu sync.Mutex
+	lastReport   *utils.ReconcileReport
+)
+
+// RunReconciler fails deployments that a crash or reboot interrupted, then keeps
+// infracon.db in line with the docker daemon every RECONCILE_INTERVAL.
+func RunReconciler(store db.Store) {
+	n, err := store.Deployments().FailInterrupted("interrupted: infracon stopped before the deployment finished")
+	if err != nil {
+		log.Printf("reconciler error: %s", err)
+	} else if n > 0 {
+		log.Printf("reconciler: marked %d interrupted deployments as failed", n)
+	}
+
+	interval := utils.GetEnvDuration("RECONCILE_INTERVAL", 5*time.Minute)
+	for {
+		if _, err := Reconcile(store); err != nil {
+			log.Printf("reconciler error: %s", err)
+		}
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Reconcile compares every project with its container, fixes the recorded state,
+// starts containers that should be running and reports leftover containers and
+// images. Leftovers are only removed when RECONCILE_REMOVE_ORPHANS is "true".
+// Projects with a deployment in progress are left alone.
+func Reconcile(store db.Store) (*utils.ReconcileReport, error) {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	report := &utils.ReconcileReport{
+		StartedAt:        time.Now(),
+		Actions:          []utils.ReconcileAction{},
+		OrphanContainers: []string{},
+		OrphanImages:     []string{},
+		Removed:          []string{},
+	}
+
+	projects, err := store.Projects().List()
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := listDaemonContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]daemonContainer{}
+	for _, ct := range containers {
+		byName[ct.Names] = ct
+	}
+
+	listed := map[string]bool{}
+	busy := map[string]bool{}
+	for i := range projects {
+		p := &projects[i]
+		listed[p.Slug] = true
+		if isBusy(p.Slug) {
+			busy[p.Slug] = true
+			continue
+		}
+		reconcileProject(store, p, byName, containers, report)
+	}
+
+	live := map[string]bool{}
+	retained := map[string]bool{}
+	for _, p := range projects {
+		if p.ContainerName != nil && *p.ContainerName != "" {
+			live[*p.ContainerName] = true
+		}
+		if p.CurrentImage != nil && *p.CurrentImage != "" {
+			retained[imageRef(*p.CurrentImage)] = true
+		}
+
+		images, err := store.Images().List(p.Slug)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range images {
+			retained[imageRef(img.ImageTag)] = true
+		}
+	}
+
+	// leaveAlone reports whether leftovers of slug must not be touched: its project is
+	// being deployed or maintained, now or when the projects were listed, or it was
+	// created after they were listed, so its live container and images aren't known here.
+	leaveAlone := func(slug string) bool {
+		if busy[slug] || isBusy(slug) {
+			return true
+		}
+		if listed[slug] {
+			return false
+		}
+		_, err := store.Projects().Get(slug)
+		return !errors.Is(err, sql.ErrNoRows)
+	}
+
+	owners := map[string]string{}
+	inUse := map[string]bool{}
+	for _, ct := range containers {
+		inUse[imageRef(ct.Image)] = true
+
+		if live[ct.Names] {
+			continue
+		}
+		labels, ok := utils.ParseResourceLabels(utils.ParseLabelList(ct.Labels))
+		if !ok || leaveAlone(labels.ProjectSlug) {
+			continue
+		}
+		owners[ct.Names] = labels.ProjectSlug
+		report.OrphanContainers = append(report.OrphanContainers, ct.Names)
+	}
+
+	images, err := utils.ListManagedImages()
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range images {
+		labels, ok := utils.ParseResourceLabels(img.Config.Labels)
+		if !ok || leaveAlone(labels.ProjectSlug) {
+			continue
+		}
+
+		refs := img.RepoTags
+		if len(refs) == 0 {
+			refs = []string{img.ID}
+		}
+		for _, ref := range refs {
+			ref = imageRef(ref)
+			if !retained[ref] && !inUse[ref] && !inUse[img.ID] {
+				owners[ref] = labels.ProjectSlug
+				report.OrphanImages = append(report.OrphanImages, ref)
+			}
+		}
+	}
+
+	// A deploy may have started since the leftovers were found.
+	if utils.GetEnv("RECONCILE_REMOVE_ORPHANS", "false") == "true" {
+		for _, name := range report.OrphanContainers {
+			if leaveAlone(owners[name]) {
+				continue
+			}
+			if err := exec.Command("docker", "rm", "-f", name).Run(); err != nil {
+				addReconcileAction(report, "", "error", fmt.Sprintf("could not remove container %s: %s", name, err))
+				continue
+			}
+			report.Removed = append(report.Removed, name)
+		}
+		for _, ref := range report.OrphanImages {
+			if leaveAlone(owners[ref]) {
+				continue
+			}
+			if err := exec.Command("docker", "rmi", ref).Run(); err != nil {
+				addReconcileAction(report, "", "error", fmt.Sprintf("could not remove image %s: %s", ref, err))
+				continue
+			}
+			report.Removed = append(report.Removed, ref)
+		}
+	}
+
+	report.FinishedAt = time.Now()
+
+	lastReportMu.Lock()
+	lastReport = report
+	lastReportMu.Unlock()
+
+	for _, a := range report.Actions {
+		log.Printf("reconciler: %s %s: %s", a.ProjectSlug, a.Action, a.Detail)
+	}
+	if len(report.OrphanContainers) > 0 || len(report.OrphanImages) > 0 {
+		log.Printf("reconciler: %d orphan containers, %d orphan images, %d removed", len(report.OrphanContainers), len(report.OrphanImages), len(report.Removed))
+	}
+
+	return report, nil
+}
+
+func reconcileProject(store db.Store, p *utils.Project, byName map[string]daemonContainer, containers []daemonContainer, report *utils.ReconcileReport) {
+	if p.ContainerName == nil || *p.ContainerName == "" {
+		return
+	}
+	containerName := *p.ContainerName
+	wantRunning := p.Status == nil || *p.Status != "stopped"
+
+	ct, ok := byName[containerName]
+	if !ok {
+		// A crash between starting a release and recording it leaves the new container
+		// running under a name the db doesn't know.
+		if adopted := newestRunningContainer(p.Slug, containers); adopted != nil {
+			status := "running"
+			update := utils.Project{ID: p.ID, Status: &status, ContainerName: &adopted.Names, CurrentImage: &adopted.Image}
+			if err := store.Projects().Update(update); err != nil {
+				addReconcileAction(report, p.Slug, "error", fmt.Sprintf("could not adopt %s: %s", adopted.Names, err))
+				return
+			}
+			p.Status, p.ContainerName, p.CurrentImage = update.Status, update.ContainerName, update.CurrentImage
+			logstore.Follow(p.Slug, adopted.Names)
+			events.PublishStatus(p.Slug, status, fmt.Sprintf("adopted %s", adopted.Names))
+			addReconcileAction(report, p.Slug, "adopted", fmt.Sprintf("%s no longer exists; %s is running %s", containerName, adopted.Names, adopted.Image))
+			return
+		}
+
+		if wantRunning && p.CurrentImage != nil && *p.CurrentImage != "" {
+			if _, err := utils.GetDockerImage(*p.CurrentImage); err == nil {
+				deploymentId, err := Restart(store, p, "container was missing during reconciliation")
+				if err == nil {
+					addReconcileAction(report, p.Slug, "recreated", fmt.Sprintf("%s no longer exists; deployment %d recreates it from %s", containerName, deploymentId, *p.CurrentImage))
+					return
+				}
+				addReconcileAction(report, p.Slug, "error", fmt.Sprintf("could not recreate %s: %s", containerName, err))
+			}
+		}
+
+		setReconciledStatus(store, p, "missing", report)
+		return
+	}
+
+	switch {
+	case ct.State == "running":
+		logstore.Follow(p.Slug, containerName)
+		setReconciledStatus(store, p, "running", report)
+	case ct.State == "restarting" || !wantRunning:
+		if wantRunning {
+			setReconciledStatus(store, p, ct.State, report)
+		}
+	default:
+		if err := exec.Command("docker", "start", containerName).Run(); err != nil {
+			addReconcileAction(report, p.Slug, "error", fmt.Sprintf("could not start %s: %s", containerName, err))
+			setReconciledStatus(store, p, ct.State, report)
+			return
+		}
+		logstore.Follow(p.Slug, containerName)
+		addReconcileAction(report, p.Slug, "started", fmt.Sprintf("%s was %s", containerName, ct.State))
+		setReconciledStatus(store, p, "running", report)
+	}
+}
+
+func setReconciledStatus(store db.Store, p *utils.Project, status string, report *utils.ReconcileReport) {
+	previous := ""
+	if p.Status != nil {
+		previous = *p.Status
+	}
+	if previous == status {
+		return
+	}
+
+	if err := store.Projects().Update(utils.Project{ID: p.ID, Status: &status}); err != nil {
+		addReconcileAction(report, p.Slug, "error", fmt.Sprintf("could not save status %s: %s", status, err))
+		return
+	}
+	p.Status = &status
+	events.PublishStatus(p.Slug, status, "reconciled with the docker daemon")
+	addReconcileAction(report, p.Slug, "status", fmt.Sprintf("%s -> %s", previous, status))
+}
+
+func addReconcileAction(report *utils.ReconcileReport, slug, action, detail string) {
+	report.Actions = append(report.Actions, utils.ReconcileAction{ProjectSlug: slug, Action: action, Detail: detail})
+}
+
+// isBusy reports whether a deployment of the project is in progress, its latest
+// release is still being watched for an automatic rollback or its container is
+// stopped for maintenance.
+func isBusy(slug string) bool {
+	deploying := false
+	buildLogsMu.Lock()
+	for _, w := range buildLogs {
+		if w.slug == slug {
+			deploying = true
+			break
+		}
+	}
+	buildLogsMu.Unlock()
+
+	watchersMu.Lock()
+	_, watched := watchers[slug]
+	watchersMu.Unlock()
+
+	maintenanceMu.Lock()
+	maintained := maintenance[slug]
+	maintenanceMu.Unlock()
+
+	return deploying || watched || maintained
+}
+
+// newestRunningContainer picks the project's running container from the latest deployment.
+func newestRunningContainer(slug string, containers []daemonContainer) *daemonContainer {
+	var newest *daemonContainer
+	newestDeployment := -1
+	for i, ct := range containers {
+		labels, ok := utils.ParseResourceLabels(utils.ParseLabelList(ct.Labels))
+		if ct.State != "running" || !ok || labels.ProjectSlug != slug {
+			continue
+		}
+		if labels.DeploymentID > newestDeployment {
+			newest, newestDeployment = &containers[i], labels.DeploymentID
+		}
+	}
+	return newest
+}
+
+func listDaemonContainers() ([]daemonContainer, error) {
+	output, err := exec.Command("docker", "ps", "-a", "--no-trunc", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var containers []daemonContainer
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+		var ct daemonContainer
+		if err := json.Unmarshal([]byte(line), &ct); err != nil {
+			return nil, fmt.Errorf("failed to parse docker ps output: %w", err)
+		}
+		containers = append(containers, ct)
+	}
+	return containers, nil
+}
+
+// imageRef normalises an image name so "app" and "app:latest" compare equal.
+func imageRef(name string) string {
+	return strings.TrimSuffix(name, ":latest")
+}
+
+func GetReconcileReport(c *gin.Context) {
+	lastReportMu.Lock()
+	report := lastReport
+	lastReportMu.Unlock()
+
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Reconciliation has not run yet",
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data":   report,
+	})
+}
+
+func RunReconcile(c *gin.Context) {
+	report, err := Reconcile(db.StoreFrom(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	audit.Record(c, "system.reconcile", "system", "reconciler", nil, gin.H{
+		"actions":           len(report.Actions),
+		"orphan_containers": report.OrphanContainers,
+		"orphan_images":     report.OrphanImages,
+		"removed":           report.Removed,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Reconciliation finished",
+		"status":  true,
+		"data":    report,
+	})
+}
@@ -0,0 +1,76 @@
+package project
+
+import (
+	"infracon/db/dbtest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReconcileRepairsDriftAndLeavesBusyProjectsAlone(t *testing.T) {
+	dir := installFakeDocker(t)
+	t.Setenv("RECONCILE_REMOVE_ORPHANS", "true")
+	store := dbtest.OpenStore(t)
+
+	liveProject(t, store, "api", "api:1", "api-1")
+	liveProject(t, store, "web", "web:1", "web-1")
+	liveProject(t, store, "busy", "busy:1", "busy-1")
+	ps := map[string]string{
+		"api-1":    `{"Names":"api-1","Image":"api:1","State":"exited","Labels":"infracon.managed=true,infracon.project=api,infracon.deployment=1"}`,
+		"web-2":    `{"Names":"web-2","Image":"web:2","State":"exited","Labels":"infracon.managed=true,infracon.project=web,infracon.deployment=2"}`,
+		"web-3":    `{"Names":"web-3","Image":"web:3","State":"running","Labels":"infracon.managed=true,infracon.project=web,infracon.deployment=3"}`,
+		"busy-1":   `{"Names":"busy-1","Image":"busy:1","State":"exited","Labels":"infracon.managed=true,infracon.project=busy,infracon.deployment=1"}`,
+		"busy-2":   `{"Names":"busy-2","Image":"busy:2","State":"created","Labels":"infracon.managed=true,infracon.project=busy,infracon.deployment=2"}`,
+		"gone-1":   `{"Names":"gone-1","Image":"gone:1","State":"running","Labels":"infracon.managed=true,infracon.project=gone,infracon.deployment=1"}`,
+		"postgres": `{"Names":"postgres","Image":"postgres:16","State":"running","Labels":""}`,
+	}
+	var lines []string
+	for name, line := range ps {
+		setContainer(t, dir, name, "exited")
+		lines = append(lines, line)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ps"), []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := beginMaintenance("busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	report, err := Reconcile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// api's stopped container is started again.
+	if containerState(dir, "api-1") != "running" {
+		t.Errorf("api-1 is %q", containerState(dir, "api-1"))
+	}
+	// web's recorded container is gone; the newest running release is adopted.
+	web, err := store.Projects().Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *web.ContainerName != "web-3" || *web.CurrentImage != "web:3" || *web.Status != "running" {
+		t.Errorf("web: container %s, image %s, status %s", *web.ContainerName, *web.CurrentImage, *web.Status)
+	}
+	// Leftovers of web and of a deleted project are removed, busy's and unmanaged ones aren't.
+	slices.Sort(report.OrphanContainers)
+	if want := []string{"gone-1", "web-2"}; !slices.Equal(report.OrphanContainers, want) {
+		t.Errorf("orphans: got %v, want %v", report.OrphanContainers, want)
+	}
+	for name, want := range map[string]string{"gone-1": "", "web-2": "", "busy-1": "exited", "busy-2": "exited", "postgres": "exited"} {
+		if got := containerState(dir, name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	for _, a := range report.Actions {
+		if a.ProjectSlug == "busy" {
+			t.Errorf("busy project was reconciled: %+v", a)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/utils"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetProjectResources(c *gin.Context) {
+	slug := c.Param("slug")
+
+	resources, err := db.StoreFrom(c).Resources().Get(slug)
+	if err != nil {
+		log.Printf("project resources query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	host, err := utils.GetHostCapacity()
+	if err != nil {
+		log.Printf("host capacity error: %s", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"resources": resources,
+			"host":      host,
+		},
+	})
+}
+
+// SetProjectResources stores new limits and, when the project is running, restarts it
+// through a health-checked cutover so the limits take effect.
+func SetProjectResources(c *gin.Context) {
+	var body SetResourcesPayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid payload",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	project, err := db.StoreFrom(c).Projects().Get(c.Param("slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Project not found!",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("project lookup query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	host, err := utils.GetHostCapacity()
+	if err != nil {
+		log.Printf("host capacity error: %s", err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"message": "Could not determine host capacity",
+			"status":  false,
+		})
+		return
+	}
+
+	if err := validateResources(body, host); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	before, err := db.StoreFrom(c).Resources().Get(project.Slug)
+	if err != nil {
+		log.Printf("project resources query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	resources := utils.ProjectResources{
+		ProjectSlug:   project.Slug,
+		MemoryMB:      body.MemoryMB,
+		CPUs:          body.CPUs,
+		PidsLimit:     body.PidsLimit,
+		RestartPolicy: body.RestartPolicy,
+		MaxRetries:    body.MaxRetries,
+	}
+
+	if err := db.StoreFrom(c).Resources().Set(resources); err != nil {
+		log.Printf("project resources update error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.resources.update", "project", project.Slug, before, resources)
+
+	if *before == resources || project.ContainerName == nil || *project.ContainerName == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  true,
+			"message": "Resources updated",
+			"data": gin.H{
+				"resources": resources,
+			},
+		})
+		return
+	}
+
+	deploymentId, err := Restart(db.StoreFrom(c), project, "resource limits changed")
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Resources saved, but the project could not be restarted: " + err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  true,
+		"message": "Resources updated, restarting project",
+		"data": gin.H{
+			"resources":     resources,
+			"deployment_id": deploymentId,
+		},
+	})
+}
+
+func validateResources(body SetResourcesPayload, host *utils.HostCapacity) error {
+	if err := utils.StringValidator("restart_policy", body.RestartPolicy, utils.ValidatorConfig{
+		ExpectedValues: []string{"no", "on-failure", "always", "unless-stopped"},
+	}); err != nil {
+		return err
+	}
+
+	if body.MaxRetries > 0 && body.RestartPolicy != "on-failure" {
+		return errors.New("`max_retries` only applies to the on-failure restart policy")
+	}
+
+	// Docker refuses memory limits below 6MB.
+	if body.MemoryMB != 0 && (body.MemoryMB < 6 || int64(body.MemoryMB) > host.MemoryMB) {
+		return fmt.Errorf("`memory_mb` must be between 6 and %d", host.MemoryMB)
+	}
+
+	if body.CPUs != 0 && (body.CPUs < 0.01 || body.CPUs > float64(host.CPUs)) {
+		return fmt.Errorf("`cpus` must be between 0.01 and %d", host.CPUs)
+	}
+
+	if body.PidsLimit != 0 && body.PidsLimit < 8 {
+		return errors.New("`pids_limit` must be at least 8")
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package project
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/db"
+	"infracon/logstore"
+	"infracon/utils"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type runtimeLogLine struct {
+	stream string
+	line   string
+}
+
+// StreamRuntimeLogs tails the project's current container over SSE. With follow
+// enabled it keeps going across redeploys by switching to whichever container
+// the project points at once the old one goes away.
+func StreamRuntimeLogs(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, map[string]any{
+			"status":  false,
+			"message": "SSE not supported",
+		})
+		return
+	}
+
+	slug := c.Param("slug")
+	since := c.Query("since")
+	tail := c.DefaultQuery("tail", "100")
+	follow := c.DefaultQuery("follow", "true") == "true"
+	stream := c.DefaultQuery("stream", "all")
+
+	stopHeartbeat := utils.StartSSEHeartbeat(c, flusher)
+	defer stopHeartbeat()
+
+	writeError := func(message string) {
+		utils.StreamSSEError(message, c, flusher)
+	}
+
+	if err := utils.StringValidator("stream", stream, utils.ValidatorConfig{
+		ExpectedValues: []string{"all", "stdout", "stderr"},
+	}); err != nil {
+		writeError(err.Error())
+		return
+	}
+
+	if n, err := strconv.Atoi(tail); tail != "all" && (err != nil || n < 0) {
+		writeError("`tail` must be a non-negative integer or \"all\"")
+		return
+	}
+
+	if since != "" {
+		if _, err := time.Parse(time.RFC3339, since); err != nil {
+			if _, err := time.ParseDuration(since); err != nil {
+				writeError("`since` must be an RFC3339 timestamp or a duration such as 10m")
+				return
+			}
+		}
+	}
+
+	project, err := db.StoreFrom(c).Projects().Get(slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError("Project not found")
+		} else {
+			writeError(err.Error())
+		}
+		return
+	}
+
+	if project.ContainerName == nil || *project.ContainerName == "" {
+		writeError("Project has no running container")
+		return
+	}
+
+	ctx := c.Request.Context()
+	containerName := *project.ContainerName
+
+	for {
+		args := []string{"logs", "--timestamps"}
+		if since != "" {
+			args = append(args, "--since", since)
+		}
+		if tail != "" {
+			args = append(args, "--tail", tail)
+		}
+		if follow {
+			args = append(args, "--follow")
+		}
+		args = append(args, containerName)
+
+		if err := streamContainerLogs(ctx, args, stream, c, flusher); err != nil && ctx.Err() == nil {
+			writeError(fmt.Sprintf("Error reading logs of %s: %s", containerName, err))
+		}
+
+		if !follow || ctx.Err() != nil {
+			return
+		}
+
+		next, err := waitForContainerSwitch(ctx, db.StoreFrom(c), slug, containerName)
+		if err != nil {
+			if ctx.Err() == nil {
+				writeError(err.Error())
+			}
+			return
+		}
+
+		utils.StreamSSEEvent(utils.SSEEvent{
+			Type:      "status",
+			Level:     "info",
+			Message:   fmt.Sprintf("Switched to container %s", next),
+			Container: next,
+			Timestamp: time.Now(),
+		}, c, flusher)
+
+		// A replacement container's output is all new, so read it from the start.
+		containerName = next
+		since = ""
+		tail = ""
+	}
+}
+
+func streamContainerLogs(ctx context.Context, args []string, stream string, c *gin.Context, flusher http.Flusher) error {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	lines := make(chan runtimeLogLine)
+	var readers sync.WaitGroup
+	for name, pipe := range map[string]io.Reader{"STDOUT": stdout, "STDERR": stderr} {
+		readers.Add(1)
+		go func(name string, r io.Reader) {
+			defer readers.Done()
+			utils.ReadLines(r, utils.MaxLogLineBytes, func(line string) {
+				lines <- runtimeLogLine{stream: name, line: line}
+			})
+		}(name, pipe)
+	}
+
+	go func() {
+		readers.Wait()
+		close(lines)
+	}()
+
+	for l := range lines {
+		if stream != "all" && !strings.EqualFold(stream, l.stream) {
+			continue
+		}
+
+		timestamp, message := splitDockerTimestamp(l.line)
+		utils.StreamSSEEvent(utils.SSEEvent{
+			Type:      "log",
+			Level:     logstore.DetectLevel(message),
+			Message:   message,
+			Stream:    strings.ToLower(l.stream),
+			Timestamp: timestamp,
+		}, c, flusher)
+	}
+
+	return cmd.Wait()
+}
+
+func waitForContainerSwitch(ctx context.Context, store db.Store, slug, current string) (string, error) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+
+		project, err := store.Projects().Get(slug)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return "", errors.New("Project was deleted")
+			}
+			continue
+		}
+
+		if project.ContainerName != nil && *project.ContainerName != "" && *project.ContainerName != current {
+			return *project.ContainerName, nil
+		}
+	}
+}
+
+// splitDockerTimestamp separates the RFC3339Nano prefix added by `docker logs --timestamps`.
+func splitDockerTimestamp(line string) (time.Time, string) {
+	prefix, rest, found := strings.Cut(line, " ")
+	if !found {
+		return time.Now(), line
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, prefix)
+	if err != nil {
+		return time.Now(), line
+	}
+
+	return t, rest
+}
@@ -0,0 +1,298 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"infracon/audit"
+	"infracon/db"
+	"infracon/disk"
+	"infracon/utils"
+	"log"
+	"net/http"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var volumeNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,62}$`)
+
+// volumeMountArgs creates the project's volumes that don't exist yet and returns the
+// `docker run` flags mounting all of them. Existing volumes are reused as they are, so
+// data survives redeploys, cutovers and rollbacks.
+func volumeMountArgs(store db.Store, slug string, deploymentId int, c *gin.Context, f http.Flusher) ([]string, error) {
+	volumes, err := store.Volumes().List(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	for _, v := range volumes {
+		if err := EnsureVolume(v, deploymentId, c, f); err != nil {
+			return nil, err
+		}
+		args = append(args, "--mount", fmt.Sprintf("type=volume,source=%s,target=%s", v.DockerName, v.MountPath))
+	}
+	return args, nil
+}
+
+// EnsureVolume creates the docker volume backing v unless it already exists.
+func EnsureVolume(v utils.ProjectVolume, deploymentId int, c *gin.Context, f http.Flusher) error {
+	if err := exec.Command("docker", "volume", "inspect", v.DockerName).Run(); err == nil {
+		return nil
+	}
+
+	utils.WriteSSEData(v.ProjectSlug, deploymentId, []string{"INFO", fmt.Sprintf("Creating volume %s", v.DockerName)}, c, f)
+	createArgs := append([]string{"volume", "create"}, utils.DockerLabelArgs(utils.ResourceLabels{ProjectSlug: v.ProjectSlug})...)
+	createArgs = append(createArgs, v.DockerName)
+	if err := utils.ExecCommandAndStreamViaSSE(v.ProjectSlug, deploymentId, "RUN", exec.Command("docker", createArgs...), c, f); err != nil {
+		return fmt.Errorf("could not create volume %s: %w", v.DockerName, err)
+	}
+	return nil
+}
+
+func GetVolumes(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	volumes, err := db.StoreFrom(c).Volumes().List(project.Slug)
+	if err != nil {
+		log.Printf("volumes query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	sizes, err := disk.VolumeSizes()
+	if err != nil {
+		log.Printf("volume sizes error: %s", err)
+	}
+
+	statuses := []VolumeStatus{}
+	for _, v := range volumes {
+		status := VolumeStatus{ProjectVolume: v}
+		if size, ok := sizes[v.DockerName]; ok {
+			status.Exists = true
+			status.SizeBytes = &size
+		}
+		statuses = append(statuses, status)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data": gin.H{
+			"volumes": statuses,
+		},
+	})
+}
+
+// CreateVolume declares a volume and, when the project is running, restarts it so the
+// volume gets mounted.
+func CreateVolume(c *gin.Context) {
+	var body CreateVolumePayload
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid payload",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	mountPath := path.Clean(body.MountPath)
+	if !volumeNameRegex.MatchString(body.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "`name` must be lowercase letters, digits, '.', '_' or '-'",
+			"status":  false,
+		})
+		return
+	}
+	if !path.IsAbs(mountPath) || mountPath == "/" || strings.ContainsAny(mountPath, ",") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "`mount_path` must be an absolute path other than /",
+			"status":  false,
+		})
+		return
+	}
+
+	existing, err := db.StoreFrom(c).Volumes().List(project.Slug)
+	if err != nil {
+		log.Printf("volumes query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	for _, v := range existing {
+		if v.Name == body.Name || v.MountPath == mountPath {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "The project already has a volume with this name or mount path",
+				"status":  false,
+			})
+			return
+		}
+	}
+
+	volume := utils.ProjectVolume{
+		ProjectSlug: project.Slug,
+		Name:        body.Name,
+		MountPath:   mountPath,
+		DockerName:  fmt.Sprintf("infracon-%s-%s", project.Slug, body.Name),
+	}
+
+	id, err := db.StoreFrom(c).Volumes().Create(volume)
+	if err != nil {
+		log.Printf("volume insert error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	volume.ID = id
+	audit.Record(c, "project.volume.create", "project", project.Slug, nil, volume)
+
+	if project.ContainerName == nil || *project.ContainerName == "" {
+		c.JSON(http.StatusCreated, gin.H{
+			"status":  true,
+			"message": "Volume created, it will be mounted on the next deploy",
+			"data": gin.H{
+				"volume": volume,
+			},
+		})
+		return
+	}
+
+	deploymentId, err := Restart(db.StoreFrom(c), project, fmt.Sprintf("volume %s added", volume.Name))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Volume saved, but the project could not be restarted: " + err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  true,
+		"message": "Volume created, restarting project",
+		"data": gin.H{
+			"volume":        volume,
+			"deployment_id": deploymentId,
+		},
+	})
+}
+
+// DeleteVolume detaches a volume from the project. Its data is kept unless
+// ?purge=true, in which case the docker volume is removed once no container uses it.
+func DeleteVolume(c *gin.Context) {
+	project, ok := lookupProject(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid volume id",
+			"status":  false,
+		})
+		return
+	}
+
+	volume, err := db.StoreFrom(c).Volumes().Get(project.Slug, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Volume not found",
+				"status":  false,
+			})
+			return
+		}
+		log.Printf("volume query error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+
+	purge := c.Query("purge") == "true"
+	if err := db.StoreFrom(c).Volumes().Delete(project.Slug, id); err != nil {
+		log.Printf("volume delete error: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Something went wrong",
+			"status":  false,
+		})
+		return
+	}
+	audit.Record(c, "project.volume.delete", "project", project.Slug, volume, gin.H{"purge": purge})
+
+	if project.ContainerName == nil || *project.ContainerName == "" {
+		if purge {
+			if err := removeVolume(volume.DockerName); err != nil {
+				c.JSON(http.StatusConflict, gin.H{
+					"message": "Volume detached, but its data could not be removed: " + err.Error(),
+					"status":  false,
+				})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Volume deleted",
+			"status":  true,
+		})
+		return
+	}
+
+	var then func(error)
+	if purge {
+		then = func(err error) {
+			if err != nil {
+				log.Printf("volume %s kept because the restart of %s failed", volume.DockerName, project.Slug)
+				return
+			}
+			if err := removeVolume(volume.DockerName); err != nil {
+				log.Printf("volume %s removal error: %s", volume.DockerName, err)
+			}
+		}
+	}
+
+	deploymentId, err := restart(db.StoreFrom(c), project, fmt.Sprintf("volume %s removed", volume.Name), then)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Volume detached, but the project could not be restarted: " + err.Error(),
+			"status":  false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  true,
+		"message": "Volume detached, restarting project",
+		"data": gin.H{
+			"deployment_id": deploymentId,
+		},
+	})
+}
+
+func removeVolume(dockerName string) error {
+	output, err := exec.Command("docker", "volume", "rm", dockerName).CombinedOutput()
+	if err != nil && !strings.Contains(string(output), "no such volume") {
+		return errors.New(strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A hand rolled subset of the Prometheus client: counters, gauges, histograms and
+// gauges computed at scrape time, written in the text exposition format.
+
+var (
+	registryMu sync.Mutex
+	registry   []*Family
+
+	DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	LongBuckets    = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800}
+)
+
+func register(f *Family) *Family {
+	f.series = map[string]*series{}
+	if len(f.labels) == 0 && f.collect == nil {
+		f.get(nil)
+	}
+	registryMu.Lock()
+	registry = append(registry, f)
+	registryMu.Unlock()
+	return f
+}
+
+func NewCounter(name, help string, labels ...string) *Family {
+	return register(&Family{name: name, help: help, kind: "counter", labels: labels})
+}
+
+func NewGauge(name, help string, labels ...string) *Family {
+	return register(&Family{name: name, help: help, kind: "gauge", labels: labels})
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Family {
+	return register(&Family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})
+}
+
+// NewGaugeFunc registers a gauge whose values are produced by collect on every scrape.
+func NewGaugeFunc(name, help string, labels []string, collect func(emit func(value float64, labels ...string))) *Family {
+	return register(&Family{name: name, help: help, kind: "gauge", labels: labels, collect: collect})
+}
+
+func (f *Family) get(labels []string) *series {
+	if len(labels) != len(f.labels) {
+		panic(fmt.Sprintf("telemetry: %s expects %d labels, got %d", f.name, len(f.labels), len(labels)))
+	}
+
+	key := strings.Join(labels, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labels...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+		f.order = append(f.order, key)
+	}
+	return s
+}
+
+func (f *Family) Add(v float64, labels ...string) {
+	f.mu.Lock()
+	f.get(labels).value += v
+	f.mu.Unlock()
+}
+
+func (f *Family) Inc(labels ...string) {
+	f.Add(1, labels...)
+}
+
+func (f *Family) Dec(labels ...string) {
+	f.Add(-1, labels...)
+}
+
+func (f *Family) Set(v float64, labels ...string) {
+	f.mu.Lock()
+	f.get(labels).value = v
+	f.mu.Unlock()
+}
+
+func (f *Family) Observe(v float64, labels ...string) {
+	f.mu.Lock()
+	s := f.get(labels)
+	for i, upper := range f.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+	f.mu.Unlock()
+}
+
+// Write renders every registered family.
+func Write(w io.Writer) {
+	registryMu.Lock()
+	families := append([]*Family(nil), registry...)
+	registryMu.Unlock()
+
+	sort.SliceStable(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	for _, f := range families {
+		f.write(w)
+	}
+}
+
+func (f *Family) write(w io.Writer) {
+	var rows []*series
+	if f.collect != nil {
+		f.collect(func(value float64, labels ...string) {
+			rows = append(rows, &series{labels: labels, value: value})
+		})
+	} else {
+		f.mu.Lock()
+		for _, key := range f.order {
+			s := *f.series[key]
+			s.counts = append([]uint64(nil), s.counts...)
+			rows = append(rows, &s)
+		}
+		f.mu.Unlock()
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	for _, s := range rows {
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelSet(f.labels, s.labels, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		for i, upper := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelSet(f.labels, s.labels, "le", formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelSet(f.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelSet(f.labels, s.labels, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelSet(f.labels, s.labels, "", ""), s.count)
+	}
+}
+
+func labelSet(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name+`="`+escapeLabel(value)+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
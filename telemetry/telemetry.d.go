@@ -0,0 +1,25 @@
+package telemetry
+
+import "sync"
+
+// Family is one metric with all of its label combinations.
+type Family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	collect func(emit func(value float64, labels ...string))
+
+	mu     sync.Mutex
+	series map[string]*series
+	order  []string
+}
+
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
@@ -0,0 +1,106 @@
+package telemetry
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	DeploymentsTotal = NewCounter(
+		"infracon_deployments_total",
+		"Finished deployments by kind and outcome.",
+		"kind", "outcome",
+	)
+	DeploymentDuration = NewHistogram(
+		"infracon_deployment_duration_seconds",
+		"Time from deployment start to its outcome.",
+		LongBuckets,
+		"kind", "outcome",
+	)
+	PhaseDuration = NewHistogram(
+		"infracon_build_phase_duration_seconds",
+		"Time spent in each pipeline phase.",
+		LongBuckets,
+		"phase", "outcome",
+	)
+	SSEStreams = NewGauge(
+		"infracon_sse_streams_active",
+		"Server-sent event streams currently open.",
+	)
+	HTTPDuration = NewHistogram(
+		"infracon_http_request_duration_seconds",
+		"HTTP request latency by route.",
+		DefaultBuckets,
+		"method", "route", "status",
+	)
+	GithubAPIErrors = NewCounter(
+		"infracon_github_api_errors_total",
+		"Failed calls to the GitHub API by operation.",
+		"operation",
+	)
+	ContainerIncidents = NewCounter(
+		"infracon_container_incidents_total",
+		"Crashes and OOM kills of project containers by kind.",
+		"kind",
+	)
+	Backups = NewCounter(
+		"infracon_backups_total",
+		"Volume snapshots and restores by operation and outcome.",
+		"operation", "outcome",
+	)
+)
+
+// ObservePhase records how long a pipeline phase that began at start took.
+func ObservePhase(phase string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	PhaseDuration.Observe(time.Since(start).Seconds(), phase, outcome)
+}
+
+// Middleware times every request under its route pattern, so path parameters don't
+// explode the label set. Unmatched requests share one "unmatched" route.
+func Middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	HTTPDuration.Observe(time.Since(start).Seconds(), c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+}
+
+// Handler serves the exposition format to callers presenting METRICS_SCRAPE_TOKEN.
+// Without a configured token the endpoint does not exist.
+func Handler(c *gin.Context) {
+	token := os.Getenv("METRICS_SCRAPE_TOKEN")
+	if token == "" {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Metrics endpoint is disabled",
+			"status":  false,
+		})
+		return
+	}
+
+	provided, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid scrape token",
+			"status":  false,
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	Write(c.Writer)
+}
@@ -18,17 +18,30 @@ type DockerImage struct {
 		Entrypoint   []string            `json:"Entrypoint"`
 		WorkingDir   string              `json:"WorkingDir"`
 		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		Labels       map[string]string   `json:"Labels"`
 	} `json:"Config"`
+	Size int64 `json:"Size"`
 }
 
 type DockerContainer struct {
-	ID    string `json:"Id"`
-	Name  string `json:"Name"`
-	Image string `json:"Image"`
+	ID           string `json:"Id"`
+	Name         string `json:"Name"`
+	Image        string `json:"Image"`
+	RestartCount int    `json:"RestartCount"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
 	State struct {
-		Status  string `json:"Status"`
-		Running bool   `json:"Running"`
-		Pid     int    `json:"Pid"`
+		Status     string    `json:"Status"`
+		Running    bool      `json:"Running"`
+		Restarting bool      `json:"Restarting"`
+		OOMKilled  bool      `json:"OOMKilled"`
+		Pid        int       `json:"Pid"`
+		ExitCode   int       `json:"ExitCode"`
+		StartedAt  time.Time `json:"StartedAt"`
+		Health     *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
 	} `json:"State"`
 	NetworkSettings struct {
 		Networks map[string]struct {
@@ -61,8 +74,304 @@ type Project struct {
 }
 
 type ProjectImage struct {
-	ID        int       `json:"id"`
-	ProjectID int       `json:"project_id"`
-	ImageTag  string    `json:"image_tag"`
-	CreatedAt time.Time `json:"created_at"`
+	ID           int       `json:"id"`
+	ProjectSlug  string    `json:"project_slug"`
+	ImageTag     string    `json:"image_tag"`
+	CommitSha    *string   `json:"commit_sha"`
+	DeploymentID *int      `json:"deployment_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type Deployment struct {
+	ID            int        `json:"id" db:"id"`
+	ProjectSlug   string     `json:"project_slug" db:"project_slug"`
+	Kind          string     `json:"kind" db:"kind"`
+	Source        string     `json:"source" db:"source"`
+	ImageTag      string     `json:"image_tag" db:"image_tag"`
+	ContainerName string     `json:"container_name" db:"container_name"`
+	CommitSha     *string    `json:"commit_sha" db:"commit_sha"`
+	PreviousImage *string    `json:"previous_image" db:"previous_image"`
+	Status        string     `json:"status" db:"status"`
+	Message       string     `json:"message" db:"message"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at" db:"finished_at"`
+}
+
+type AuthEvent struct {
+	ID        int       `json:"id" db:"id"`
+	EventType string    `json:"event_type" db:"event_type"`
+	UserID    *int      `json:"user_id" db:"user_id"`
+	Email     string    `json:"email" db:"email"`
+	IP        string    `json:"ip" db:"ip"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	Success   bool      `json:"success" db:"success"`
+	Details   string    `json:"details" db:"details"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type AuthEventFilter struct {
+	EventType string
+	Email     string
+	IP        string
+	Since     *time.Time
+	Until     *time.Time
+	Limit     int
+	Offset    int
+}
+
+type AuditLog struct {
+	ID         int       `json:"id" db:"id"`
+	ActorID    *int      `json:"actor_id" db:"actor_id"`
+	ActorEmail string    `json:"actor_email" db:"actor_email"`
+	IP         string    `json:"ip" db:"ip"`
+	Action     string    `json:"action" db:"action"`
+	TargetType string    `json:"target_type" db:"target_type"`
+	Target     string    `json:"target" db:"target"`
+	Before     string    `json:"before" db:"before"`
+	After      string    `json:"after" db:"after"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+type AuditLogFilter struct {
+	ActorID    *int
+	Action     string
+	TargetType string
+	Target     string
+	Since      *time.Time
+	Until      *time.Time
+	// BeforeID keeps only entries older than that id, for keyset paging.
+	BeforeID int
+	Limit    int
+	Offset   int
+}
+
+type LogRetentionPolicy struct {
+	ProjectSlug string `json:"project_slug" db:"project_slug"`
+	MaxAgeDays  int    `json:"max_age_days" db:"max_age_days"`
+	MaxSizeMB   int    `json:"max_size_mb" db:"max_size_mb"`
+}
+
+type LogRecord struct {
+	ProjectSlug  string    `json:"project_slug"`
+	Source       string    `json:"source"`
+	DeploymentID int       `json:"deployment_id,omitempty"`
+	Seq          int       `json:"seq,omitempty"`
+	Timestamp    time.Time `json:"ts"`
+	Kind         string    `json:"kind,omitempty"`
+	Phase        string    `json:"phase,omitempty"`
+	Level        string    `json:"level"`
+	Stream       string    `json:"stream,omitempty"`
+	Container    string    `json:"container,omitempty"`
+	Message      string    `json:"message"`
+}
+
+// SSEEvent is the JSON payload of every server-sent event. Build events carry the
+// deployment's log sequence number as their SSE id.
+type SSEEvent struct {
+	ID           int       `json:"id,omitempty"`
+	Type         string    `json:"type"`
+	DeploymentID int       `json:"deployment_id,omitempty"`
+	Phase        string    `json:"phase,omitempty"`
+	Level        string    `json:"level"`
+	Message      string    `json:"message"`
+	Stream       string    `json:"stream,omitempty"`
+	Container    string    `json:"container,omitempty"`
+	ProjectSlug  string    `json:"project_slug,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	ExitCode     *int      `json:"exit_code,omitempty"`
+	Timestamp    time.Time `json:"ts"`
+}
+
+type LogDrain struct {
+	ID          int       `json:"id" db:"id"`
+	ProjectSlug string    `json:"project_slug" db:"project_slug"`
+	Name        string    `json:"name" db:"name"`
+	Kind        string    `json:"kind" db:"kind"`
+	Endpoint    string    `json:"endpoint" db:"endpoint"`
+	Protocol    string    `json:"protocol" db:"protocol"`
+	Token       string    `json:"-" db:"token"`
+	Sources     string    `json:"sources" db:"sources"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type DeploymentLog struct {
+	DeploymentID int       `json:"deployment_id" db:"deployment_id"`
+	Seq          int       `json:"seq" db:"seq"`
+	Kind         string    `json:"kind" db:"kind"`
+	Phase        string    `json:"phase" db:"phase"`
+	Message      string    `json:"message" db:"message"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+type MetricPoint struct {
+	ProjectSlug string    `json:"-" db:"project_slug"`
+	Resolution  string    `json:"-" db:"resolution"`
+	Bucket      time.Time `json:"bucket" db:"bucket"`
+	Samples     int       `json:"samples" db:"samples"`
+	CPUAvg      float64   `json:"cpu_avg" db:"cpu_avg"`
+	CPUMax      float64   `json:"cpu_max" db:"cpu_max"`
+	MemAvg      int64     `json:"mem_avg" db:"mem_avg"`
+	MemMax      int64     `json:"mem_max" db:"mem_max"`
+	MemLimit    int64     `json:"mem_limit" db:"mem_limit"`
+	NetRx       int64     `json:"net_rx" db:"net_rx"`
+	NetTx       int64     `json:"net_tx" db:"net_tx"`
+	BlockRead   int64     `json:"block_read" db:"block_read"`
+	BlockWrite  int64     `json:"block_write" db:"block_write"`
+}
+
+// ProjectResources are the limits applied to every container of a project. Zero means unlimited.
+type ProjectResources struct {
+	ProjectSlug   string  `json:"project_slug" db:"project_slug"`
+	MemoryMB      int     `json:"memory_mb" db:"memory_mb"`
+	CPUs          float64 `json:"cpus" db:"cpus"`
+	PidsLimit     int     `json:"pids_limit" db:"pids_limit"`
+	RestartPolicy string  `json:"restart_policy" db:"restart_policy"`
+	MaxRetries    int     `json:"max_retries" db:"max_retries"`
+}
+
+type HostCapacity struct {
+	CPUs     int   `json:"cpus"`
+	MemoryMB int64 `json:"memory_mb"`
+}
+
+// ResourceLabels identify what infracon created a docker resource for.
+type ResourceLabels struct {
+	ProjectSlug  string  `json:"project_slug"`
+	DeploymentID int     `json:"deployment_id,omitempty"`
+	CommitSha    *string `json:"commit_sha,omitempty"`
+}
+
+type ReconcileAction struct {
+	ProjectSlug string `json:"project_slug,omitempty"`
+	Action      string `json:"action"`
+	Detail      string `json:"detail"`
+}
+
+// ReconcileReport describes one comparison of infracon.db with the docker daemon.
+type ReconcileReport struct {
+	StartedAt        time.Time         `json:"started_at"`
+	FinishedAt       time.Time         `json:"finished_at"`
+	Actions          []ReconcileAction `json:"actions"`
+	OrphanContainers []string          `json:"orphan_containers"`
+	OrphanImages     []string          `json:"orphan_images"`
+	Removed          []string          `json:"removed"`
+}
+
+// Incident is a crash or OOM kill of a project's current container.
+type Incident struct {
+	ID            int       `json:"id" db:"id"`
+	ProjectSlug   string    `json:"project_slug" db:"project_slug"`
+	ContainerName string    `json:"container_name" db:"container_name"`
+	Kind          string    `json:"kind" db:"kind"`
+	ExitCode      *int      `json:"exit_code" db:"exit_code"`
+	Message       string    `json:"message" db:"message"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+type ImageRetentionPolicy struct {
+	ProjectSlug string `json:"project_slug" db:"project_slug"`
+	KeepLast    int    `json:"keep_last" db:"keep_last"`
+	KeepDays    int    `json:"keep_days" db:"keep_days"`
+}
+
+// GCReport describes one garbage collection of images, source directories and build cache.
+// ReclaimedBytes is the image space docker reports freed plus the size of the removed
+// directories.
+type GCReport struct {
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	RemovedImages   []string  `json:"removed_images"`
+	RemovedDirs     []string  `json:"removed_dirs"`
+	Errors          []string  `json:"errors"`
+	ReclaimedBytes  int64     `json:"reclaimed_bytes"`
+	BuildCacheBytes int64     `json:"build_cache_bytes"`
+}
+
+// ProjectVolume is a named docker volume mounted into every container of the project.
+type ProjectVolume struct {
+	ID          int       `json:"id" db:"id"`
+	ProjectSlug string    `json:"project_slug" db:"project_slug"`
+	Name        string    `json:"name" db:"name"`
+	MountPath   string    `json:"mount_path" db:"mount_path"`
+	DockerName  string    `json:"docker_name" db:"docker_name"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// BackupTarget is where snapshots of a project's volumes are stored: a local directory
+// (Kind "local", Path) or an S3-compatible bucket (Kind "s3").
+type BackupTarget struct {
+	ID          int       `json:"id" db:"id"`
+	ProjectSlug string    `json:"project_slug" db:"project_slug"`
+	Name        string    `json:"name" db:"name"`
+	Kind        string    `json:"kind" db:"kind"`
+	Path        string    `json:"path" db:"path"`
+	Endpoint    string    `json:"endpoint" db:"endpoint"`
+	Bucket      string    `json:"bucket" db:"bucket"`
+	Region      string    `json:"region" db:"region"`
+	Prefix      string    `json:"prefix" db:"prefix"`
+	AccessKey   string    `json:"access_key" db:"access_key"`
+	SecretKey   string    `json:"-" db:"secret_key"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// BackupPolicy schedules snapshots with a five-field cron expression and decides how
+// many of them are kept.
+type BackupPolicy struct {
+	ProjectSlug string `json:"project_slug" db:"project_slug"`
+	Enabled     bool   `json:"enabled" db:"enabled"`
+	Schedule    string `json:"schedule" db:"schedule"`
+	TargetID    *int   `json:"target_id" db:"target_id"`
+	KeepLast    int    `json:"keep_last" db:"keep_last"`
+	KeepDays    int    `json:"keep_days" db:"keep_days"`
+}
+
+type Snapshot struct {
+	ID          int            `json:"id" db:"id"`
+	ProjectSlug string         `json:"project_slug" db:"project_slug"`
+	TargetID    int            `json:"target_id" db:"target_id"`
+	Trigger     string         `json:"trigger" db:"trigger"`
+	Status      string         `json:"status" db:"status"`
+	SizeBytes   int64          `json:"size_bytes" db:"size_bytes"`
+	Message     string         `json:"message" db:"message"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+	FinishedAt  *time.Time     `json:"finished_at" db:"finished_at"`
+	Files       []SnapshotFile `json:"files"`
+}
+
+// SnapshotFile is the gzipped tarball of one volume, stored under Key in the target.
+type SnapshotFile struct {
+	SnapshotID int    `json:"snapshot_id" db:"snapshot_id"`
+	VolumeName string `json:"volume_name" db:"volume_name"`
+	Key        string `json:"key" db:"object_key"`
+	SizeBytes  int64  `json:"size_bytes" db:"size_bytes"`
+}
+
+// Addon is a managed database running as a project of type "addon" from an official
+// image. Its container is reachable as ProjectSlug on the add-on's private network.
+type Addon struct {
+	ProjectSlug string    `json:"project_slug" db:"project_slug"`
+	Engine      string    `json:"engine" db:"engine"`
+	Version     string    `json:"version" db:"version"`
+	Username    string    `json:"username" db:"username"`
+	Password    string    `json:"-" db:"password"`
+	Database    string    `json:"database" db:"database_name"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// ProjectLink connects a project to a service it depends on. EnvVar names the
+// variable the service's URL is injected as, empty when only the network is shared.
+type ProjectLink struct {
+	ProjectSlug string    `json:"project_slug" db:"project_slug"`
+	TargetSlug  string    `json:"target_slug" db:"target_slug"`
+	EnvVar      string    `json:"env_var" db:"env_var"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// MigrationStatus is a schema migration embedded in the binary, and when it was
+// applied to the database.
+type MigrationStatus struct {
+	Version   int        `json:"version" db:"version"`
+	Name      string     `json:"name" db:"name"`
+	Checksum  string     `json:"checksum" db:"checksum"`
+	AppliedAt *time.Time `json:"applied_at" db:"applied_at"`
 }
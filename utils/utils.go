@@ -4,18 +4,23 @@ import (
 	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"infracon/telemetry"
 	"io"
+	"io/fs"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,11 +35,20 @@ import (
 var (
 	nonAlphanumericRegex = regexp.MustCompile(`[^a-z0-9]+`)
 	multipleHyphensRegex = regexp.MustCompile(`-+`)
-	Logs                 map[string][]string
-	logsMu               sync.RWMutex
+	logHooks             []func(*LogRecord)
+	logHooksMu           sync.RWMutex
 )
 
-const InfraconLogSeparator = "[INFRACON-LOG-SEPARATOR]"
+const sseMutexKey = "infracon.sse.mutex"
+
+// Labels put on every container, image, network and volume infracon creates. They,
+// not resource names, tie daemon state back to projects and deployments.
+const (
+	ManagedLabel    = "infracon.managed"
+	ProjectLabel    = "infracon.project"
+	DeploymentLabel = "infracon.deployment"
+	CommitLabel     = "infracon.commit"
+)
 
 func Slugify(s string) string {
 	s = strings.ToLower(s)
@@ -215,72 +229,258 @@ func PathExists(p string) bool {
 	return !errors.Is(err, os.ErrNotExist)
 }
 
-func WriteSSEData(slug string, d []string, c *gin.Context, flusher http.Flusher) {
-	line := strings.Join(append([]string{strconv.Itoa(int(time.Now().UnixMilli()))}, d...), InfraconLogSeparator)
-	fmt.Fprintf(c.Writer, "data: %s\n\n", line)
-	flusher.Flush()
-	logsMu.Lock()
-	Logs[slug] = append(Logs[slug], line)
-	logsMu.Unlock()
+// WriteSSEData publishes a pipeline line. d[0] is its kind (BUILD, RUN, INFO, ERROR or
+// DONE) and the rest the message. Lines of a deployment carry its id, which the log hooks
+// use to persist and number them; pass 0 for output that belongs to no deployment. The
+// line then goes to the client when there is one; background jobs pass a nil context.
+func WriteSSEData(slug string, deploymentId int, d []string, c *gin.Context, flusher http.Flusher) {
+	kind, level, message := "", "info", ""
+	if len(d) > 0 {
+		kind = d[0]
+	}
+	if kind == "ERROR" {
+		level = "error"
+	}
+	if len(d) > 1 {
+		message = strings.Join(d[1:], " ")
+	}
+
+	r := LogRecord{
+		ProjectSlug:  slug,
+		Source:       "build",
+		DeploymentID: deploymentId,
+		Timestamp:    time.Now(),
+		Kind:         kind,
+		Level:        level,
+		Message:      message,
+	}
+	EmitLog(&r)
+
+	if c != nil {
+		StreamSSEEvent(LogRecordEvent(r), c, flusher)
+	}
 }
 
-func GetLogs(slug string) []string {
-	logsMu.RLock()
-	defer logsMu.Unlock()
-	return Logs[slug]
+// OnLog registers fn to receive every build and runtime log line. Hooks must not block;
+// they run in registration order and may annotate the record for the ones after them.
+func OnLog(fn func(*LogRecord)) {
+	logHooksMu.Lock()
+	logHooks = append(logHooks, fn)
+	logHooksMu.Unlock()
 }
 
-func DeleteLogs(slug string) {
-	logsMu.RLock()
-	defer logsMu.Unlock()
-	delete(Logs, slug)
+func EmitLog(r *LogRecord) {
+	logHooksMu.RLock()
+	defer logHooksMu.RUnlock()
+	for _, fn := range logHooks {
+		fn(r)
+	}
 }
 
-func ExecCommandAndStreamViaSSE(slug string, c *exec.Cmd, gc *gin.Context, f http.Flusher) {
-	if _, ok := gc.Writer.(http.Flusher); !ok {
+func LogRecordEvent(r LogRecord) SSEEvent {
+	e := SSEEvent{
+		ID:           r.Seq,
+		Type:         "log",
+		DeploymentID: r.DeploymentID,
+		Phase:        r.Phase,
+		Level:        r.Level,
+		Message:      r.Message,
+		Stream:       r.Stream,
+		Container:    r.Container,
+		Timestamp:    r.Timestamp,
+	}
+
+	if r.Source == "build" {
+		switch r.Kind {
+		case "BUILD":
+			e.Type = "build"
+		case "RUN":
+			e.Type = "run"
+		case "ERROR":
+			e.Type = "error"
+		case "DONE":
+			e.Type = "done"
+		default:
+			e.Type = "status"
+		}
+	}
+
+	return e
+}
+
+func sseMutex(c *gin.Context) *sync.Mutex {
+	if v, ok := c.Get(sseMutexKey); ok {
+		return v.(*sync.Mutex)
+	}
+	mu := &sync.Mutex{}
+	c.Set(sseMutexKey, mu)
+	return mu
+}
+
+func StreamSSEEvent(e SSEEvent, c *gin.Context, flusher http.Flusher) {
+	data, err := json.Marshal(e)
+	if err != nil {
 		return
 	}
 
-	println("sse function")
-	stdout, _ := c.StdoutPipe()
-	stderr, _ := c.StderrPipe()
+	mu := sseMutex(c)
+	mu.Lock()
+	defer mu.Unlock()
 
-	logChan := make(chan string)
-	done := make(chan bool)
+	if e.ID > 0 {
+		fmt.Fprintf(c.Writer, "id: %d\n", e.ID)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", e.Type, data)
+	flusher.Flush()
+}
+
+func StreamSSEError(message string, c *gin.Context, flusher http.Flusher) {
+	StreamSSEEvent(SSEEvent{
+		Type:      "error",
+		Level:     "error",
+		Message:   message,
+		Timestamp: time.Now(),
+	}, c, flusher)
+}
 
-	_ = c.Start()
+// StartSSEHeartbeat writes a comment every SSE_HEARTBEAT_INTERVAL so proxies keep idle
+// streams open. The returned func must be called before the handler returns.
+func StartSSEHeartbeat(c *gin.Context, flusher http.Flusher) func() {
+	mu := sseMutex(c)
+	interval := GetEnvDuration("SSE_HEARTBEAT_INTERVAL", 15*time.Second)
+	telemetry.SSEStreams.Inc()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
 
 	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			logChan <- scanner.Text()
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-c.Request.Context().Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				fmt.Fprint(c.Writer, ": heartbeat\n\n")
+				flusher.Flush()
+				mu.Unlock()
+			}
 		}
 	}()
 
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			fmt.Println("LOGS >>", scanner.Text())
-			logChan <- scanner.Text()
+	return func() {
+		close(stop)
+		<-stopped
+		telemetry.SSEStreams.Dec()
+	}
+}
+
+// MaxLogLineBytes caps a single line of command or container output.
+const MaxLogLineBytes = 64 * 1024
+
+// ReadLines calls fn with every line of r until EOF. A line longer than limit is cut
+// to limit bytes and marked, and reading carries on with the next line, so one huge
+// line never stops the pipe from being drained.
+func ReadLines(r io.Reader, limit int, fn func(line string)) error {
+	br := bufio.NewReader(r)
+	var line []byte
+	truncated := false
+	for {
+		chunk, isPrefix, err := br.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
 		}
-	}()
+
+		if !truncated {
+			if n := limit - len(line); n < len(chunk) {
+				// Cut on a rune boundary so the kept part stays valid UTF-8.
+				for n > 0 && !utf8.RuneStart(chunk[n]) {
+					n--
+				}
+				line = append(line, chunk[:n]...)
+				truncated = true
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if isPrefix {
+			continue
+		}
+
+		text := string(line)
+		if truncated {
+			text += " [truncated]"
+		}
+		fn(text)
+		line = line[:0]
+		truncated = false
+	}
+}
+
+// ExecCommandAndStreamViaSSE streams the command's output as lines of the given kind.
+// The command always runs to completion: if the client goes away its output only stops
+// being streamed and still reaches the log hooks.
+func ExecCommandAndStreamViaSSE(slug string, deploymentId int, kind string, c *exec.Cmd, gc *gin.Context, f http.Flusher) error {
+	ctx := context.Background()
+	if gc != nil {
+		if _, ok := gc.Writer.(http.Flusher); !ok {
+			return errors.New("SSE not supported")
+		}
+		ctx = gc.Request.Context()
+	}
+
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := c.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := c.Start(); err != nil {
+		WriteSSEData(slug, deploymentId, []string{"ERROR", fmt.Sprintf("Error starting %s: %s", c.Args[0], err)}, gc, f)
+		return err
+	}
+
+	logChan := make(chan string)
+
+	var readers sync.WaitGroup
+	for _, pipe := range []io.Reader{stdout, stderr} {
+		readers.Add(1)
+		go func(r io.Reader) {
+			defer readers.Done()
+			ReadLines(r, MaxLogLineBytes, func(line string) {
+				logChan <- line
+			})
+		}(pipe)
+	}
 
 	go func() {
-		c.Wait()
-		done <- true
+		readers.Wait()
+		close(logChan)
 	}()
 
 	for {
 		select {
-		case line := <-logChan:
-			WriteSSEData(slug, []string{"BUILD", line}, gc, f)
-
-		case <-done:
-			WriteSSEData(slug, []string{"BUILD", "BUILD finished"}, gc, f)
-			return
+		case line, ok := <-logChan:
+			if !ok {
+				// Wait must not run before the pipes are drained, or trailing output is lost.
+				err := c.Wait()
+				WriteSSEData(slug, deploymentId, []string{kind, kind + " finished"}, gc, f)
+				return err
+			}
+			WriteSSEData(slug, deploymentId, []string{kind, line}, gc, f)
 
-		case <-gc.Request.Context().Done():
-			return
+		case <-ctx.Done():
+			gc, f, ctx = nil, nil, context.Background()
 		}
 	}
 
@@ -324,11 +524,191 @@ func GetDeploymentStatusetDockerContainer(name string) (*DockerContainer, error)
 	return &containers[0], nil
 }
 
-func WriteEnvFile(destination, env string) (string, error) {
+// GetHostCapacity reports what the docker daemon can hand out to containers.
+func GetHostCapacity() (*HostCapacity, error) {
+	output, err := exec.Command("docker", "info", "--format", "{{.NCPU}} {{.MemTotal}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query docker info: %w", err)
+	}
+
+	var cpus int
+	var memTotal int64
+	if _, err := fmt.Sscan(string(output), &cpus, &memTotal); err != nil {
+		return nil, fmt.Errorf("failed to parse docker info output: %w", err)
+	}
+
+	return &HostCapacity{CPUs: cpus, MemoryMB: memTotal / (1 << 20)}, nil
+}
+
+var byteUnits = map[string]float64{
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+// ParseDockerSize reads the human readable sizes docker prints, e.g. "1.5MiB" or "648B".
+func ParseDockerSize(v string) (int64, error) {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "--" {
+		return 0, nil
+	}
+
+	i := strings.IndexFunc(v, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i <= 0 {
+		return 0, fmt.Errorf("unexpected size %q", v)
+	}
+
+	n, err := strconv.ParseFloat(v[:i], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(v[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %q", v)
+	}
+
+	return int64(math.Round(n * unit)), nil
+}
+
+// DockerLabelArgs returns the --label flags for `docker run`, `docker build`,
+// `docker network create` and `docker volume create`.
+func DockerLabelArgs(l ResourceLabels) []string {
+	args := []string{
+		"--label", ManagedLabel + "=true",
+		"--label", ProjectLabel + "=" + l.ProjectSlug,
+	}
+	if l.DeploymentID > 0 {
+		args = append(args, "--label", DeploymentLabel+"="+strconv.Itoa(l.DeploymentID))
+	}
+	if l.CommitSha != nil && *l.CommitSha != "" {
+		args = append(args, "--label", CommitLabel+"="+*l.CommitSha)
+	}
+	return args
+}
+
+// ParseResourceLabels reads infracon's labels back. ok is false for resources
+// infracon did not create.
+func ParseResourceLabels(labels map[string]string) (l ResourceLabels, ok bool) {
+	if labels[ManagedLabel] != "true" || labels[ProjectLabel] == "" {
+		return l, false
+	}
+
+	l.ProjectSlug = labels[ProjectLabel]
+	l.DeploymentID, _ = strconv.Atoi(labels[DeploymentLabel])
+	if sha := labels[CommitLabel]; sha != "" {
+		l.CommitSha = &sha
+	}
+	return l, true
+}
+
+// ParseLabelList splits the "k=v,k=v" label listing of `docker ps --format`.
+func ParseLabelList(s string) map[string]string {
+	labels := map[string]string{}
+	for _, label := range strings.Split(s, ",") {
+		if k, v, ok := strings.Cut(label, "="); ok {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// ListManagedImages inspects every image carrying infracon's labels.
+func ListManagedImages() ([]DockerImage, error) {
+	output, err := exec.Command("docker", "images", "-q", "--no-trunc", "--filter", "label="+ManagedLabel+"=true").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	ids := map[string]bool{}
+	args := []string{"image", "inspect"}
+	for _, id := range strings.Fields(string(output)) {
+		if !ids[id] {
+			ids[id] = true
+			args = append(args, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	output, err = exec.Command("docker", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect images: %w", err)
+	}
+
+	var images []DockerImage
+	if err := json.Unmarshal(output, &images); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return images, nil
+}
+
+// DockerResourceArgs turns project limits into `docker run` flags. Swap is capped at the
+// memory limit so a leaking app can't escape it by swapping.
+func DockerResourceArgs(r *ProjectResources) []string {
+	var args []string
+	if r.MemoryMB > 0 {
+		memory := strconv.Itoa(r.MemoryMB) + "m"
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+	if r.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(r.CPUs, 'f', -1, 64))
+	}
+	if r.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(r.PidsLimit))
+	}
+
+	policy := r.RestartPolicy
+	if policy == "on-failure" && r.MaxRetries > 0 {
+		policy += ":" + strconv.Itoa(r.MaxRetries)
+	}
+	if policy != "" {
+		args = append(args, "--restart", policy)
+	}
+
+	return args
+}
+
+// AppPort is the port projects are told to listen on through PORT.
+const AppPort = 3000
+
+// WriteEnvFile writes env to destination/.env along with PORT and the injected
+// variables, e.g. connection URLs of linked services. Variables set in env win over
+// injected ones.
+func WriteEnvFile(destination, env string, injected map[string]string) (string, error) {
 	if err := os.MkdirAll(destination, 0755); err != nil {
 		return "", err
 	}
-	merged := InjectPort(env, 3000)
+	merged := InjectPort(env, AppPort)
+
+	defined := map[string]bool{}
+	for _, line := range strings.Split(env, "\n") {
+		if key, _, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			defined[strings.TrimSpace(key)] = true
+		}
+	}
+	keys := make([]string, 0, len(injected))
+	for key := range injected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !defined[key] {
+			merged += fmt.Sprintf("\n%s=%s", key, injected[key])
+		}
+	}
+
 	envPath := filepath.Join(destination, ".env")
 	if err := os.WriteFile(envPath, []byte(merged), 0644); err != nil {
 		return "", err
@@ -358,16 +738,18 @@ func InjectPort(env string, port int) string {
 	return strings.Join(lines, "\n")
 }
 
-func PullFromGithub(p PullfromGithub) (commitHash string, err error) {
+// PullFromGithub downloads and extracts the repository archive into p.Destination.
+// rootDir is the single top-level directory GitHub wraps the archive in.
+func PullFromGithub(p PullfromGithub) (commitHash string, rootDir string, err error) {
 	if p.AccessToken == "" {
-		return "", errors.New("GITHUB_ACCESS_TOKEN is not set")
+		return "", "", errors.New("GITHUB_ACCESS_TOKEN is not set")
 	}
 
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/zipball/%s", p.Owner, p.Repo, p.Ref)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	req.Header.Set("Accept", "application/vnd.github+json")
@@ -377,32 +759,37 @@ func PullFromGithub(p PullfromGithub) (commitHash string, err error) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		telemetry.GithubAPIErrors.Inc("download_archive")
+		return "", "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
+		telemetry.GithubAPIErrors.Inc("download_archive")
 		var response map[string]string
 		json.NewDecoder(resp.Body).Decode(&response)
-		return "", fmt.Errorf("Github error: %s", response["message"])
+		return "", "", fmt.Errorf("Github error: %s", response["message"])
 	}
 
 	var buf bytes.Buffer
 	size, err := io.Copy(&buf, resp.Body)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), size)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	for _, f := range r.File {
 		fpath := filepath.Join(p.Destination, f.Name)
+		if rootDir == "" {
+			rootDir = strings.SplitN(f.Name, "/", 2)[0]
+		}
 
 		if !strings.HasPrefix(fpath, filepath.Clean(p.Destination)+string(os.PathSeparator)) {
-			return "", fmt.Errorf("illegal file path: %s", fpath)
+			return "", "", fmt.Errorf("illegal file path: %s", fpath)
 		}
 
 		if f.FileInfo().IsDir() {
@@ -411,27 +798,123 @@ func PullFromGithub(p PullfromGithub) (commitHash string, err error) {
 		}
 
 		if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return "", err
+			return "", "", err
 		}
 
 		inFile, err := f.Open()
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 
 		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
 		if err != nil {
 			inFile.Close()
-			return "", err
+			return "", "", err
 		}
 
 		_, err = io.Copy(outFile, inFile)
 		inFile.Close()
 		outFile.Close()
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 	}
 
-	return r.Comment, nil
+	return r.Comment, rootDir, nil
+}
+
+// GetEnvDuration reads a duration such as "90s" or a plain number of seconds from the environment.
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+
+	return fallback
+}
+
+// SendNotification posts an event to NOTIFICATION_WEBHOOK_URL. The payload carries a
+// "text" field so Slack and Discord compatible webhooks render it without an adapter.
+func SendNotification(event, text string, details map[string]any) error {
+	url := os.Getenv("NOTIFICATION_WEBHOOK_URL")
+	if url == "" {
+		return nil
+	}
+
+	payload := map[string]any{
+		"event":     event,
+		"text":      text,
+		"details":   details,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func GetEnv(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// ProjectSourceDirs lists the source directories of every release of the project.
+func ProjectSourceDirs(slug string) []string {
+	entries, err := os.ReadDir("infracon-apps")
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && belongsToProject(slug, entry.Name()) {
+			dirs = append(dirs, filepath.Join("infracon-apps", entry.Name()))
+		}
+	}
+	return dirs
+}
+
+var releaseSuffix = regexp.MustCompile(`^-[0-9]+$`)
+
+func belongsToProject(slug, name string) bool {
+	return name == slug || (strings.HasPrefix(name, slug) && releaseSuffix.MatchString(name[len(slug):]))
+}
+
+// DirSize adds up the size of every file below dir.
+func DirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
 }
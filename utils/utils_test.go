@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"context"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReadLinesTruncatesLongLines(t *testing.T) {
+	input := "short\n" + strings.Repeat("a", 10000) + "\n" + strings.Repeat("é", 20) + "\nlast"
+
+	var lines []string
+	if err := ReadLines(strings.NewReader(input), 25, func(line string) {
+		lines = append(lines, line)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"short",
+		strings.Repeat("a", 25) + " [truncated]",
+		strings.Repeat("é", 12) + " [truncated]",
+		"last",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %q", len(lines), len(want), lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d: got %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestExecCommandOutlivesTheRequest(t *testing.T) {
+	const deploymentId = 424242
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	OnLog(func(r *LogRecord) {
+		if r.DeploymentID == deploymentId {
+			mu.Lock()
+			lines = append(lines, r.Message)
+			mu.Unlock()
+		}
+	})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Request = httptest.NewRequest("POST", "/", nil).WithContext(ctx)
+
+	cmd := exec.Command("sh", "-c", "sleep 0.1; echo built")
+	if err := ExecCommandAndStreamViaSSE("web", deploymentId, "BUILD", cmd, c, w); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.ProcessState == nil || !cmd.ProcessState.Exited() {
+		t.Fatal("returned before the command exited")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(strings.Join(lines, "\n"), "built") {
+		t.Errorf("output after the disconnect was not logged: %q", lines)
+	}
+}